@@ -0,0 +1,159 @@
+package gomatrix
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// DirectContent is the content of the m.direct account data, mapping user IDs to the IDs of the direct chat
+// rooms with them - https://spec.matrix.org/v1.1/client-server-api/#mdirect
+type DirectContent map[string][]string
+
+// IgnoredUserListContent is the content of the m.ignored_user_list account data -
+// https://spec.matrix.org/v1.1/client-server-api/#mignored_user_list
+type IgnoredUserListContent struct {
+	IgnoredUsers map[string]struct{} `json:"ignored_users"`
+}
+
+// FullyReadContent is the content of the m.fully_read room account data -
+// https://spec.matrix.org/v1.1/client-server-api/#mfully_read
+type FullyReadContent struct {
+	EventID string `json:"event_id"`
+}
+
+// GetDirectChats returns the direct chats of the current user, by user ID. Returns an empty DirectContent if the
+// user has none.
+func (cli *Client) GetDirectChats(ctx context.Context) (DirectContent, error) {
+	content := DirectContent{}
+	err := cli.GetAccountData(ctx, "m.direct", &content)
+	if errors.Is(err, ErrNotFound) {
+		return content, nil
+	}
+	return content, err
+}
+
+// SetDirectChats replaces the direct chats of the current user.
+func (cli *Client) SetDirectChats(ctx context.Context, content DirectContent) error {
+	return cli.SetAccountData(ctx, "m.direct", content)
+}
+
+// GetIgnoredUsers returns the IDs of the users the current user ignores.
+func (cli *Client) GetIgnoredUsers(ctx context.Context) ([]string, error) {
+	var content IgnoredUserListContent
+	err := cli.GetAccountData(ctx, "m.ignored_user_list", &content)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	userIDs := make([]string, 0, len(content.IgnoredUsers))
+	for userID := range content.IgnoredUsers {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+// SetIgnoredUsers replaces the list of users the current user ignores.
+func (cli *Client) SetIgnoredUsers(ctx context.Context, userIDs []string) error {
+	content := IgnoredUserListContent{IgnoredUsers: make(map[string]struct{}, len(userIDs))}
+	for _, userID := range userIDs {
+		content.IgnoredUsers[userID] = struct{}{}
+	}
+	return cli.SetAccountData(ctx, "m.ignored_user_list", &content)
+}
+
+// AccountDataCache holds the latest global and per-room account data events seen in sync responses. The
+// DefaultSyncer keeps its AccountData cache up to date, including from the initial sync.
+//
+// It is safe to use the cache from multiple goroutines.
+type AccountDataCache struct {
+	mu        sync.RWMutex
+	global    map[string]*Event
+	rooms     map[string]map[string]*Event
+	listeners map[string][]OnEventListener
+}
+
+// NewAccountDataCache returns an empty AccountDataCache.
+func NewAccountDataCache() *AccountDataCache {
+	return &AccountDataCache{
+		global:    make(map[string]*Event),
+		rooms:     make(map[string]map[string]*Event),
+		listeners: make(map[string][]OnEventListener),
+	}
+}
+
+// Global returns the global account data event of the given type, or nil if there is none. Use
+// Event.ParseContent to decode it, e.g. into a *DirectContent for m.direct.
+func (c *AccountDataCache) Global(eventType string) *Event {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.global[eventType]
+}
+
+// Room returns the account data event of the given type for the room, or nil if there is none.
+func (c *AccountDataCache) Room(roomID, eventType string) *Event {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rooms[roomID][eventType]
+}
+
+// OnChange registers a callback which is called whenever account data of the given type changes. Room account
+// data events have RoomID set. Callbacks are called from the goroutine which updates the cache.
+func (c *AccountDataCache) OnChange(eventType string, callback OnEventListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners[eventType] = append(c.listeners[eventType], callback)
+}
+
+// Update stores the account data events of a sync response and notifies listeners of changed events.
+func (c *AccountDataCache) Update(res *RespSync) {
+	var changed []*Event
+	c.mu.Lock()
+	for i := range res.AccountData.Events {
+		if event := c.set("", &res.AccountData.Events[i]); event != nil {
+			changed = append(changed, event)
+		}
+	}
+	for roomID, room := range res.Rooms.Join {
+		for i := range room.AccountData.Events {
+			if event := c.set(roomID, &room.AccountData.Events[i]); event != nil {
+				changed = append(changed, event)
+			}
+		}
+	}
+	for roomID, room := range res.Rooms.Leave {
+		for i := range room.AccountData.Events {
+			if event := c.set(roomID, &room.AccountData.Events[i]); event != nil {
+				changed = append(changed, event)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	for _, event := range changed {
+		c.mu.RLock()
+		listeners := c.listeners[event.Type]
+		c.mu.RUnlock()
+		for _, fn := range listeners {
+			fn(event)
+		}
+	}
+}
+
+// set stores a copy of the event, returning it if it differs from the stored one. Must be called with the lock held.
+func (c *AccountDataCache) set(roomID string, event *Event) *Event {
+	stored := c.global
+	if roomID != "" {
+		if stored = c.rooms[roomID]; stored == nil {
+			stored = make(map[string]*Event)
+			c.rooms[roomID] = stored
+		}
+	}
+	if old, ok := stored[event.Type]; ok && reflect.DeepEqual(old.Content, event.Content) {
+		return nil
+	}
+	copied := *event
+	copied.RoomID = roomID
+	stored[event.Type] = &copied
+	return &copied
+}
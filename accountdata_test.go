@@ -0,0 +1,38 @@
+package gomatrix
+
+import (
+	"testing"
+)
+
+func TestAccountDataCache(t *testing.T) {
+	syncer := NewDefaultSyncer("@alice:example.com", NewInMemoryStore())
+	var changes, dispatched int
+	syncer.AccountData.OnChange("com.example.settings", func(event *Event) { changes++ })
+	syncer.OnEventType("com.example.settings", func(event *Event) { dispatched++ })
+
+	var res RespSync
+	res.AccountData.Events = []Event{{Type: "com.example.settings", Content: map[string]interface{}{"lang": "en"}}}
+	join := Join{}
+	join.AccountData.Events = []Event{{Type: "m.fully_read", Content: map[string]interface{}{"event_id": "$1"}}}
+	res.Rooms.Join = map[string]Join{"!room:example.com": join}
+
+	// The initial sync fills the cache without dispatching events to the syncer's listeners.
+	if err := syncer.ProcessResponse(&res, ""); err != nil {
+		t.Fatalf("TestAccountDataCache => ProcessResponse: %s", err)
+	}
+	if err := syncer.ProcessResponse(&res, "s1"); err != nil {
+		t.Fatalf("TestAccountDataCache => ProcessResponse: %s", err)
+	}
+	if changes != 1 || dispatched != 1 {
+		t.Fatalf("TestAccountDataCache => Got: %d changes, %d dispatched Expected: 1, 1", changes, dispatched)
+	}
+
+	event := syncer.AccountData.Room("!room:example.com", "m.fully_read")
+	if event == nil {
+		t.Fatal("TestAccountDataCache => Room Got: nil Expected: the m.fully_read event")
+	}
+	content, err := event.ParseContent()
+	if fullyRead, ok := content.(*FullyReadContent); err != nil || !ok || fullyRead.EventID != "$1" {
+		t.Fatalf("TestAccountDataCache => Got: %#v, %v Expected: a *FullyReadContent", content, err)
+	}
+}
@@ -0,0 +1,199 @@
+package gomatrix
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Errors returned when decrypting attachments.
+var (
+	ErrInvalidEncryptedFile = errors.New("invalid encrypted file")
+	ErrHashMismatch         = errors.New("encrypted file hash mismatch")
+)
+
+// EncryptedFile describes an attachment encrypted with AES-256-CTR, as used in the file and thumbnail_file fields of
+// messages in encrypted rooms - https://spec.matrix.org/v1.7/client-server-api/#extensions-to-mroommessage-msgtypes
+type EncryptedFile struct {
+	URL     string            `json:"url"`
+	Key     JSONWebKey        `json:"key"`
+	IV      string            `json:"iv"`     // Unpadded base64 of the 128-bit counter block.
+	Hashes  map[string]string `json:"hashes"` // Unpadded base64 of the SHA-256 of the ciphertext, keyed by "sha256".
+	Version string            `json:"v"`
+}
+
+// JSONWebKey is the key of an EncryptedFile.
+type JSONWebKey struct {
+	Kty    string   `json:"kty"`
+	KeyOps []string `json:"key_ops"`
+	Alg    string   `json:"alg"`
+	K      string   `json:"k"` // Unpadded URL-safe base64 of the 256-bit key.
+	Ext    bool     `json:"ext"`
+}
+
+// NewEncryptedFile returns an EncryptedFile with a new random key and IV, ready for Encrypt. The URL and hash are
+// filled in once the file has been encrypted and uploaded.
+func NewEncryptedFile() (*EncryptedFile, error) {
+	key := make([]byte, 32)
+	// The low 64 bits of the counter start at zero so that it cannot wrap.
+	iv := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv[:8]); err != nil {
+		return nil, err
+	}
+	return &EncryptedFile{
+		Key: JSONWebKey{
+			Kty:    "oct",
+			KeyOps: []string{"encrypt", "decrypt"},
+			Alg:    "A256CTR",
+			K:      base64.RawURLEncoding.EncodeToString(key),
+			Ext:    true,
+		},
+		IV:      base64.RawStdEncoding.EncodeToString(iv),
+		Hashes:  map[string]string{},
+		Version: "v2",
+	}, nil
+}
+
+// Encrypt returns a reader of the encrypted plaintext. The SHA-256 hash of the ciphertext is stored in f.Hashes once
+// the returned reader has been read to the end.
+func (f *EncryptedFile) Encrypt(plaintext io.Reader) (io.Reader, error) {
+	stream, err := f.stream()
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingReader{file: f, r: plaintext, stream: stream, hash: sha256.New()}, nil
+}
+
+// Decrypt returns a reader of the decrypted ciphertext. Since the hash can only be checked once all of the
+// ciphertext has been read, the reader returns ErrHashMismatch instead of io.EOF if it does not match, and the
+// data read so far must then be discarded.
+func (f *EncryptedFile) Decrypt(ciphertext io.Reader) (io.Reader, error) {
+	expected, err := decodeUnpadded(base64.RawStdEncoding, f.Hashes["sha256"])
+	if err != nil || len(expected) != sha256.Size {
+		return nil, fmt.Errorf("%w: missing or invalid sha256 hash", ErrInvalidEncryptedFile)
+	}
+	stream, err := f.stream()
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{r: ciphertext, stream: stream, hash: sha256.New(), expected: expected}, nil
+}
+
+func (f *EncryptedFile) stream() (cipher.Stream, error) {
+	if f.Key.Alg != "A256CTR" || f.Key.Kty != "oct" {
+		return nil, fmt.Errorf("%w: unsupported key %s/%s", ErrInvalidEncryptedFile, f.Key.Kty, f.Key.Alg)
+	}
+	if f.Version != "v2" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrInvalidEncryptedFile, f.Version)
+	}
+	key, err := decodeUnpadded(base64.RawURLEncoding, f.Key.K)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%w: invalid key", ErrInvalidEncryptedFile)
+	}
+	iv, err := decodeUnpadded(base64.RawStdEncoding, f.IV)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: invalid iv", ErrInvalidEncryptedFile)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(block, iv), nil
+}
+
+// decodeUnpadded decodes unpadded base64, tolerating padding added by other clients.
+func decodeUnpadded(enc *base64.Encoding, s string) ([]byte, error) {
+	return enc.DecodeString(strings.TrimRight(s, "="))
+}
+
+type encryptingReader struct {
+	file   *EncryptedFile
+	r      io.Reader
+	stream cipher.Stream
+	hash   hash.Hash
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.stream.XORKeyStream(p[:n], p[:n])
+	e.hash.Write(p[:n])
+	if err == io.EOF {
+		e.file.Hashes["sha256"] = base64.RawStdEncoding.EncodeToString(e.hash.Sum(nil))
+	}
+	return n, err
+}
+
+type decryptingReader struct {
+	r        io.Reader
+	stream   cipher.Stream
+	hash     hash.Hash
+	expected []byte
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	d.stream.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF && subtle.ConstantTimeCompare(d.hash.Sum(nil), d.expected) != 1 {
+		err = ErrHashMismatch
+	}
+	return n, err
+}
+
+// UploadEncrypted encrypts content with a new key and uploads it with UploadToContentRepo. The returned
+// EncryptedFile can be used as the file or thumbnail_file of a message.
+func (cli *Client) UploadEncrypted(ctx context.Context, content io.Reader, contentLength int64) (*EncryptedFile, error) {
+	file, err := NewEncryptedFile()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := file.Encrypt(content)
+	if err != nil {
+		return nil, err
+	}
+	// AES-CTR does not change the length of the content.
+	resp, err := cli.UploadToContentRepo(ctx, ciphertext, "application/octet-stream", contentLength)
+	if err != nil {
+		return nil, err
+	}
+	if file.Hashes["sha256"] == "" {
+		return nil, errors.New("upload did not consume the whole content")
+	}
+	file.URL = resp.ContentURI
+	return file, nil
+}
+
+// DownloadEncrypted downloads and decrypts an encrypted attachment. Reading the returned media fails with
+// ErrHashMismatch at the end if the content was tampered with; see EncryptedFile.Decrypt.
+func (cli *Client) DownloadEncrypted(ctx context.Context, file *EncryptedFile) (*Media, error) {
+	media, err := cli.Download(ctx, file.URL)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := file.Decrypt(media.ReadCloser)
+	if err != nil {
+		media.Close()
+		return nil, err
+	}
+	// The content type of the ciphertext is meaningless; the real one is in the message's info.
+	return &Media{
+		ReadCloser: struct {
+			io.Reader
+			io.Closer
+		}{plaintext, media.ReadCloser},
+		FileName: media.FileName,
+		Size:     media.Size,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package gomatrix
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedAttachmentRoundTrip(t *testing.T) {
+	var stored []byte
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/media/v3/upload":
+			stored, _ = ioutil.ReadAll(r.Body)
+			w.Write([]byte(`{"content_uri":"mxc://example.com/abc"}`))
+		case "/_matrix/client/v1/media/download/example.com/abc":
+			w.Write(stored)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	ctx := context.Background()
+	plaintext := strings.Repeat("secret attachment ", 1000)
+
+	file, err := cli.UploadEncrypted(ctx, strings.NewReader(plaintext), int64(len(plaintext)))
+	if err != nil {
+		t.Fatalf("TestEncryptedAttachmentRoundTrip => UploadEncrypted: %s", err)
+	}
+	if file.URL != "mxc://example.com/abc" || file.Hashes["sha256"] == "" || len(stored) != len(plaintext) || strings.Contains(string(stored), "secret") {
+		t.Fatalf("TestEncryptedAttachmentRoundTrip => Got: %+v Expected: an uploaded encrypted file", file)
+	}
+
+	media, err := cli.DownloadEncrypted(ctx, file)
+	if err != nil {
+		t.Fatalf("TestEncryptedAttachmentRoundTrip => DownloadEncrypted: %s", err)
+	}
+	got, err := ioutil.ReadAll(media)
+	media.Close()
+	if err != nil || string(got) != plaintext {
+		t.Fatalf("TestEncryptedAttachmentRoundTrip => Got: %d bytes %v Expected: the plaintext", len(got), err)
+	}
+
+	stored[len(stored)-1] ^= 1
+	media, err = cli.DownloadEncrypted(ctx, file)
+	if err != nil {
+		t.Fatalf("TestEncryptedAttachmentRoundTrip => DownloadEncrypted: %s", err)
+	}
+	defer media.Close()
+	if _, err = ioutil.ReadAll(media); err != ErrHashMismatch {
+		t.Fatalf("TestEncryptedAttachmentRoundTrip => Got: %v Expected: %v", err, ErrHashMismatch)
+	}
+}
@@ -5,6 +5,7 @@ package gomatrix
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,14 +29,22 @@ type Client struct {
 	Client        *http.Client // The underlying HTTP client which will be used to make HTTP requests.
 	Syncer        Syncer       // The thing which can process /sync responses
 	Store         Storer       // The thing which can store rooms/tokens/ids
+	RetryPolicy   RetryPolicy  // Decides whether failed requests are retried. If nil, requests are never retried.
 
 	// The ?user_id= query parameter for application services. This must be set *prior* to calling a method. If this is empty,
 	// no user_id parameter will be sent.
 	// See http://matrix.org/docs/spec/application_service/unstable.html#identity-assertion
 	AppServiceUserID string
 
+	// If true, SetDisplayName, SetAvatarURL and UploadAvatar also update the user's m.room.member event in every
+	// joined room, like Element does, for homeservers which do not propagate profile changes to rooms themselves.
+	UpdateRoomProfiles bool
+
 	syncingMutex sync.Mutex // protects syncingID
 	syncingID    uint32     // Identifies the current Sync. Only one Sync can be active at any given time.
+
+	mediaConfigMutex sync.Mutex       // protects mediaConfig
+	mediaConfig      *RespMediaConfig // The cached result of MediaConfig.
 }
 
 // HTTPError An HTTP Error response, which may wrap an underlying native Go Error.
@@ -54,6 +63,18 @@ func (e HTTPError) Error() string {
 	return fmt.Sprintf("contents=%v msg=%s code=%d wrapped=%s", e.Contents, e.Message, e.Code, wrappedErrMsg)
 }
 
+// Unwrap returns the wrapped error, which is usually a RespError.
+func (e HTTPError) Unwrap() error {
+	return e.WrappedError
+}
+
+// Is reports whether target is an HTTPError with the same status code, so that
+// errors.Is(err, HTTPError{Code: 404}) matches any 404 response.
+func (e HTTPError) Is(target error) bool {
+	t, ok := target.(HTTPError)
+	return ok && t.Code == e.Code
+}
+
 // BuildURL builds a URL with the Client's homeserver/prefix set already.
 func (cli *Client) BuildURL(urlPath ...string) string {
 	ps := append([]string{cli.Prefix}, urlPath...)
@@ -82,7 +103,14 @@ func (cli *Client) BuildBaseURL(urlPath ...string) string {
 
 // BuildURLWithQuery builds a URL with query parameters in addition to the Client's homeserver/prefix set already.
 func (cli *Client) BuildURLWithQuery(urlPath []string, urlQuery map[string]string) string {
-	u, _ := url.Parse(cli.BuildURL(urlPath...))
+	ps := append([]string{cli.Prefix}, urlPath...)
+	return cli.BuildBaseURLWithQuery(ps, urlQuery)
+}
+
+// BuildBaseURLWithQuery builds a URL with query parameters in addition to the Client's homeserver set already.
+// You must supply the prefix in the path.
+func (cli *Client) BuildBaseURLWithQuery(urlPath []string, urlQuery map[string]string) string {
+	u, _ := url.Parse(cli.BuildBaseURL(urlPath...))
 	q := u.Query()
 	for k, v := range urlQuery {
 		q.Set(k, v)
@@ -112,7 +140,10 @@ func (cli *Client) ClearCredentials() {
 //   - Client.Syncer.OnFailedSync returning an error in response to a failed sync.
 //   - Client.Syncer.ProcessResponse returning an error.
 // If you wish to continue retrying in spite of these fatal errors, call Sync() again.
-func (cli *Client) Sync() error {
+//
+// Cancelling ctx aborts the in-flight /sync long-poll and any pending retry wait, and
+// Sync returns the context's error.
+func (cli *Client) Sync(ctx context.Context) error {
 	// Mark the client as syncing.
 	// We will keep syncing until the syncing state changes. Either because
 	// Sync is called or StopSync is called.
@@ -121,7 +152,7 @@ func (cli *Client) Sync() error {
 	filterID := cli.Store.LoadFilterID(cli.UserID)
 	if filterID == "" {
 		filterJSON := cli.Syncer.GetFilterJSON(cli.UserID)
-		resFilter, err := cli.CreateFilter(filterJSON)
+		resFilter, err := cli.CreateFilter(ctx, filterJSON)
 		if err != nil {
 			return err
 		}
@@ -130,13 +161,18 @@ func (cli *Client) Sync() error {
 	}
 
 	for {
-		resSync, err := cli.SyncRequest(30000, nextBatch, filterID, false, "")
+		resSync, err := cli.SyncRequest(ctx, 30000, nextBatch, filterID, false, "")
 		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			duration, err2 := cli.Syncer.OnFailedSync(resSync, err)
 			if err2 != nil {
 				return err2
 			}
-			time.Sleep(duration)
+			if err2 = sleepContext(ctx, duration); err2 != nil {
+				return err2
+			}
 			continue
 		}
 
@@ -147,13 +183,13 @@ func (cli *Client) Sync() error {
 			return nil
 		}
 
-		// Save the token now *before* processing it. This means it's possible
-		// to not process some events, but it means that we won't get constantly stuck processing
-		// a malformed/buggy event which keeps making us panic.
-		cli.Store.SaveNextBatch(cli.UserID, resSync.NextBatch)
-		if err = cli.Syncer.ProcessResponse(resSync, nextBatch); err != nil {
+		// Save the token only *after* processing the response, as the syncer saves the rooms while
+		// processing it. Saving it first would lose the state of every room which had not been saved yet
+		// if we were killed in between, since the next sync would start after it.
+		if err = processResponse(ctx, cli.Syncer, resSync, nextBatch); err != nil {
 			return err
 		}
+		cli.Store.SaveNextBatch(cli.UserID, resSync.NextBatch)
 
 		nextBatch = resSync.NextBatch
 	}
@@ -178,31 +214,55 @@ func (cli *Client) StopSync() {
 	cli.incrementSyncingID()
 }
 
-// MakeRequest makes a JSON HTTP request to the given URL.
+// MakeRequest makes a JSON HTTP request to the given URL. The request is bound to ctx, so
+// cancelling it aborts the request along with any pending retry wait.
 // The response body will be stream decoded into an interface. This will automatically stop if the response
 // body is nil.
 //
+// Failed attempts are retried according to Client.RetryPolicy. If it is nil, the request is only attempted once.
+//
 // Returns an error if the response is not 2xx along with the HTTP body bytes if it got that far. This error is
 // an HTTPError which includes the returned HTTP status code, byte contents of the response body and possibly a
 // RespError as the WrappedError, if the HTTP body could be decoded as a RespError.
-func (cli *Client) MakeRequest(method string, httpURL string, reqBody interface{}, resBody interface{}) error {
-	var (
-		req   *http.Request
-		err   error
-		sleep time.Duration = 5000000000 // 5 seconds
-	)
+func (cli *Client) MakeRequest(ctx context.Context, method string, httpURL string, reqBody interface{}, resBody interface{}) error {
+	var body []byte
 	if reqBody != nil {
-		buf := new(bytes.Buffer)
-		if err := json.NewEncoder(buf).Encode(reqBody); err != nil {
+		var err error
+		if body, err = json.Marshal(reqBody); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := cli.makeRequestOnce(ctx, method, httpURL, body, resBody)
+		if err == nil || cli.RetryPolicy == nil || ctx.Err() != nil {
+			return err
+		}
+		wait, retry := cli.RetryPolicy.ShouldRetry(method, httpURL, attempt, res, err)
+		if !retry {
 			return err
 		}
-		req, err = http.NewRequest(method, httpURL, buf)
+		if err = sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// makeRequestOnce performs a single attempt of MakeRequest. The response is returned alongside any error so that
+// the retry policy can inspect it; its body has already been consumed and closed.
+func (cli *Client) makeRequestOnce(ctx context.Context, method string, httpURL string, body []byte, resBody interface{}) (*http.Response, error) {
+	var (
+		req *http.Request
+		err error
+	)
+	if body != nil {
+		req, err = http.NewRequestWithContext(ctx, method, httpURL, bytes.NewReader(body))
 	} else {
-		req, err = http.NewRequest(method, httpURL, nil)
+		req, err = http.NewRequestWithContext(ctx, method, httpURL, nil)
 	}
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -216,19 +276,12 @@ func (cli *Client) MakeRequest(method string, httpURL string, reqBody interface{
 		defer res.Body.Close()
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if res.StatusCode == 429 {
-		dur, err := HandleRetry(res, sleep)
-		if err != nil {
-			return err
-		}
-		time.Sleep(dur)
-		cli.MakeRequest(method, httpURL, reqBody, resBody)
-	} else if res.StatusCode/100 != 2 { // not 2xx
+	if res.StatusCode/100 != 2 { // not 2xx
 		contents, err := ioutil.ReadAll(res.Body)
 		if err != nil {
-			return err
+			return res, err
 		}
 
 		var wrap error
@@ -244,7 +297,7 @@ func (cli *Client) MakeRequest(method string, httpURL string, reqBody interface{
 			msg = msg + ": " + string(contents)
 		}
 
-		return HTTPError{
+		return res, HTTPError{
 			Contents:     contents,
 			Code:         res.StatusCode,
 			Message:      msg,
@@ -253,45 +306,28 @@ func (cli *Client) MakeRequest(method string, httpURL string, reqBody interface{
 	}
 
 	if resBody != nil && res.Body != nil {
-		return json.NewDecoder(res.Body).Decode(&resBody)
+		return res, json.NewDecoder(res.Body).Decode(&resBody)
 	}
 
-	return nil
-}
-
-func HandleRetry(res *http.Response, duration time.Duration) (time.Duration, error) {
-	ra := res.Header.Get("Retry-After")
-	if ra == "" {
-		return duration, nil
-	}
-
-	if t, err := time.Parse(http.TimeFormat, ra); err == nil {
-		return time.Until(t), nil
-	}
-
-	if seconds, err := strconv.Atoi(ra); err == nil {
-		return time.Duration(seconds) * time.Second, nil
-	}
-
-	return duration, errors.New("invalid retry-after data")
+	return res, nil
 }
 
 // CreateFilter makes an HTTP request according to https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3useruseridfilter
-func (cli *Client) CreateFilter(filter json.RawMessage) (resp *RespCreateFilter, err error) {
+func (cli *Client) CreateFilter(ctx context.Context, filter json.RawMessage) (resp *RespCreateFilter, err error) {
 	urlPath := cli.BuildURL("user", cli.UserID, "filter")
-	err = cli.MakeRequest("POST", urlPath, &filter, &resp)
+	err = cli.MakeRequest(ctx, "POST", urlPath, &filter, &resp)
 	return
 }
 
 // GetFilter makes an HTTP request according to https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3useruseridfilterfilterid
-func (cli *Client) GetFilter(filterID string) (resp *Filter, err error) {
+func (cli *Client) GetFilter(ctx context.Context, filterID string) (resp *Filter, err error) {
 	urlPath := cli.BuildURL("user", cli.UserID, "filter", filterID)
-	err = cli.MakeRequest("GET", urlPath, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
 	return
 }
 
 // SyncRequest makes an HTTP request according to https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3sync
-func (cli *Client) SyncRequest(timeout int, since, filterID string, fullState bool, setPresence string) (resp *RespSync, err error) {
+func (cli *Client) SyncRequest(ctx context.Context, timeout int, since, filterID string, fullState bool, setPresence string) (resp *RespSync, err error) {
 	query := map[string]string{
 		"timeout": strconv.Itoa(timeout),
 	}
@@ -308,14 +344,14 @@ func (cli *Client) SyncRequest(timeout int, since, filterID string, fullState bo
 		query["since"] = since
 	}
 	urlPath := cli.BuildURLWithQuery([]string{"sync"}, query)
-	err = cli.MakeRequest("GET", urlPath, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
 	return
 }
 
 // GetEventByID returns a single event based on roomId/eventId. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomideventeventid
-func (cli *Client) GetEventByID(eventID, roomID string) (resp *Event, err error) {
+func (cli *Client) GetEventByID(ctx context.Context, eventID, roomID string) (resp *Event, err error) {
 	u := cli.BuildURL("rooms", roomID, "event", eventID)
-	err = cli.MakeRequest("GET", u, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", u, nil, &resp)
 	return
 }
 
@@ -323,14 +359,15 @@ func (cli *Client) GetEventByID(eventID, roomID string) (resp *Event, err error)
 //
 // This API is primarily for Application Services and should be faster to
 // respond than /members as it can be implemented more efficiently on the server.
-func (cli *Client) JoinedMembers(roomID string) (resp *RespJoinedMembers, err error) {
+func (cli *Client) JoinedMembers(ctx context.Context, roomID string) (resp *RespJoinedMembers, err error) {
 	u := cli.BuildURL("rooms", roomID, "joined_members")
-	err = cli.MakeRequest("GET", u, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", u, nil, &resp)
 	return
 }
 
-// GetMembers returns the list of members for a room. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidmembers
-func (cli *Client) GetMembers(at, membership, notMembership, roomID string) (resp *RespJoinedMembers, err error) {
+// GetMembers returns the m.room.member events of a room. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidmembers
+// To keep a Room's member list up to date, see LoadMembers.
+func (cli *Client) GetMembers(ctx context.Context, at, membership, notMembership, roomID string) (resp *RespMembers, err error) {
 	query := map[string]string{}
 
 	if at != "" {
@@ -344,20 +381,20 @@ func (cli *Client) GetMembers(at, membership, notMembership, roomID string) (res
 	}
 
 	urlPath := cli.BuildURLWithQuery([]string{"rooms", roomID, "members"}, query)
-	err = cli.MakeRequest("GET", urlPath, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
 	return
 }
 
 // GetStateEvent returns the state events for the current state of the room. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidstate
-func (cli *Client) GetStateEvents(roomID string) (resp *Event, err error) {
+func (cli *Client) GetStateEvents(ctx context.Context, roomID string) (resp *Event, err error) {
 	urlPath := cli.BuildURL("rooms", roomID, "state")
-	err = cli.MakeRequest("GET", urlPath, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
 	return
 }
 
 // PowerLevels returns the power levels content for the current state of the room. See https://spec.matrix.org/v1.1/client-server-api/#mroompower_levels
-func (cli *Client) PowerLevels(roomID string) (resp *RespPowerLevels, err error) {
-	err = cli.StateEvent(roomID, "m.room.power_levels", "", &resp)
+func (cli *Client) PowerLevels(ctx context.Context, roomID string) (resp *RespPowerLevels, err error) {
+	err = cli.StateEvent(ctx, roomID, "m.room.power_levels", "", &resp)
 	return
 }
 
@@ -365,7 +402,7 @@ func (cli *Client) PowerLevels(roomID string) (resp *RespPowerLevels, err error)
 // specified event. It use pagination query parameters to paginate history in
 // the room.
 // See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidcontexteventid
-func (cli *Client) Context(roomID, eventID, filter string, limit int) (resp *RespContext, err error) {
+func (cli *Client) Context(ctx context.Context, roomID, eventID, filter string, limit int) (resp *RespContext, err error) {
 	query := map[string]string{}
 
 	if filter != "" {
@@ -377,14 +414,14 @@ func (cli *Client) Context(roomID, eventID, filter string, limit int) (resp *Res
 	}
 
 	urlPath := cli.BuildURLWithQuery([]string{"rooms", roomID, "context", eventID}, query)
-	err = cli.MakeRequest("GET", urlPath, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
 	return
 }
 
 // Messages returns a list of message and state events for a room. It uses
 // pagination query parameters to paginate history in the room.
 // See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidmessages
-func (cli *Client) Messages(roomID, filter, from, to string, dir rune, limit int) (resp *RespMessages, err error) {
+func (cli *Client) Messages(ctx context.Context, roomID, filter, from, to string, dir rune, limit int) (resp *RespMessages, err error) {
 	query := map[string]string{
 		"dir": string(dir),
 	}
@@ -406,43 +443,43 @@ func (cli *Client) Messages(roomID, filter, from, to string, dir rune, limit int
 	}
 
 	urlPath := cli.BuildURLWithQuery([]string{"rooms", roomID, "messages"}, query)
-	err = cli.MakeRequest("GET", urlPath, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
 	return
 }
 
 // SendStateEvent sends a state event into a room. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3roomsroomidstateeventtypestatekey
 // contentJSON should be a pointer to something that can be encoded as JSON using json.Marshal.
-func (cli *Client) SendStateEvent(roomID, eventType, stateKey string, contentJSON interface{}) (resp *RespSendEvent, err error) {
+func (cli *Client) SendStateEvent(ctx context.Context, roomID, eventType, stateKey string, contentJSON interface{}) (resp *RespSendEvent, err error) {
 	urlPath := cli.BuildURL("rooms", roomID, "state", eventType, stateKey)
-	err = cli.MakeRequest("PUT", urlPath, contentJSON, &resp)
+	err = cli.MakeRequest(ctx, "PUT", urlPath, contentJSON, &resp)
 	return
 }
 
 // SendMessageEvent sends a message event into a room. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3roomsroomidsendeventtypetxnid
 // contentJSON should be a pointer to something that can be encoded as JSON using json.Marshal.
-func (cli *Client) SendMessageEvent(roomID, eventType string, contentJSON interface{}) (resp *RespSendEvent, err error) {
+func (cli *Client) SendMessageEvent(ctx context.Context, roomID, eventType string, contentJSON interface{}) (resp *RespSendEvent, err error) {
 	txnID := txnID()
 	urlPath := cli.BuildURL("rooms", roomID, "send", eventType, txnID)
-	err = cli.MakeRequest("PUT", urlPath, contentJSON, &resp)
+	err = cli.MakeRequest(ctx, "PUT", urlPath, contentJSON, &resp)
 	return
 }
 
 // RedactEvent redacts the given event. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3roomsroomidredacteventidtxnid
-func (cli *Client) RedactEvent(roomID, eventID string, req *ReqRedact) (resp *RespSendEvent, err error) {
+func (cli *Client) RedactEvent(ctx context.Context, roomID, eventID string, req *ReqRedact) (resp *RespSendEvent, err error) {
 	txnID := txnID()
 	urlPath := cli.BuildURL("rooms", roomID, "redact", eventID, txnID)
-	err = cli.MakeRequest("PUT", urlPath, req, &resp)
+	err = cli.MakeRequest(ctx, "PUT", urlPath, req, &resp)
 	return
 }
 
 // CreateRoom creates a new Matrix room. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3createroom
-//  resp, err := cli.CreateRoom(&gomatrix.ReqCreateRoom{
+//  resp, err := cli.CreateRoom(ctx, &gomatrix.ReqCreateRoom{
 //  	Preset: "public_chat",
 //  })
 //  fmt.Println("Room:", resp.RoomID)
-func (cli *Client) CreateRoom(req *ReqCreateRoom) (resp *RespCreateRoom, err error) {
+func (cli *Client) CreateRoom(ctx context.Context, req *ReqCreateRoom) (resp *RespCreateRoom, err error) {
 	urlPath := cli.BuildURL("createRoom")
-	err = cli.MakeRequest("POST", urlPath, req, &resp)
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, &resp)
 	return
 }
 
@@ -450,9 +487,9 @@ func (cli *Client) CreateRoom(req *ReqCreateRoom) (resp *RespCreateRoom, err err
 //
 // In general, usage of this API is discouraged in favour of /sync, as calling this API can race with incoming membership changes.
 // This API is primarily designed for application services which may want to efficiently look up joined rooms.
-func (cli *Client) JoinedRooms() (resp *RespJoinedRooms, err error) {
+func (cli *Client) JoinedRooms(ctx context.Context) (resp *RespJoinedRooms, err error) {
 	u := cli.BuildURL("joined_rooms")
-	err = cli.MakeRequest("GET", u, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", u, nil, &resp)
 	return
 }
 
@@ -460,7 +497,7 @@ func (cli *Client) JoinedRooms() (resp *RespJoinedRooms, err error) {
 //
 // If serverName is specified, this will be added as a query param to instruct the homeserver to join via that server. If content is specified, it will
 // be JSON encoded and used as the request body.
-func (cli *Client) JoinRoomIDOrAlias(roomIDorAlias, serverName string, content interface{}) (resp *RespJoinRoom, err error) {
+func (cli *Client) JoinRoomIDOrAlias(ctx context.Context, roomIDorAlias, serverName string, content interface{}) (resp *RespJoinRoom, err error) {
 	var urlPath string
 	if serverName != "" {
 		urlPath = cli.BuildURLWithQuery([]string{"join", roomIDorAlias}, map[string]string{
@@ -469,7 +506,7 @@ func (cli *Client) JoinRoomIDOrAlias(roomIDorAlias, serverName string, content i
 	} else {
 		urlPath = cli.BuildURL("join", roomIDorAlias)
 	}
-	err = cli.MakeRequest("POST", urlPath, content, &resp)
+	err = cli.MakeRequest(ctx, "POST", urlPath, content, &resp)
 	return
 }
 
@@ -477,7 +514,7 @@ func (cli *Client) JoinRoomIDOrAlias(roomIDorAlias, serverName string, content i
 //
 // If serverName is specified, this will be added as a query param to instruct the homeserver to join via that server. If content is specified, it will
 // be JSON encoded and used as the request body.
-func (cli *Client) JoinRoom(roomID, serverName string, content interface{}) (resp *RespJoinRoom, err error) {
+func (cli *Client) JoinRoom(ctx context.Context, roomID, serverName string, content interface{}) (resp *RespJoinRoom, err error) {
 	var urlPath string
 	if serverName != "" {
 		urlPath = cli.BuildURLWithQuery([]string{"rooms", roomID, "join"}, map[string]string{
@@ -486,12 +523,12 @@ func (cli *Client) JoinRoom(roomID, serverName string, content interface{}) (res
 	} else {
 		urlPath = cli.BuildURL("rooms", roomID, "join")
 	}
-	err = cli.MakeRequest("POST", urlPath, content, &resp)
+	err = cli.MakeRequest(ctx, "POST", urlPath, content, &resp)
 	return
 }
 
 // KnockRoom “knocks” on the room to ask for permission to join. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3knockroomidoralias
-func (cli *Client) KnockRoom(roomIDorAlias, serverName string, req *ReqKnockRoom) (resp *RespKnockRoom, err error) {
+func (cli *Client) KnockRoom(ctx context.Context, roomIDorAlias, serverName string, req *ReqKnockRoom) (resp *RespKnockRoom, err error) {
 	var urlPath string
 	if serverName != "" {
 		urlPath = cli.BuildURLWithQuery([]string{"knock", roomIDorAlias}, map[string]string{
@@ -500,78 +537,85 @@ func (cli *Client) KnockRoom(roomIDorAlias, serverName string, req *ReqKnockRoom
 	} else {
 		urlPath = cli.BuildURL("knock", roomIDorAlias)
 	}
-	err = cli.MakeRequest("POST", urlPath, req, &resp)
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, &resp)
 	return
 }
 
 // ForgetRoom forgets a room entirely. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3roomsroomidforget
-func (cli *Client) ForgetRoom(roomID string) (resp *RespForgetRoom, err error) {
+func (cli *Client) ForgetRoom(ctx context.Context, roomID string) (resp *RespForgetRoom, err error) {
 	u := cli.BuildURL("rooms", roomID, "forget")
-	err = cli.MakeRequest("POST", u, struct{}{}, &resp)
+	err = cli.MakeRequest(ctx, "POST", u, struct{}{}, &resp)
 	return
 }
 
 // LeaveRoom leaves the given room. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3roomsroomidleave
-func (cli *Client) LeaveRoom(roomID string, req *ReqLeaveRoom) (resp *RespLeaveRoom, err error) {
+func (cli *Client) LeaveRoom(ctx context.Context, roomID string, req *ReqLeaveRoom) (resp *RespLeaveRoom, err error) {
 	u := cli.BuildURL("rooms", roomID, "leave")
-	err = cli.MakeRequest("POST", u, req, &resp)
+	err = cli.MakeRequest(ctx, "POST", u, req, &resp)
 	return
 }
 
 // KickUser kicks a user from a room. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3roomsroomidkick
-func (cli *Client) KickUser(roomID string, req *ReqKickUser) (resp *RespKickUser, err error) {
+func (cli *Client) KickUser(ctx context.Context, roomID string, req *ReqKickUser) (resp *RespKickUser, err error) {
 	u := cli.BuildURL("rooms", roomID, "kick")
-	err = cli.MakeRequest("POST", u, req, &resp)
+	err = cli.MakeRequest(ctx, "POST", u, req, &resp)
 	return
 }
 
 // BanUser bans a user from a room. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3roomsroomidkick
-func (cli *Client) BanUser(roomID string, req *ReqBanUser) (resp *RespBanUser, err error) {
+func (cli *Client) BanUser(ctx context.Context, roomID string, req *ReqBanUser) (resp *RespBanUser, err error) {
 	u := cli.BuildURL("rooms", roomID, "ban")
-	err = cli.MakeRequest("POST", u, req, &resp)
+	err = cli.MakeRequest(ctx, "POST", u, req, &resp)
 	return
 }
 
 // UnbanUser unbans a user from a room. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3roomsroomidunban
-func (cli *Client) UnbanUser(roomID string, req *ReqUnbanUser) (resp *RespUnbanUser, err error) {
+func (cli *Client) UnbanUser(ctx context.Context, roomID string, req *ReqUnbanUser) (resp *RespUnbanUser, err error) {
 	u := cli.BuildURL("rooms", roomID, "unban")
-	err = cli.MakeRequest("POST", u, req, &resp)
+	err = cli.MakeRequest(ctx, "POST", u, req, &resp)
 	return
 }
 
 // GetRoomDir gets the visibility of a given room on the server’s public room directory. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3directorylistroomroomid
-func (cli *Client) GetRoomDir(roomID string) (resp *RespGetRoomDir, err error) {
+func (cli *Client) GetRoomDir(ctx context.Context, roomID string) (resp *RespGetRoomDir, err error) {
 	u := cli.BuildURL("directory", "list", "room", roomID)
-	err = cli.MakeRequest("GET", u, nil, &resp)
+	err = cli.MakeRequest(ctx, "GET", u, nil, &resp)
 	return
 }
 
 // SetRoomDir gets the visibility of a given room on the server’s public room directory. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3directorylistroomroomid
-func (cli *Client) SetRoomDir(roomID string, req *ReqSetRoomDir) (resp *RespSetRoomDir, err error) {
+func (cli *Client) SetRoomDir(ctx context.Context, roomID string, req *ReqSetRoomDir) (resp *RespSetRoomDir, err error) {
 	u := cli.BuildURL("directory", "list", "room", roomID)
-	err = cli.MakeRequest("PUT", u, req, &resp)
+	err = cli.MakeRequest(ctx, "PUT", u, req, &resp)
 	return
 }
 
 // SearchUsers performs a search for users on the homeserver. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3user_directorysearch
-func (cli *Client) SearchUsers(req *ReqSearchUsers) (resp *RespSearchUsers, err error) {
+func (cli *Client) SearchUsers(ctx context.Context, req *ReqSearchUsers) (resp *RespSearchUsers, err error) {
 	urlPath := cli.BuildURL("user_directory", "search")
-	err = cli.MakeRequest("POST", urlPath, struct{}{}, &resp)
+	err = cli.MakeRequest(ctx, "POST", urlPath, struct{}{}, &resp)
 	return
 }
 
 // SendText sends an m.room.message event into the given room with a msgtype of m.text
 // See http://matrix.org/docs/spec/client_server/r0.2.0.html#m-text
-func (cli *Client) SendText(roomID, text string) (*RespSendEvent, error) {
-	return cli.SendMessageEvent(roomID, "m.room.message",
+func (cli *Client) SendText(ctx context.Context, roomID, text string) (*RespSendEvent, error) {
+	return cli.SendMessageEvent(ctx, roomID, "m.room.message",
 		TextMessage{MsgType: "m.text", Body: text})
 }
 
-func (cli *Client) register(u string, req *ReqRegister) (resp *RespRegister, uiaResp *RespUserInteractive, err error) {
-	err = cli.MakeRequest("POST", u, req, &resp)
+func (cli *Client) register(ctx context.Context, u string, req *ReqRegister) (resp *RespRegister, uiaResp *RespUserInteractive, err error) {
+	uiaResp, err = cli.makeUserInteractiveRequest(ctx, "POST", u, req, &resp)
+	return
+}
+
+// makeUserInteractiveRequest makes a request to an endpoint protected by user-interactive authentication. If the
+// server requires (further) authentication, the 401 response is returned as uiaResp with a nil error.
+func (cli *Client) makeUserInteractiveRequest(ctx context.Context, method string, u string, reqBody interface{}, resBody interface{}) (uiaResp *RespUserInteractive, err error) {
+	err = cli.MakeRequest(ctx, method, u, reqBody, resBody)
 	if err != nil {
-		httpErr, ok := err.(HTTPError)
-		if !ok { // network error
+		var httpErr HTTPError
+		if !errors.As(err, &httpErr) { // network error
 			return
 		}
 		if httpErr.Code == 401 {
@@ -586,30 +630,30 @@ func (cli *Client) register(u string, req *ReqRegister) (resp *RespRegister, uia
 // Register makes an HTTP request according to http://matrix.org/docs/spec/client_server/r0.2.0.html#post-matrix-client-r0-register
 //
 // Registers with kind=user. For kind=guest, see RegisterGuest.
-func (cli *Client) Register(req *ReqRegister) (*RespRegister, *RespUserInteractive, error) {
+func (cli *Client) Register(ctx context.Context, req *ReqRegister) (*RespRegister, *RespUserInteractive, error) {
 	u := cli.BuildURL("register")
-	return cli.register(u, req)
+	return cli.register(ctx, u, req)
 }
 
 // Login a user to the homeserver according to https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3login
 // This does not set credentials on this client instance. See SetCredentials() instead.
-func (cli *Client) Login(req *ReqLogin) (resp *RespLogin, err error) {
+func (cli *Client) Login(ctx context.Context, req *ReqLogin) (resp *RespLogin, err error) {
 	urlPath := cli.BuildURL("login")
-	err = cli.MakeRequest("POST", urlPath, req, &resp)
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, &resp)
 	return
 }
 
 // SendFormattedText sends an m.room.message event into the given room with a msgtype of m.text, supports a subset of HTML for formatting.
 // See https://matrix.org/docs/spec/client_server/r0.6.0#m-text
-func (cli *Client) SendFormattedText(roomID, text, formattedText string) (*RespSendEvent, error) {
-	return cli.SendMessageEvent(roomID, "m.room.message",
+func (cli *Client) SendFormattedText(ctx context.Context, roomID, text, formattedText string) (*RespSendEvent, error) {
+	return cli.SendMessageEvent(ctx, roomID, "m.room.message",
 		TextMessage{MsgType: "m.text", Body: text, FormattedBody: formattedText, Format: "org.matrix.custom.html"})
 }
 
 // SendSticker sends an m.room.message event into the given room with a msgtype of m.sticker
 // See https://spec.matrix.org/latest/client-server-api/#msticker
-func (cli *Client) SendSticker(roomID, body, url string) (*RespSendEvent, error) {
-	return cli.SendMessageEvent(roomID, "m.sticker",
+func (cli *Client) SendSticker(ctx context.Context, roomID, body, url string) (*RespSendEvent, error) {
+	return cli.SendMessageEvent(ctx, roomID, "m.sticker",
 		ImageMessage{
 			Body: body,
 			Info: ImageInfo{
@@ -627,43 +671,426 @@ func (cli *Client) SendSticker(roomID, body, url string) (*RespSendEvent, error)
 
 // SendNotice sends an m.room.message event into the given room with a msgtype of m.notice
 // See https://spec.matrix.org/v1.1/client-server-api/#mnotice
-func (cli *Client) SendNotice(roomID, text string) (*RespSendEvent, error) {
-	return cli.SendMessageEvent(roomID, "m.room.message",
+func (cli *Client) SendNotice(ctx context.Context, roomID, text string) (*RespSendEvent, error) {
+	return cli.SendMessageEvent(ctx, roomID, "m.room.message",
 		TextMessage{MsgType: "m.notice", Body: text})
 }
 
 // InviteUserByThirdParty invites a third-party identifier to a room. See http://matrix.org/docs/spec/client_server/r0.2.0.html#invite-by-third-party-id-endpoint
-func (cli *Client) InviteUserByThirdParty(roomID string, req *ReqInvite3PID) (resp *RespInviteUser, err error) {
+func (cli *Client) InviteUserByThirdParty(ctx context.Context, roomID string, req *ReqInvite3PID) (resp *RespInviteUser, err error) {
 	u := cli.BuildURL("rooms", roomID, "invite")
-	err = cli.MakeRequest("POST", u, req, &resp)
+	err = cli.MakeRequest(ctx, "POST", u, req, &resp)
 	return
 }
 
 // StateEvent gets a single state event in a room. It will attempt to JSON unmarshal into the given "outContent" struct with
 // the HTTP response body, or return an error.
 // See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidstateeventtypestatekey
-func (cli *Client) StateEvent(roomID, eventType, stateKey string, outContent interface{}) (err error) {
+func (cli *Client) StateEvent(ctx context.Context, roomID, eventType, stateKey string, outContent interface{}) (err error) {
 	u := cli.BuildURL("rooms", roomID, "state", eventType, stateKey)
-	err = cli.MakeRequest("GET", u, nil, outContent)
+	err = cli.MakeRequest(ctx, "GET", u, nil, outContent)
+	return
+}
+
+// GetProfile returns the display name and avatar URL of the user. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3profileuserid
+func (cli *Client) GetProfile(ctx context.Context, userID string) (resp *RespProfile, err error) {
+	urlPath := cli.BuildURL("profile", userID)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// GetDisplayName returns the display name of the user. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3profileuseriddisplayname
+func (cli *Client) GetDisplayName(ctx context.Context, userID string) (resp *RespUserDisplayName, err error) {
+	urlPath := cli.BuildURL("profile", userID, "displayname")
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// GetOwnDisplayName returns the display name of the current user.
+func (cli *Client) GetOwnDisplayName(ctx context.Context) (*RespUserDisplayName, error) {
+	return cli.GetDisplayName(ctx, cli.UserID)
+}
+
+// SetDisplayName sets the display name of the current user. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3profileuseriddisplayname
+func (cli *Client) SetDisplayName(ctx context.Context, displayName string) (err error) {
+	urlPath := cli.BuildURL("profile", cli.UserID, "displayname")
+	if err = cli.MakeRequest(ctx, "PUT", urlPath, &ReqSetDisplayName{DisplayName: displayName}, nil); err != nil {
+		return
+	}
+	if cli.UpdateRoomProfiles {
+		err = cli.updateRoomMemberEvents(ctx, "displayname", displayName)
+	}
+	return
+}
+
+// GetAvatarURL returns the avatar URL of the user. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3profileuseridavatar_url
+func (cli *Client) GetAvatarURL(ctx context.Context, userID string) (resp *RespAvatarURL, err error) {
+	urlPath := cli.BuildURL("profile", userID, "avatar_url")
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// SetAvatarURL sets the avatar URL of the current user to an MXC URI. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3profileuseridavatar_url
+func (cli *Client) SetAvatarURL(ctx context.Context, url string) (err error) {
+	urlPath := cli.BuildURL("profile", cli.UserID, "avatar_url")
+	if err = cli.MakeRequest(ctx, "PUT", urlPath, &ReqSetProfile{AvatarUrl: url}, nil); err != nil {
+		return
+	}
+	if cli.UpdateRoomProfiles {
+		err = cli.updateRoomMemberEvents(ctx, "avatar_url", url)
+	}
+	return
+}
+
+// UploadAvatar uploads an image with UploadToContentRepo and sets it as the avatar of the current user.
+func (cli *Client) UploadAvatar(ctx context.Context, content io.Reader, contentType string, contentLength int64) (*RespMediaUpload, error) {
+	resp, err := cli.UploadToContentRepo(ctx, content, contentType, contentLength)
+	if err != nil {
+		return nil, err
+	}
+	return resp, cli.SetAvatarURL(ctx, resp.ContentURI)
+}
+
+// updateRoomMemberEvents sets a field of the current user's member event in every joined room. Rooms where the
+// field is already up to date are skipped. All rooms are attempted; the first error is returned.
+func (cli *Client) updateRoomMemberEvents(ctx context.Context, field, value string) error {
+	rooms, err := cli.JoinedRooms(ctx)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, roomID := range rooms.JoinedRooms {
+		var content map[string]interface{}
+		err = cli.StateEvent(ctx, roomID, "m.room.member", cli.UserID, &content)
+		if err == nil && content["membership"] == "join" && content[field] != value {
+			content[field] = value
+			_, err = cli.SendStateEvent(ctx, roomID, "m.room.member", cli.UserID, content)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// GetAccountData decodes the global account data of the given type of the current user into out.
+// See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3useruseridaccount_datatype
+func (cli *Client) GetAccountData(ctx context.Context, eventType string, out interface{}) (err error) {
+	urlPath := cli.BuildURL("user", cli.UserID, "account_data", eventType)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, out)
+	return
+}
+
+// SetAccountData sets the global account data of the given type of the current user.
+// See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3useruseridaccount_datatype
+func (cli *Client) SetAccountData(ctx context.Context, eventType string, content interface{}) (err error) {
+	urlPath := cli.BuildURL("user", cli.UserID, "account_data", eventType)
+	err = cli.MakeRequest(ctx, "PUT", urlPath, content, nil)
+	return
+}
+
+// GetRoomAccountData decodes the account data of the given type of the current user in the room into out.
+// See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3useruseridroomsroomidaccount_datatype
+func (cli *Client) GetRoomAccountData(ctx context.Context, roomID, eventType string, out interface{}) (err error) {
+	urlPath := cli.BuildURL("user", cli.UserID, "rooms", roomID, "account_data", eventType)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, out)
+	return
+}
+
+// SetRoomAccountData sets the account data of the given type of the current user in the room.
+// See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3useruseridroomsroomidaccount_datatype
+func (cli *Client) SetRoomAccountData(ctx context.Context, roomID, eventType string, content interface{}) (err error) {
+	urlPath := cli.BuildURL("user", cli.UserID, "rooms", roomID, "account_data", eventType)
+	err = cli.MakeRequest(ctx, "PUT", urlPath, content, nil)
+	return
+}
+
+// GetTags returns the tags of the room for the current user. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3useruseridroomsroomidtags
+func (cli *Client) GetTags(ctx context.Context, roomID string) (resp *TagContent, err error) {
+	urlPath := cli.BuildURL("user", cli.UserID, "rooms", roomID, "tags")
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// AddTag adds a tag to the room for the current user, or updates its properties. The tag name is checked with
+// ValidateTagName. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3useruseridroomsroomidtagstag
+func (cli *Client) AddTag(ctx context.Context, roomID, tag string, properties TagProperties) (err error) {
+	if err = ValidateTagName(tag); err != nil {
+		return
+	}
+	urlPath := cli.BuildURL("user", cli.UserID, "rooms", roomID, "tags", tag)
+	err = cli.MakeRequest(ctx, "PUT", urlPath, properties, nil)
+	return
+}
+
+// RemoveTag removes a tag from the room for the current user. See https://spec.matrix.org/v1.1/client-server-api/#delete_matrixclientv3useruseridroomsroomidtagstag
+func (cli *Client) RemoveTag(ctx context.Context, roomID, tag string) (err error) {
+	if err = ValidateTagName(tag); err != nil {
+		return
+	}
+	urlPath := cli.BuildURL("user", cli.UserID, "rooms", roomID, "tags", tag)
+	err = cli.MakeRequest(ctx, "DELETE", urlPath, nil, nil)
+	return
+}
+
+// GetPushRules returns the push rules of the current user. See https://spec.matrix.org/v1.7/client-server-api/#get_matrixclientv3pushrules
+func (cli *Client) GetPushRules(ctx context.Context) (resp *RespPushRules, err error) {
+	urlPath := cli.BuildURL("pushrules/")
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// GetPushRule returns a single push rule of the given kind, e.g. PushRuleKindOverride. See https://spec.matrix.org/v1.7/client-server-api/#get_matrixclientv3pushrulesscopekindruleid
+func (cli *Client) GetPushRule(ctx context.Context, kind, ruleID string) (resp *PushRule, err error) {
+	urlPath := cli.BuildURL("pushrules", "global", kind, ruleID)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// PutPushRule creates or replaces a user-defined push rule. If before or after is non-empty, the rule is placed
+// before or after the rule with that ID. See https://spec.matrix.org/v1.7/client-server-api/#put_matrixclientv3pushrulesscopekindruleid
+func (cli *Client) PutPushRule(ctx context.Context, kind, ruleID string, req *ReqPutPushRule, before, after string) (err error) {
+	args := map[string]string{}
+	if before != "" {
+		args["before"] = before
+	}
+	if after != "" {
+		args["after"] = after
+	}
+	urlPath := cli.BuildURLWithQuery([]string{"pushrules", "global", kind, ruleID}, args)
+	err = cli.MakeRequest(ctx, "PUT", urlPath, req, nil)
+	return
+}
+
+// DeletePushRule deletes a user-defined push rule. See https://spec.matrix.org/v1.7/client-server-api/#delete_matrixclientv3pushrulesscopekindruleid
+func (cli *Client) DeletePushRule(ctx context.Context, kind, ruleID string) (err error) {
+	urlPath := cli.BuildURL("pushrules", "global", kind, ruleID)
+	err = cli.MakeRequest(ctx, "DELETE", urlPath, nil, nil)
+	return
+}
+
+// SetPushRuleEnabled enables or disables a push rule. See https://spec.matrix.org/v1.7/client-server-api/#put_matrixclientv3pushrulesscopekindruleidenabled
+func (cli *Client) SetPushRuleEnabled(ctx context.Context, kind, ruleID string, enabled bool) (err error) {
+	urlPath := cli.BuildURL("pushrules", "global", kind, ruleID, "enabled")
+	err = cli.MakeRequest(ctx, "PUT", urlPath, &ReqPushRuleEnabled{Enabled: enabled}, nil)
+	return
+}
+
+// SetPushRuleActions sets the actions of a push rule. See https://spec.matrix.org/v1.7/client-server-api/#put_matrixclientv3pushrulesscopekindruleidactions
+func (cli *Client) SetPushRuleActions(ctx context.Context, kind, ruleID string, actions []interface{}) (err error) {
+	urlPath := cli.BuildURL("pushrules", "global", kind, ruleID, "actions")
+	err = cli.MakeRequest(ctx, "PUT", urlPath, &ReqPushRuleActions{Actions: actions}, nil)
+	return
+}
+
+// Pushers returns the pushers registered for the current user. See https://spec.matrix.org/v1.7/client-server-api/#get_matrixclientv3pushers
+func (cli *Client) Pushers(ctx context.Context) (resp *RespPushers, err error) {
+	urlPath := cli.BuildURL("pushers")
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// SetPusher creates or updates a pusher. See https://spec.matrix.org/v1.7/client-server-api/#post_matrixclientv3pushersset
+func (cli *Client) SetPusher(ctx context.Context, req *ReqSetPusher) (err error) {
+	urlPath := cli.BuildURL("pushers", "set")
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, nil)
+	return
+}
+
+// DeletePusher deletes the pusher with the given app ID and pushkey. See https://spec.matrix.org/v1.7/client-server-api/#post_matrixclientv3pushersset
+func (cli *Client) DeletePusher(ctx context.Context, appID, pushKey string) (err error) {
+	urlPath := cli.BuildURL("pushers", "set")
+	// A null kind deletes the pusher.
+	req := map[string]interface{}{"app_id": appID, "pushkey": pushKey, "kind": nil}
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, nil)
+	return
+}
+
+// UserTyping sets the typing state of the current user in the room. The timeout is only used when typing is true,
+// after which the server considers the user to have stopped typing. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3roomsroomidtypinguserid
+func (cli *Client) UserTyping(ctx context.Context, roomID string, typing bool, timeout time.Duration) (err error) {
+	req := ReqTyping{Typing: typing}
+	if typing {
+		req.Timeout = timeout.Milliseconds()
+	}
+	u := cli.BuildURL("rooms", roomID, "typing", cli.UserID)
+	err = cli.MakeRequest(ctx, "PUT", u, req, nil)
+	return
+}
+
+// SendReceipt sends a receipt of the given type, e.g. ReceiptTypeRead, for the event. req may be nil, or set
+// ThreadID to send a threaded receipt. See https://spec.matrix.org/v1.4/client-server-api/#post_matrixclientv3roomsroomidreceiptreceipttypeeventid
+func (cli *Client) SendReceipt(ctx context.Context, roomID, eventID, receiptType string, req *ReqReceipt) (err error) {
+	if req == nil {
+		req = &ReqReceipt{}
+	}
+	u := cli.BuildURL("rooms", roomID, "receipt", receiptType, eventID)
+	err = cli.MakeRequest(ctx, "POST", u, req, nil)
+	return
+}
+
+// MarkRead sends a public, unthreaded read receipt for the event.
+func (cli *Client) MarkRead(ctx context.Context, roomID, eventID string) error {
+	return cli.SendReceipt(ctx, roomID, eventID, ReceiptTypeRead, nil)
+}
+
+// SetReadMarkers sets the fully read marker of the room, and optionally sends read receipts at the same time.
+// See https://spec.matrix.org/v1.4/client-server-api/#post_matrixclientv3roomsroomidread_markers
+func (cli *Client) SetReadMarkers(ctx context.Context, roomID string, req *ReqSetReadMarkers) (err error) {
+	u := cli.BuildURL("rooms", roomID, "read_markers")
+	err = cli.MakeRequest(ctx, "POST", u, req, nil)
+	return
+}
+
+// PublicRooms returns a page of the public room directory. If server is empty, the directory of the client's
+// homeserver is returned. limit may be 0 to use the server's default. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3publicrooms
+func (cli *Client) PublicRooms(ctx context.Context, limit int, since string, server string) (resp *RespPublicRooms, err error) {
+	args := map[string]string{}
+	if limit != 0 {
+		args["limit"] = strconv.Itoa(limit)
+	}
+	if since != "" {
+		args["since"] = since
+	}
+	if server != "" {
+		args["server"] = server
+	}
+	urlPath := cli.BuildURLWithQuery([]string{"publicRooms"}, args)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// PublicRoomsFiltered returns a page of the public room directory matching the filter. If server is empty, the
+// directory of the client's homeserver is returned. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3publicrooms
+func (cli *Client) PublicRoomsFiltered(ctx context.Context, server string, req *ReqPublicRoomsFiltered) (resp *RespPublicRooms, err error) {
+	args := map[string]string{}
+	if server != "" {
+		args["server"] = server
+	}
+	urlPath := cli.BuildURLWithQuery([]string{"publicRooms"}, args)
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, &resp)
+	return
+}
+
+// Devices returns the devices of the current user. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3devices
+func (cli *Client) Devices(ctx context.Context) (resp *RespDevices, err error) {
+	urlPath := cli.BuildURL("devices")
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// GetDevice returns a device of the current user. See https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3devicesdeviceid
+func (cli *Client) GetDevice(ctx context.Context, deviceID string) (resp *RespDevice, err error) {
+	urlPath := cli.BuildURL("devices", deviceID)
+	err = cli.MakeRequest(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// UpdateDevice updates the display name of a device of the current user. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3devicesdeviceid
+func (cli *Client) UpdateDevice(ctx context.Context, deviceID string, req *ReqUpdateDevice) (err error) {
+	urlPath := cli.BuildURL("devices", deviceID)
+	err = cli.MakeRequest(ctx, "PUT", urlPath, req, nil)
+	return
+}
+
+// DeleteDevice deletes a device of the current user and invalidates its access token. See https://spec.matrix.org/v1.1/client-server-api/#delete_matrixclientv3devicesdeviceid
+//
+// This requires user-interactive authentication. If the server requires (further) authentication, the returned
+// RespUserInteractive describes the flows and the request must be retried with req.Auth set. req may be nil for
+// the first attempt.
+func (cli *Client) DeleteDevice(ctx context.Context, deviceID string, req *ReqDeleteDevice) (uiaResp *RespUserInteractive, err error) {
+	if req == nil {
+		req = &ReqDeleteDevice{}
+	}
+	urlPath := cli.BuildURL("devices", deviceID)
+	uiaResp, err = cli.makeUserInteractiveRequest(ctx, "DELETE", urlPath, req, nil)
+	return
+}
+
+// DeleteDevices deletes several devices of the current user at once. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3delete_devices
+//
+// This requires user-interactive authentication, see DeleteDevice.
+func (cli *Client) DeleteDevices(ctx context.Context, req *ReqDeleteDevices) (uiaResp *RespUserInteractive, err error) {
+	urlPath := cli.BuildURL("delete_devices")
+	uiaResp, err = cli.makeUserInteractiveRequest(ctx, "POST", urlPath, req, nil)
+	return
+}
+
+// DeleteDevicesWithPassword deletes several devices of the current user, completing user-interactive authentication
+// with the user's password. Returns the RespUserInteractive of the server if it does not offer a flow consisting of
+// the password stage alone.
+func (cli *Client) DeleteDevicesWithPassword(ctx context.Context, deviceIDs []string, password string) (uiaResp *RespUserInteractive, err error) {
+	req := &ReqDeleteDevices{Devices: deviceIDs}
+	if uiaResp, err = cli.DeleteDevices(ctx, req); err != nil || uiaResp == nil {
+		return
+	}
+	if !uiaResp.HasSingleStageFlow(AuthTypePassword) {
+		return
+	}
+	req.Auth = NewAuthPassword(uiaResp.Session, cli.UserID, password)
+	return cli.DeleteDevices(ctx, req)
+}
+
+// UploadKeys publishes end-to-end encryption keys for the device. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysupload
+func (cli *Client) UploadKeys(ctx context.Context, req *ReqUploadKeys) (resp *RespUploadKeys, err error) {
+	urlPath := cli.BuildURL("keys", "upload")
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, &resp)
+	return
+}
+
+// QueryKeys returns the current devices and identity keys for the given users. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysquery
+func (cli *Client) QueryKeys(ctx context.Context, req *ReqQueryKeys) (resp *RespQueryKeys, err error) {
+	urlPath := cli.BuildURL("keys", "query")
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, &resp)
+	return
+}
+
+// ClaimKeys claims one-time keys for use in pre-key messages. See https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysclaim
+func (cli *Client) ClaimKeys(ctx context.Context, req *ReqClaimKeys) (resp *RespClaimKeys, err error) {
+	urlPath := cli.BuildURL("keys", "claim")
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, &resp)
+	return
+}
+
+// SendToDevice sends send-to-device events to a set of client devices. See https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3sendtodeviceeventtypetxnid
+func (cli *Client) SendToDevice(ctx context.Context, eventType string, req *ReqSendToDevice) (resp *RespSendToDevice, err error) {
+	urlPath := cli.BuildURL("sendToDevice", eventType, txnID())
+	err = cli.MakeRequest(ctx, "PUT", urlPath, req, &resp)
 	return
 }
 
 // UploadLink uploads an HTTP URL and then returns an MXC URI.
-func (cli *Client) UploadLink(link string) (*RespMediaUpload, error) {
-	res, err := cli.Client.Get(link)
+func (cli *Client) UploadLink(ctx context.Context, link string) (*RespMediaUpload, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.Client.Do(req)
 	if res != nil {
 		defer res.Body.Close()
 	}
 	if err != nil {
 		return nil, err
 	}
-	return cli.UploadToContentRepo(res.Body, res.Header.Get("Content-Type"), res.ContentLength)
+	return cli.UploadToContentRepo(ctx, res.Body, res.Header.Get("Content-Type"), res.ContentLength)
 }
 
 // UploadToContentRepo uploads the given bytes to the content repository and returns an MXC URI.
-// See http://matrix.org/docs/spec/client_server/r0.2.0.html#post-matrix-media-r0-upload
-func (cli *Client) UploadToContentRepo(content io.Reader, contentType string, contentLength int64) (*RespMediaUpload, error) {
-	req, err := http.NewRequest("POST", cli.BuildBaseURL("_matrix/media/r0/upload"), content)
+// If contentLength is known and exceeds the upload size limit from MediaConfig, an M_TOO_LARGE RespError is
+// returned without uploading anything.
+// See https://spec.matrix.org/v1.11/client-server-api/#post_matrixmediav3upload
+func (cli *Client) UploadToContentRepo(ctx context.Context, content io.Reader, contentType string, contentLength int64) (*RespMediaUpload, error) {
+	if contentLength > 0 {
+		// Servers without a config endpoint are left to enforce their limit themselves.
+		if config, err := cli.MediaConfig(ctx); err == nil && config.UploadSize > 0 && contentLength > config.UploadSize {
+			return nil, RespError{
+				ErrCode: ErrTooLarge.ErrCode,
+				Err:     fmt.Sprintf("Upload of %d bytes exceeds the limit of %d bytes", contentLength, config.UploadSize),
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", cli.BuildBaseURL("_matrix/media/v3/upload"), content)
 	if err != nil {
 		return nil, err
 	}
@@ -690,10 +1117,20 @@ func (cli *Client) UploadToContentRepo(content io.Reader, contentType string, co
 				Code:    res.StatusCode,
 			}
 		}
+		var wrap error
+		var respErr RespError
+		if _ = json.Unmarshal(contents, &respErr); respErr.ErrCode != "" {
+			wrap = respErr
+		}
+		msg := "Upload request failed"
+		if wrap == nil {
+			msg = msg + ": " + string(contents)
+		}
 		return nil, HTTPError{
-			Contents: contents,
-			Message:  "Upload request failed: " + string(contents),
-			Code:     res.StatusCode,
+			Contents:     contents,
+			Message:      msg,
+			Code:         res.StatusCode,
+			WrappedError: wrap,
 		}
 	}
 
@@ -705,6 +1142,19 @@ func (cli *Client) UploadToContentRepo(content io.Reader, contentType string, co
 	return &m, nil
 }
 
+// sleepContext pauses the current goroutine for at least the duration d, returning early
+// with the context's error if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 func txnID() string {
 	return "go" + strconv.FormatInt(time.Now().UnixNano(), 10)
 }
@@ -717,7 +1167,7 @@ func NewClient(homeserverURL, userID, accessToken string) (*Client, error) {
 	}
 	// By default, use an in-memory store which will never save filter ids / next batch tokens to disk.
 	// The client will work with this storer: it just won't remember across restarts.
-	// In practice, a database backend should be used.
+	// In practice, a persistent backend such as a FileStore should be used.
 	store := NewInMemoryStore()
 	cli := Client{
 		AccessToken:   accessToken,
@@ -726,6 +1176,7 @@ func NewClient(homeserverURL, userID, accessToken string) (*Client, error) {
 		Prefix:        "/_matrix/client/v3",
 		Syncer:        NewDefaultSyncer(userID, store),
 		Store:         store,
+		RetryPolicy:   NewDefaultRetryPolicy(),
 	}
 	// By default, use the default HTTP client.
 	cli.Client = http.DefaultClient
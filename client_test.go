@@ -0,0 +1,170 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	cli, err := NewClient(srv.URL, "@alice:example.com", "token")
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+	return cli
+}
+
+func TestMakeRequestContextCancel(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := cli.JoinedRooms(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TestMakeRequestContextCancel => Got: %v Expected: %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSyncContextCancel(t *testing.T) {
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_matrix/client/v3/user/@alice:example.com/filter" {
+			w.Write([]byte(`{"filter_id":"1"}`))
+			return
+		}
+		<-r.Context().Done()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- cli.Sync(ctx) }()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("TestSyncContextCancel => Got: %v Expected: %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TestSyncContextCancel => Sync did not return after its context was cancelled")
+	}
+}
+
+func TestDeleteDevicesWithPassword(t *testing.T) {
+	var attempts int
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/v3/delete_devices" {
+			t.Fatalf("TestDeleteDevicesWithPassword => Got: %s Expected: /_matrix/client/v3/delete_devices", r.URL.Path)
+		}
+		attempts++
+		var req struct {
+			Devices []string `json:"devices"`
+			Auth    *struct {
+				Type     string `json:"type"`
+				Session  string `json:"session"`
+				Password string `json:"password"`
+			} `json:"auth"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Devices) != 2 {
+			t.Fatalf("TestDeleteDevicesWithPassword => Got: %v Expected: 2 devices", req.Devices)
+		}
+		if req.Auth == nil || req.Auth.Session != "sess" || req.Auth.Password != "hunter2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"flows":[{"stages":["m.login.password"]}],"session":"sess"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+
+	uiaResp, err := cli.DeleteDevicesWithPassword(context.Background(), []string{"A", "B"}, "hunter2")
+	if err != nil || uiaResp != nil {
+		t.Fatalf("TestDeleteDevicesWithPassword => Got: %v, %v Expected: success", uiaResp, err)
+	}
+	if attempts != 2 {
+		t.Fatalf("TestDeleteDevicesWithPassword => Got: %d requests Expected: 2", attempts)
+	}
+}
+
+func TestDeviceRequestBodies(t *testing.T) {
+	bodies := make(map[string]string)
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies[r.Method] = string(body)
+		w.Write([]byte(`{}`))
+	}))
+	ctx := context.Background()
+
+	if _, err := cli.DeleteDevice(ctx, "A", nil); err != nil {
+		t.Fatalf("TestDeviceRequestBodies => DeleteDevice: %s", err)
+	}
+	if bodies["DELETE"] != "{}" {
+		t.Fatalf("TestDeviceRequestBodies => DeleteDevice Got: %s Expected: {}", bodies["DELETE"])
+	}
+
+	for _, tc := range []struct {
+		req      ReqUpdateDevice
+		expected string
+	}{
+		{ReqUpdateDevice{}, `{}`},
+		{ReqUpdateDevice{DisplayName: new(string)}, `{"display_name":""}`},
+	} {
+		if err := cli.UpdateDevice(ctx, "A", &tc.req); err != nil {
+			t.Fatalf("TestDeviceRequestBodies => UpdateDevice: %s", err)
+		}
+		if bodies["PUT"] != tc.expected {
+			t.Fatalf("TestDeviceRequestBodies => UpdateDevice Got: %s Expected: %s", bodies["PUT"], tc.expected)
+		}
+	}
+}
+
+func TestSetDisplayNameUpdateRoomProfiles(t *testing.T) {
+	updated := make(map[string]string)
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const state = "/state/m.room.member/@alice:example.com"
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/profile/@alice:example.com/displayname":
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/_matrix/client/v3/joined_rooms":
+			w.Write([]byte(`{"joined_rooms":["!a:example.com","!b:example.com"]}`))
+		case strings.HasSuffix(r.URL.Path, state) && r.Method == "GET":
+			if strings.Contains(r.URL.Path, "!b:example.com") {
+				w.Write([]byte(`{"membership":"join","displayname":"Alice"}`))
+				return
+			}
+			w.Write([]byte(`{"membership":"join","displayname":"Old","avatar_url":"mxc://example.com/a"}`))
+		case strings.HasSuffix(r.URL.Path, state) && r.Method == "PUT":
+			var content map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&content)
+			if content["avatar_url"] != "mxc://example.com/a" {
+				t.Errorf("TestSetDisplayNameUpdateRoomProfiles => the other fields of the member event were not kept: %v", content)
+			}
+			updated[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/rooms/"), state)] = content["displayname"].(string)
+			w.Write([]byte(`{"event_id":"$1"}`))
+		default:
+			t.Fatalf("TestSetDisplayNameUpdateRoomProfiles => unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	cli.UpdateRoomProfiles = true
+
+	if err := cli.SetDisplayName(context.Background(), "Alice"); err != nil {
+		t.Fatalf("TestSetDisplayNameUpdateRoomProfiles => SetDisplayName: %s", err)
+	}
+	if len(updated) != 1 || updated["!a:example.com"] != "Alice" {
+		t.Fatalf("TestSetDisplayNameUpdateRoomProfiles => Got: %v Expected: only !a:example.com updated", updated)
+	}
+}
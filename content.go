@@ -0,0 +1,222 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrUnknownEventType is returned by Event.ParseContent if no content type is registered for the event.
+var ErrUnknownEventType = errors.New("no content type registered for event type")
+
+// MemberContent is the content of an m.room.member event - https://spec.matrix.org/v1.1/client-server-api/#mroommember
+type MemberContent struct {
+	AvatarURL        string `json:"avatar_url,omitempty"`
+	DisplayName      string `json:"displayname,omitempty"`
+	IsDirect         bool   `json:"is_direct,omitempty"`
+	JoinAuthorised   string `json:"join_authorised_via_users_server,omitempty"`
+	Membership       string `json:"membership"`
+	Reason           string `json:"reason,omitempty"`
+	ThirdPartyInvite *struct {
+		DisplayName string `json:"display_name"`
+	} `json:"third_party_invite,omitempty"`
+}
+
+// NameContent is the content of an m.room.name event - https://spec.matrix.org/v1.1/client-server-api/#mroomname
+type NameContent struct {
+	Name string `json:"name"`
+}
+
+// TopicContent is the content of an m.room.topic event - https://spec.matrix.org/v1.1/client-server-api/#mroomtopic
+type TopicContent struct {
+	Topic string `json:"topic"`
+}
+
+// AvatarContent is the content of an m.room.avatar event - https://spec.matrix.org/v1.1/client-server-api/#mroomavatar
+type AvatarContent struct {
+	URL  string    `json:"url,omitempty"`
+	Info ImageInfo `json:"info,omitempty"`
+}
+
+// CanonicalAliasContent is the content of an m.room.canonical_alias event - https://spec.matrix.org/v1.1/client-server-api/#mroomcanonical_alias
+type CanonicalAliasContent struct {
+	Alias      string   `json:"alias,omitempty"`
+	AltAliases []string `json:"alt_aliases,omitempty"`
+}
+
+// CreateContent is the content of an m.room.create event - https://spec.matrix.org/v1.1/client-server-api/#mroomcreate
+type CreateContent struct {
+	Creator     string `json:"creator,omitempty"`
+	Federate    *bool  `json:"m.federate,omitempty"`
+	RoomVersion string `json:"room_version,omitempty"` // Defaults to "1" if empty.
+	Type        string `json:"type,omitempty"`
+	Predecessor *struct {
+		EventID string `json:"event_id"`
+		RoomID  string `json:"room_id"`
+	} `json:"predecessor,omitempty"`
+}
+
+// JoinRulesContent is the content of an m.room.join_rules event - https://spec.matrix.org/v1.1/client-server-api/#mroomjoin_rules
+type JoinRulesContent struct {
+	JoinRule string `json:"join_rule"`
+	Allow    []struct {
+		RoomID string `json:"room_id,omitempty"`
+		Type   string `json:"type"`
+	} `json:"allow,omitempty"`
+}
+
+// HistoryVisibilityContent is the content of an m.room.history_visibility event - https://spec.matrix.org/v1.1/client-server-api/#mroomhistory_visibility
+type HistoryVisibilityContent struct {
+	HistoryVisibility string `json:"history_visibility"`
+}
+
+// GuestAccessContent is the content of an m.room.guest_access event - https://spec.matrix.org/v1.1/client-server-api/#mroomguest_access
+type GuestAccessContent struct {
+	GuestAccess string `json:"guest_access"`
+}
+
+// EncryptionContent is the content of an m.room.encryption event - https://spec.matrix.org/v1.1/client-server-api/#mroomencryption
+type EncryptionContent struct {
+	Algorithm          string `json:"algorithm"`
+	RotationPeriodMs   int64  `json:"rotation_period_ms,omitempty"`
+	RotationPeriodMsgs int    `json:"rotation_period_msgs,omitempty"`
+}
+
+// RedactionContent is the content of an m.room.redaction event - https://spec.matrix.org/v1.1/client-server-api/#mroomredaction
+type RedactionContent struct {
+	Reason  string `json:"reason,omitempty"`
+	Redacts string `json:"redacts,omitempty"` // Only in room versions which move redacts into the content.
+}
+
+// TypingContent is the content of an m.typing event - https://spec.matrix.org/v1.1/client-server-api/#mtyping
+type TypingContent struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// The registries used by Event.ParseContent. Message types take precedence over event types for m.room.message.
+var contentTypes = struct {
+	sync.RWMutex
+	events   map[string]reflect.Type
+	messages map[string]reflect.Type
+}{
+	events: map[string]reflect.Type{
+		"m.room.message":            reflect.TypeOf(TextMessage{}),
+		"m.sticker":                 reflect.TypeOf(ImageMessage{}),
+		"m.room.member":             reflect.TypeOf(MemberContent{}),
+		"m.room.name":               reflect.TypeOf(NameContent{}),
+		"m.room.topic":              reflect.TypeOf(TopicContent{}),
+		"m.room.avatar":             reflect.TypeOf(AvatarContent{}),
+		"m.room.canonical_alias":    reflect.TypeOf(CanonicalAliasContent{}),
+		"m.room.create":             reflect.TypeOf(CreateContent{}),
+		"m.room.join_rules":         reflect.TypeOf(JoinRulesContent{}),
+		"m.room.history_visibility": reflect.TypeOf(HistoryVisibilityContent{}),
+		"m.room.guest_access":       reflect.TypeOf(GuestAccessContent{}),
+		"m.room.encryption":         reflect.TypeOf(EncryptionContent{}),
+		"m.room.power_levels":       reflect.TypeOf(RespPowerLevels{}),
+		"m.room.redaction":          reflect.TypeOf(RedactionContent{}),
+		"m.typing":                  reflect.TypeOf(TypingContent{}),
+		"m.receipt":                 reflect.TypeOf(ReceiptContent{}),
+		"m.tag":                     reflect.TypeOf(TagContent{}),
+		"m.direct":                  reflect.TypeOf(DirectContent{}),
+		"m.ignored_user_list":       reflect.TypeOf(IgnoredUserListContent{}),
+		"m.fully_read":              reflect.TypeOf(FullyReadContent{}),
+		"m.reaction":                reflect.TypeOf(ReactionContent{}),
+	},
+	messages: map[string]reflect.Type{
+		"m.text":     reflect.TypeOf(TextMessage{}),
+		"m.notice":   reflect.TypeOf(TextMessage{}),
+		"m.emote":    reflect.TypeOf(TextMessage{}),
+		"m.image":    reflect.TypeOf(ImageMessage{}),
+		"m.video":    reflect.TypeOf(VideoMessage{}),
+		"m.audio":    reflect.TypeOf(AudioMessage{}),
+		"m.file":     reflect.TypeOf(FileMessage{}),
+		"m.location": reflect.TypeOf(LocationMessage{}),
+	},
+}
+
+// RegisterEventType registers the struct used by Event.ParseContent for events of the given type, replacing any
+// previous registration. content may be a struct or a pointer to one, e.g. MyContent{} or (*MyContent)(nil).
+func RegisterEventType(eventType string, content interface{}) {
+	contentTypes.Lock()
+	defer contentTypes.Unlock()
+	contentTypes.events[eventType] = contentType(content)
+}
+
+// RegisterMessageType registers the struct used by Event.ParseContent for m.room.message events with the given
+// msgtype, replacing any previous registration. See RegisterEventType.
+func RegisterMessageType(msgtype string, content interface{}) {
+	contentTypes.Lock()
+	defer contentTypes.Unlock()
+	contentTypes.messages[msgtype] = contentType(content)
+}
+
+func contentType(content interface{}) reflect.Type {
+	t := reflect.TypeOf(content)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// parsedContent caches the result of Event.ParseContent. It is shared by copies of the event.
+type parsedContent struct {
+	once    sync.Once
+	content interface{}
+	err     error
+}
+
+// ParseContent decodes the event content into the struct registered for the event type, or for the msgtype of
+// m.room.message events, and returns a pointer to it, e.g. *TextMessage or *MemberContent. Returns an error
+// wrapping ErrUnknownEventType if nothing is registered.
+//
+// For events decoded from JSON the result is cached, and the content is decoded from the original JSON rather
+// than from Content, so later changes to Content are not reflected.
+func (event *Event) ParseContent() (interface{}, error) {
+	if event.parsed == nil {
+		return parseContent(event)
+	}
+	event.parsed.once.Do(func() {
+		event.parsed.content, event.parsed.err = parseContent(event)
+	})
+	return event.parsed.content, event.parsed.err
+}
+
+func parseContent(event *Event) (interface{}, error) {
+	contentTypes.RLock()
+	t, ok := contentTypes.events[event.Type]
+	if event.Type == "m.room.message" {
+		if msgtype, _ := event.MessageType(); contentTypes.messages[msgtype] != nil {
+			t, ok = contentTypes.messages[msgtype], true
+		}
+	}
+	contentTypes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEventType, event.Type)
+	}
+
+	var raw json.RawMessage
+	if event.Raw != nil {
+		var e struct {
+			Content json.RawMessage `json:"content"`
+		}
+		if err := json.Unmarshal(event.Raw, &e); err != nil {
+			return nil, err
+		}
+		raw = e.Content
+	} else {
+		var err error
+		if raw, err = json.Marshal(event.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	content := reflect.New(t).Interface()
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, content); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
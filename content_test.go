@@ -0,0 +1,64 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEventParseContent(t *testing.T) {
+	raw := `{"type":"m.room.message","event_id":"$1","content":{"msgtype":"m.image","body":"cat.png","url":"mxc://example.com/cat","x.custom":1},"x.top":true}`
+	var event Event
+	if err := json.Unmarshal([]byte(raw), &event); err != nil {
+		t.Fatalf("TestEventParseContent => Failed to unmarshal: %s", err)
+	}
+	if string(event.Raw) != raw {
+		t.Fatalf("TestEventParseContent => Raw Got: %s Expected: %s", event.Raw, raw)
+	}
+	if data, err := json.Marshal(event); err != nil || string(data) != raw {
+		t.Fatalf("TestEventParseContent => Marshal Got: %s, %v Expected: %s", data, err, raw)
+	}
+	changed := event
+	changed.RoomID = "!room:example.com"
+	if data, err := json.Marshal(changed); err != nil || !strings.Contains(string(data), `"room_id":"!room:example.com"`) {
+		t.Fatalf("TestEventParseContent => Marshal Got: %s, %v Expected: the changed room ID", data, err)
+	}
+
+	content, err := event.ParseContent()
+	if err != nil {
+		t.Fatalf("TestEventParseContent => ParseContent: %s", err)
+	}
+	image, ok := content.(*ImageMessage)
+	if !ok || image.URL != "mxc://example.com/cat" {
+		t.Fatalf("TestEventParseContent => Got: %#v Expected: an *ImageMessage", content)
+	}
+	if again, _ := event.ParseContent(); again != content {
+		t.Fatal("TestEventParseContent => ParseContent did not return the cached content")
+	}
+
+	stateKey := "@alice:example.com"
+	member := Event{Type: "m.room.member", StateKey: &stateKey, Content: map[string]interface{}{"membership": "join", "displayname": "Alice"}}
+	content, err = member.ParseContent()
+	if m, ok := content.(*MemberContent); err != nil || !ok || m.DisplayName != "Alice" {
+		t.Fatalf("TestEventParseContent => Got: %#v, %v Expected: a *MemberContent", content, err)
+	}
+
+	unknown := Event{Type: "com.example.unknown"}
+	if _, err = unknown.ParseContent(); !errors.Is(err, ErrUnknownEventType) {
+		t.Fatalf("TestEventParseContent => Got: %v Expected: %v", err, ErrUnknownEventType)
+	}
+}
+
+func TestRegisterEventType(t *testing.T) {
+	type pollContent struct {
+		Question string `json:"question"`
+	}
+	RegisterEventType("com.example.poll", (*pollContent)(nil))
+
+	event := Event{Type: "com.example.poll", Content: map[string]interface{}{"question": "Tea?"}}
+	content, err := event.ParseContent()
+	if poll, ok := content.(*pollContent); err != nil || !ok || poll.Question != "Tea?" {
+		t.Fatalf("TestRegisterEventType => Got: %#v, %v Expected: a *pollContent", content, err)
+	}
+}
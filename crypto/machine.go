@@ -0,0 +1,978 @@
+// Package crypto implements end-to-end encryption with Olm and Megolm for gomatrix clients.
+//
+// Specification can be found at https://spec.matrix.org/v1.1/client-server-api/#end-to-end-encryption
+package crypto
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qua3k/gomatrix"
+)
+
+// The encryption algorithms implemented by this package.
+const (
+	AlgorithmOlm    = "m.olm.v1.curve25519-aes-sha2"
+	AlgorithmMegolm = "m.megolm.v1.aes-sha2"
+)
+
+var (
+	// ErrNoSession is returned by OlmMachine.DecryptEvent if the room key of the event has not been received.
+	ErrNoSession = errors.New("no room key to decrypt the event")
+	// ErrReplayedMessage is returned by OlmMachine.DecryptEvent if the message index of the event was already
+	// used by a different event, which means the ciphertext has been replayed.
+	ErrReplayedMessage = errors.New("message index was already used by another event")
+	// ErrUnknownSender is returned by OlmMachine.DecryptEvent if the room key of the event was not shared by
+	// a device of the event's sender, which means the sender may have been forged.
+	ErrUnknownSender = errors.New("room key does not belong to a device of the sender")
+
+	errNotLoaded            = errors.New("OlmMachine.Load has not been called")
+	errUnsupportedAlgorithm = errors.New("unsupported encryption algorithm")
+	errNotForUs             = errors.New("event was not encrypted for this device")
+	errNoOlmSession         = errors.New("no Olm session to decrypt the message")
+	errPayloadMismatch      = errors.New("decrypted payload does not match the event")
+)
+
+// OlmContent is the content of an m.room.encrypted to-device event encrypted with Olm.
+type OlmContent struct {
+	Algorithm  string                   `json:"algorithm"`
+	SenderKey  string                   `json:"sender_key"`
+	Ciphertext map[string]OlmCiphertext `json:"ciphertext"` // Keyed by the Curve25519 key of the recipient.
+}
+
+// OlmCiphertext is a single Olm message.
+type OlmCiphertext struct {
+	Type int    `json:"type"`
+	Body string `json:"body"`
+}
+
+// MegolmContent is the content of an m.room.encrypted room event encrypted with Megolm.
+type MegolmContent struct {
+	Algorithm  string          `json:"algorithm"`
+	SenderKey  string          `json:"sender_key"`
+	DeviceID   string          `json:"device_id"`
+	SessionID  string          `json:"session_id"`
+	Ciphertext string          `json:"ciphertext"`
+	RelatesTo  json.RawMessage `json:"m.relates_to,omitempty"` // Relations are not encrypted.
+}
+
+// olmPayload is the plaintext of an Olm message.
+type olmPayload struct {
+	Type          string          `json:"type"`
+	Content       json.RawMessage `json:"content"`
+	Sender        string          `json:"sender"`
+	Recipient     string          `json:"recipient"`
+	RecipientKeys ed25519Keys     `json:"recipient_keys"`
+	Keys          ed25519Keys     `json:"keys"`
+}
+
+type ed25519Keys struct {
+	Ed25519 string `json:"ed25519"`
+}
+
+// megolmPayload is the plaintext of a Megolm message.
+type megolmPayload struct {
+	Type    string          `json:"type"`
+	Content json.RawMessage `json:"content"`
+	RoomID  string          `json:"room_id"`
+}
+
+// roomKeyContent is the content of an m.room_key event.
+type roomKeyContent struct {
+	Algorithm  string `json:"algorithm"`
+	RoomID     string `json:"room_id"`
+	SessionID  string `json:"session_id"`
+	SessionKey string `json:"session_key"`
+}
+
+// OlmMachine implements end-to-end encryption for a single device. Set it as the Decrypter of the DefaultSyncer to
+// decrypt incoming events, and use SendEncrypted to send events to encrypted rooms:
+//
+//	machine := crypto.NewOlmMachine(cli, deviceID, store)
+//	if err := machine.Load(ctx); err != nil {
+//		panic(err)
+//	}
+//	cli.Syncer.(*gomatrix.DefaultSyncer).Decrypter = machine
+//
+// The members of a room are read from the client's Store, falling back to /joined_members. Devices are trusted on
+// first use: once a device has been seen, changes to its keys are ignored.
+//
+// It is safe to use the machine from multiple goroutines. Requests to the homeserver are made without holding the
+// machine's lock, so a slow homeserver does not hold up decryption; calls to EncryptEvent wait for each other.
+type OlmMachine struct {
+	Client   *gomatrix.Client
+	DeviceID string
+	Store    Store
+
+	mu         sync.Mutex
+	account    *Account
+	outdated   map[string]bool              // Users whose devices have changed since they were last queried.
+	seen       map[string]map[uint32]string // The event ID decrypted at each index of each inbound group session.
+	uploading  bool                         // Whether keys are being uploaded.
+	encrypting chan struct{}                // Holds a value while EncryptEvent runs.
+}
+
+// NewOlmMachine creates an OlmMachine for the client's device. Load must be called before using it.
+func NewOlmMachine(cli *gomatrix.Client, deviceID string, store Store) *OlmMachine {
+	return &OlmMachine{
+		Client:     cli,
+		DeviceID:   deviceID,
+		Store:      store,
+		outdated:   make(map[string]bool),
+		seen:       make(map[string]map[uint32]string),
+		encrypting: make(chan struct{}, 1),
+	}
+}
+
+// Load loads the account from the store, creating it if needed, and uploads the device keys and one-time keys
+// if they have not been uploaded yet.
+func (m *OlmMachine) Load(ctx context.Context) error {
+	if err := m.loadAccount(); err != nil {
+		return err
+	}
+	return m.uploadKeys(ctx)
+}
+
+// loadAccount loads the account from the store, creating it if needed.
+func (m *OlmMachine) loadAccount() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	account, err := m.Store.LoadAccount()
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		if account, err = NewAccount(); err != nil {
+			return err
+		}
+		if err = account.GenerateOneTimeKeys(MaxOneTimeKeys / 2); err != nil {
+			return err
+		}
+		if err = m.Store.SaveAccount(account); err != nil {
+			return err
+		}
+	}
+	m.account = account
+	return nil
+}
+
+// IdentityKey returns the Curve25519 identity key of the device. Load must have been called.
+func (m *OlmMachine) IdentityKey() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.account.Curve25519()
+}
+
+// SigningKey returns the Ed25519 fingerprint key of the device. Load must have been called.
+func (m *OlmMachine) SigningKey() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.account.Ed25519()
+}
+
+// ProcessSyncResponse implements gomatrix.Decrypter. It decrypts the to-device events of the response in place,
+// storing the room keys they contain, tracks device list changes and tops up the published one-time keys.
+//
+// To-device events which cannot be decrypted are left as they are. Failing to upload one-time keys is not an
+// error, as it is retried on the next sync.
+func (m *OlmMachine) ProcessSyncResponse(ctx context.Context, res *gomatrix.RespSync, since string) error {
+	m.mu.Lock()
+	err := m.processSyncResponse(res)
+	m.mu.Unlock()
+	if err != nil || res.DeviceOneTimeKeysCount == nil {
+		return err
+	}
+	m.uploadKeys(ctx)
+	return nil
+}
+
+// processSyncResponse handles the parts of ProcessSyncResponse which need no requests. Must be called with the
+// lock held.
+func (m *OlmMachine) processSyncResponse(res *gomatrix.RespSync) error {
+	if m.account == nil {
+		return errNotLoaded
+	}
+	for _, userID := range res.DeviceLists.Changed {
+		m.outdated[userID] = true
+	}
+	for _, userID := range res.DeviceLists.Left {
+		m.outdated[userID] = true
+	}
+
+	for i := range res.ToDevice.Events {
+		event := &res.ToDevice.Events[i]
+		if event.Type != "m.room.encrypted" {
+			continue
+		}
+		if decrypted, err := m.decryptOlmEvent(event); err == nil {
+			res.ToDevice.Events[i] = *decrypted
+		}
+	}
+
+	if res.DeviceOneTimeKeysCount == nil {
+		return nil
+	}
+	missing := MaxOneTimeKeys/2 - res.DeviceOneTimeKeysCount["signed_curve25519"] - len(m.account.UnpublishedOneTimeKeys())
+	if missing > 0 {
+		if err := m.account.GenerateOneTimeKeys(missing); err != nil {
+			return err
+		}
+		return m.Store.SaveAccount(m.account)
+	}
+	return nil
+}
+
+// DecryptEvent implements gomatrix.Decrypter. It decrypts a Megolm encrypted room event, returning a new event with
+// the decrypted type and content. Returns ErrNoSession if the room key for the event has not been received, and
+// ErrUnknownSender if it was not shared by a device of the event's sender. The sender's devices are queried if
+// they are not known yet.
+func (m *OlmMachine) DecryptEvent(ctx context.Context, event *gomatrix.Event) (*gomatrix.Event, error) {
+	var content MegolmContent
+	if err := remarshal(event.Content, &content); err != nil {
+		return nil, err
+	}
+	if content.Algorithm != AlgorithmMegolm {
+		return nil, errUnsupportedAlgorithm
+	}
+
+	m.mu.Lock()
+	session, plaintext, index, err := m.decryptMegolm(event.RoomID, &content)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err = m.verifySender(ctx, event.Sender, session); err != nil {
+		return nil, err
+	}
+	if err = m.markSeen(session, index, event.ID); err != nil {
+		return nil, err
+	}
+
+	var payload megolmPayload
+	if err = json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, err
+	}
+	if payload.RoomID != event.RoomID {
+		return nil, errPayloadMismatch
+	}
+	decrypted := &gomatrix.Event{
+		Type:      payload.Type,
+		ID:        event.ID,
+		RoomID:    event.RoomID,
+		Sender:    event.Sender,
+		Timestamp: event.Timestamp,
+		Unsigned:  event.Unsigned,
+		Redacts:   event.Redacts,
+	}
+	if err = json.Unmarshal(payload.Content, &decrypted.Content); err != nil {
+		return nil, err
+	}
+	if _, ok := decrypted.Content["m.relates_to"]; !ok && content.RelatesTo != nil {
+		var relatesTo interface{}
+		if json.Unmarshal(content.RelatesTo, &relatesTo) == nil {
+			decrypted.Content["m.relates_to"] = relatesTo
+		}
+	}
+	return decrypted, nil
+}
+
+// decryptMegolm decrypts the ciphertext with the inbound group session it was encrypted with, returning the session
+// and the message index. Must be called with the lock held.
+func (m *OlmMachine) decryptMegolm(roomID string, content *MegolmContent) (*InboundGroupSession, []byte, uint32, error) {
+	session, err := m.Store.LoadInboundGroupSession(roomID, content.SenderKey, content.SessionID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if session == nil {
+		return nil, nil, 0, ErrNoSession
+	}
+	plaintext, index, err := session.Decrypt(content.Ciphertext)
+	return session, plaintext, index, err
+}
+
+// verifySender returns ErrUnknownSender unless the session was shared by a device of the user. The user's devices
+// are queried again if none of the known ones matches, in case the session is from a device we were not told about.
+func (m *OlmMachine) verifySender(ctx context.Context, userID string, session *InboundGroupSession) error {
+	if userID == m.Client.UserID && session.SenderKey == m.IdentityKey() {
+		return nil
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		devices, err := m.getDevices(ctx, []string{userID})
+		if err != nil {
+			return err
+		}
+		for _, device := range devices[userID] {
+			if device.IdentityKey == session.SenderKey && device.SigningKey == session.ClaimedKey {
+				return nil
+			}
+		}
+		m.mu.Lock()
+		m.outdated[userID] = true
+		m.mu.Unlock()
+	}
+	return ErrUnknownSender
+}
+
+// markSeen records that the event was decrypted at the index of the session, returning ErrReplayedMessage if
+// a different event was. Seen indexes are only kept in memory, so that decrypting does not write to the store.
+func (m *OlmMachine) markSeen(session *InboundGroupSession, index uint32, eventID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := groupSessionKey(session.RoomID, session.SenderKey, session.ID())
+	if seen, ok := m.seen[key][index]; ok && seen != eventID {
+		return ErrReplayedMessage
+	}
+	if m.seen[key] == nil {
+		m.seen[key] = make(map[uint32]string)
+	}
+	m.seen[key][index] = eventID
+	return nil
+}
+
+// EncryptEvent encrypts an event for the room, returning the content of the m.room.encrypted event to send.
+//
+// The room's Megolm session is created or rotated as needed, and shared with every device of the joined and
+// invited members which has not received it yet. A new session is created when a member the session was shared
+// with has left the room, or when it has expired according to the room's m.room.encryption event.
+func (m *OlmMachine) EncryptEvent(ctx context.Context, roomID, eventType string, content interface{}) (*MegolmContent, error) {
+	// Only one event is encrypted at a time, so that a room never gets two sessions. The lock is not held during
+	// the requests to share the session, which only EncryptEvent uses.
+	select {
+	case m.encrypting <- struct{}{}:
+		defer func() { <-m.encrypting }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	m.mu.Lock()
+	loaded := m.account != nil
+	m.mu.Unlock()
+	if !loaded {
+		return nil, errNotLoaded
+	}
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	members, err := m.roomMembers(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	session, err := m.outboundGroupSession(roomID, members)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.shareGroupSession(ctx, session, members); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(&megolmPayload{Type: eventType, Content: raw, RoomID: roomID})
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := session.Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Store.SaveOutboundGroupSession(session); err != nil {
+		return nil, err
+	}
+	encrypted := &MegolmContent{
+		Algorithm:  AlgorithmMegolm,
+		SenderKey:  m.account.Curve25519(),
+		DeviceID:   m.DeviceID,
+		SessionID:  session.ID(),
+		Ciphertext: ciphertext,
+	}
+	var relation struct {
+		RelatesTo json.RawMessage `json:"m.relates_to"`
+	}
+	if json.Unmarshal(raw, &relation) == nil {
+		encrypted.RelatesTo = relation.RelatesTo
+	}
+	return encrypted, nil
+}
+
+// SendEncrypted encrypts the event with EncryptEvent and sends it to the room.
+func (m *OlmMachine) SendEncrypted(ctx context.Context, roomID, eventType string, content interface{}) (*gomatrix.RespSendEvent, error) {
+	encrypted, err := m.EncryptEvent(ctx, roomID, eventType, content)
+	if err != nil {
+		return nil, err
+	}
+	return m.Client.SendMessageEvent(ctx, roomID, "m.room.encrypted", encrypted)
+}
+
+// uploadKeys uploads the device keys if they have not been uploaded yet and the unpublished one-time keys. It does
+// nothing if there is nothing to upload or if another upload is in progress. Must be called without the lock held.
+func (m *OlmMachine) uploadKeys(ctx context.Context) error {
+	m.mu.Lock()
+	if m.account == nil || m.uploading {
+		m.mu.Unlock()
+		return nil
+	}
+	req, keyIDs, err := m.keysToUpload()
+	if err != nil || req == nil {
+		m.mu.Unlock()
+		return err
+	}
+	m.uploading = true
+	m.mu.Unlock()
+
+	_, err = m.Client.UploadKeys(ctx, req)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploading = false
+	if err != nil {
+		return err
+	}
+	// Keys generated during the upload are left for the next one.
+	m.account.Shared = true
+	m.account.markPublished(keyIDs)
+	return m.Store.SaveAccount(m.account)
+}
+
+// keysToUpload returns the request to upload the device keys if needed and the unpublished one-time keys, along
+// with the IDs of those keys, or a nil request if there is nothing to upload. Must be called with the lock held.
+func (m *OlmMachine) keysToUpload() (*gomatrix.ReqUploadKeys, []string, error) {
+	unpublished := m.account.UnpublishedOneTimeKeys()
+	if m.account.Shared && len(unpublished) == 0 {
+		return nil, nil, nil
+	}
+	req := &gomatrix.ReqUploadKeys{OneTimeKeys: make(map[string]gomatrix.OneTimeKey)}
+	if !m.account.Shared {
+		keys := &gomatrix.DeviceKeys{
+			UserID:     m.Client.UserID,
+			DeviceID:   m.DeviceID,
+			Algorithms: []string{AlgorithmOlm, AlgorithmMegolm},
+			Keys: map[string]string{
+				"curve25519:" + m.DeviceID: m.account.Curve25519(),
+				"ed25519:" + m.DeviceID:    m.account.Ed25519(),
+			},
+		}
+		signatures, err := m.sign(keys)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys.Signatures = signatures
+		req.DeviceKeys = keys
+	}
+	keyIDs := make([]string, 0, len(unpublished))
+	for keyID, key := range unpublished {
+		otk := gomatrix.OneTimeKey{Key: key}
+		signatures, err := m.sign(&otk)
+		if err != nil {
+			return nil, nil, err
+		}
+		otk.Signatures = signatures
+		req.OneTimeKeys["signed_curve25519:"+keyID] = otk
+		keyIDs = append(keyIDs, keyID)
+	}
+	return req, keyIDs, nil
+}
+
+// sign returns the signatures object for the JSON object v, which must not contain signatures yet.
+func (m *OlmMachine) sign(v interface{}) (map[string]map[string]string, error) {
+	data, err := canonicalJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]map[string]string{
+		m.Client.UserID: {"ed25519:" + m.DeviceID: m.account.Sign(data)},
+	}, nil
+}
+
+// verifySignature returns true if the JSON object has a valid signature by the user's Ed25519 key. The object must
+// be the JSON as it was received, as fields unknown to the Go types are signed too.
+func verifySignature(data json.RawMessage, userID, keyID, signingKey string) bool {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return false
+	}
+	var signatures map[string]map[string]string
+	if err := json.Unmarshal(object["signatures"], &signatures); err != nil {
+		return false
+	}
+	delete(object, "signatures")
+	delete(object, "unsigned")
+	key, err := decodeBase64(signingKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	signature, err := decodeBase64(signatures[userID][keyID])
+	if err != nil {
+		return false
+	}
+	canonical, err := canonicalJSON(object)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, canonical, signature)
+}
+
+// decryptOlmEvent decrypts an Olm encrypted to-device event and handles the m.room_key events it contains.
+func (m *OlmMachine) decryptOlmEvent(event *gomatrix.Event) (*gomatrix.Event, error) {
+	var content OlmContent
+	if err := remarshal(event.Content, &content); err != nil {
+		return nil, err
+	}
+	if content.Algorithm != AlgorithmOlm {
+		return nil, errUnsupportedAlgorithm
+	}
+	ciphertext, ok := content.Ciphertext[m.account.Curve25519()]
+	if !ok {
+		return nil, errNotForUs
+	}
+	plaintext, err := m.decryptOlm(content.SenderKey, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload olmPayload
+	if err = json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Sender != event.Sender || payload.Recipient != m.Client.UserID ||
+		payload.RecipientKeys.Ed25519 != m.account.Ed25519() {
+		return nil, errPayloadMismatch
+	}
+	// The claimed signing key must match the one we know for the sending device, if any.
+	devices, err := m.Store.LoadDevices(event.Sender)
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		if device.IdentityKey == content.SenderKey && device.SigningKey != payload.Keys.Ed25519 {
+			return nil, errPayloadMismatch
+		}
+	}
+
+	decrypted := &gomatrix.Event{Type: payload.Type, Sender: event.Sender}
+	if err = json.Unmarshal(payload.Content, &decrypted.Content); err != nil {
+		return nil, err
+	}
+	if decrypted.Type == "m.room_key" {
+		if err = m.addRoomKey(content.SenderKey, payload.Keys.Ed25519, decrypted); err != nil {
+			return nil, err
+		}
+	}
+	return decrypted, nil
+}
+
+// decryptOlm decrypts an Olm message from the device with the given identity key, creating a new inbound session
+// for pre-key messages which do not match an existing one.
+func (m *OlmMachine) decryptOlm(senderKey string, ciphertext OlmCiphertext) ([]byte, error) {
+	sessions, err := m.Store.LoadSessions(senderKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if ciphertext.Type == MessageTypePreKey && !session.MatchesInboundSession(ciphertext.Body) {
+			continue
+		}
+		plaintext, err := session.Decrypt(ciphertext.Type, ciphertext.Body)
+		if err != nil {
+			if ciphertext.Type == MessageTypePreKey {
+				return nil, err
+			}
+			continue
+		}
+		return plaintext, m.Store.SaveSession(senderKey, session)
+	}
+	if ciphertext.Type != MessageTypePreKey {
+		return nil, errNoOlmSession
+	}
+
+	data, err := decodeBase64(ciphertext.Body)
+	if err != nil {
+		return nil, err
+	}
+	preKey, err := decodeOlmPreKeyMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	if encodeBase64(preKey.identityKey) != senderKey {
+		return nil, errPayloadMismatch
+	}
+	// NewInboundSession removes the one-time key, which must only happen if the message can be decrypted.
+	oneTimeKeys := append([]oneTimeKey(nil), m.account.OneTimeKeys...)
+	session, err := m.account.NewInboundSession(ciphertext.Body)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := session.Decrypt(ciphertext.Type, ciphertext.Body)
+	if err != nil {
+		m.account.OneTimeKeys = oneTimeKeys
+		return nil, err
+	}
+	if err = m.Store.SaveSession(senderKey, session); err != nil {
+		return nil, err
+	}
+	return plaintext, m.Store.SaveAccount(m.account)
+}
+
+// addRoomKey stores the Megolm session shared in an m.room_key event, unless a session which can decrypt at least
+// as many messages is already known.
+func (m *OlmMachine) addRoomKey(senderKey, claimedKey string, event *gomatrix.Event) error {
+	var content roomKeyContent
+	if err := remarshal(event.Content, &content); err != nil {
+		return err
+	}
+	if content.Algorithm != AlgorithmMegolm {
+		return errUnsupportedAlgorithm
+	}
+	session, err := NewInboundGroupSession(content.RoomID, senderKey, claimedKey, content.SessionKey)
+	if err != nil {
+		return err
+	}
+	if session.ID() != content.SessionID {
+		return errPayloadMismatch
+	}
+	existing, err := m.Store.LoadInboundGroupSession(content.RoomID, senderKey, content.SessionID)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.FirstKnownIndex() <= session.FirstKnownIndex() {
+		return nil
+	}
+	return m.Store.SaveInboundGroupSession(session)
+}
+
+// roomMembers returns the joined and invited members of the room.
+func (m *OlmMachine) roomMembers(ctx context.Context, roomID string) (map[string]bool, error) {
+	members := make(map[string]bool)
+	if room := m.Client.Store.LoadRoom(roomID); room != nil {
+		for userID, event := range room.GetStateEvents("m.room.member") {
+			if membership, _ := event.Content["membership"].(string); membership == "join" || membership == "invite" {
+				members[userID] = true
+			}
+		}
+	}
+	if len(members) > 0 {
+		return members, nil
+	}
+	resp, err := m.Client.JoinedMembers(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	for userID := range resp.Joined {
+		members[userID] = true
+	}
+	return members, nil
+}
+
+// outboundGroupSession returns the room's current Megolm session, creating a new one if there is none or if it
+// must be rotated.
+func (m *OlmMachine) outboundGroupSession(roomID string, members map[string]bool) (*OutboundGroupSession, error) {
+	session, err := m.Store.LoadOutboundGroupSession(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if session != nil && !session.Expired(m.rotationPeriod(roomID)) && !memberLeft(session, members) {
+		return session, nil
+	}
+	if session, err = NewOutboundGroupSession(roomID); err != nil {
+		return nil, err
+	}
+	// Keep an inbound copy so that our own messages can be decrypted.
+	inbound, err := NewInboundGroupSession(roomID, m.account.Curve25519(), m.account.Ed25519(), session.SessionKey())
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Store.SaveInboundGroupSession(inbound); err != nil {
+		return nil, err
+	}
+	return session, m.Store.SaveOutboundGroupSession(session)
+}
+
+// rotationPeriod returns the rotation settings of the room's m.room.encryption event, or zero values if unknown.
+func (m *OlmMachine) rotationPeriod(roomID string) (int, time.Duration) {
+	room := m.Client.Store.LoadRoom(roomID)
+	if room == nil {
+		return 0, 0
+	}
+	event := room.GetStateEvent("m.room.encryption", "")
+	if event == nil {
+		return 0, 0
+	}
+	content, _ := event.ParseContent()
+	encryption, ok := content.(*gomatrix.EncryptionContent)
+	if !ok {
+		return 0, 0
+	}
+	return encryption.RotationPeriodMsgs, time.Duration(encryption.RotationPeriodMs) * time.Millisecond
+}
+
+func memberLeft(session *OutboundGroupSession, members map[string]bool) bool {
+	for userID := range session.SharedWith {
+		if !members[userID] {
+			return true
+		}
+	}
+	return false
+}
+
+// shareGroupSession sends the session key to every device of the members which has not received it yet. Devices
+// without an Olm session and without one-time keys to create one are skipped, and retried on the next message.
+func (m *OlmMachine) shareGroupSession(ctx context.Context, session *OutboundGroupSession, members map[string]bool) error {
+	userIDs := make([]string, 0, len(members))
+	for userID := range members {
+		userIDs = append(userIDs, userID)
+	}
+	devices, err := m.getDevices(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+	var targets []*DeviceIdentity
+	for userID, userDevices := range devices {
+		for deviceID, device := range userDevices {
+			if userID == m.Client.UserID && deviceID == m.DeviceID || session.SharedWith[userID][deviceID] {
+				continue
+			}
+			targets = append(targets, device)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	olmSessions, err := m.olmSessions(ctx, targets)
+	if err != nil {
+		return err
+	}
+
+	roomKey := &roomKeyContent{
+		Algorithm:  AlgorithmMegolm,
+		RoomID:     session.RoomID,
+		SessionID:  session.ID(),
+		SessionKey: session.SessionKey(),
+	}
+	messages, err := m.encryptRoomKey(roomKey, targets, olmSessions)
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	if _, err = m.Client.SendToDevice(ctx, "m.room.encrypted", &gomatrix.ReqSendToDevice{Messages: messages}); err != nil {
+		return err
+	}
+	for userID, userMessages := range messages {
+		if session.SharedWith[userID] == nil {
+			session.SharedWith[userID] = make(map[string]bool)
+		}
+		for deviceID := range userMessages {
+			session.SharedWith[userID][deviceID] = true
+		}
+	}
+	return m.Store.SaveOutboundGroupSession(session)
+}
+
+// encryptRoomKey encrypts an m.room_key event for each device which has an Olm session, returning the to-device
+// messages to send.
+func (m *OlmMachine) encryptRoomKey(roomKey *roomKeyContent, devices []*DeviceIdentity, olmSessions map[*DeviceIdentity]*Session) (map[string]map[string]interface{}, error) {
+	// Olm sessions are also used to decrypt to-device events, which happens with the lock held.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	messages := make(map[string]map[string]interface{})
+	for _, device := range devices {
+		olmSession := olmSessions[device]
+		if olmSession == nil {
+			continue
+		}
+		content, err := m.encryptOlm(olmSession, device, "m.room_key", roomKey)
+		if err != nil {
+			return nil, err
+		}
+		if messages[device.UserID] == nil {
+			messages[device.UserID] = make(map[string]interface{})
+		}
+		messages[device.UserID][device.DeviceID] = content
+	}
+	return messages, nil
+}
+
+// encryptOlm encrypts a to-device event for the device. Must be called with the lock held.
+func (m *OlmMachine) encryptOlm(session *Session, device *DeviceIdentity, eventType string, content interface{}) (*OlmContent, error) {
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(&olmPayload{
+		Type:          eventType,
+		Content:       raw,
+		Sender:        m.Client.UserID,
+		Recipient:     device.UserID,
+		RecipientKeys: ed25519Keys{device.SigningKey},
+		Keys:          ed25519Keys{m.account.Ed25519()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	msgType, body, err := session.Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Store.SaveSession(device.IdentityKey, session); err != nil {
+		return nil, err
+	}
+	return &OlmContent{
+		Algorithm:  AlgorithmOlm,
+		SenderKey:  m.account.Curve25519(),
+		Ciphertext: map[string]OlmCiphertext{device.IdentityKey: {Type: msgType, Body: body}},
+	}, nil
+}
+
+// olmSessions returns an Olm session for each device, creating new sessions with claimed one-time keys where
+// needed. Devices for which no one-time key could be claimed are missing from the result.
+func (m *OlmMachine) olmSessions(ctx context.Context, devices []*DeviceIdentity) (map[*DeviceIdentity]*Session, error) {
+	sessions, claim, err := m.existingOlmSessions(devices)
+	if err != nil || len(claim) == 0 {
+		return sessions, err
+	}
+
+	resp, err := m.Client.ClaimKeys(ctx, &gomatrix.ReqClaimKeys{OneTimeKeys: claim})
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		if sessions[device] != nil {
+			continue
+		}
+		for keyID, otk := range resp.OneTimeKeys[device.UserID][device.DeviceID] {
+			if !strings.HasPrefix(keyID, "signed_curve25519:") ||
+				!verifySignature(otk.Raw, device.UserID, "ed25519:"+device.DeviceID, device.SigningKey) {
+				continue
+			}
+			session, err := m.account.NewOutboundSession(device.IdentityKey, otk.Key)
+			if err != nil {
+				continue
+			}
+			if err = m.Store.SaveSession(device.IdentityKey, session); err != nil {
+				return nil, err
+			}
+			sessions[device] = session
+			break
+		}
+	}
+	return sessions, nil
+}
+
+// existingOlmSessions returns the most recently used Olm session with each device which has one, and the one-time
+// keys to claim for the others.
+func (m *OlmMachine) existingOlmSessions(devices []*DeviceIdentity) (map[*DeviceIdentity]*Session, map[string]map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := make(map[*DeviceIdentity]*Session)
+	claim := make(map[string]map[string]string)
+	for _, device := range devices {
+		existing, err := m.Store.LoadSessions(device.IdentityKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Use the most recently used session, as the other device is most likely to still have it.
+		for _, session := range existing {
+			if sessions[device] == nil || session.LastUsed.After(sessions[device].LastUsed) {
+				sessions[device] = session
+			}
+		}
+		if sessions[device] == nil {
+			if claim[device.UserID] == nil {
+				claim[device.UserID] = make(map[string]string)
+			}
+			claim[device.UserID][device.DeviceID] = "signed_curve25519"
+		}
+	}
+	return sessions, claim, nil
+}
+
+// getDevices returns the devices of the users, querying the keys of users which are unknown or outdated.
+func (m *OlmMachine) getDevices(ctx context.Context, userIDs []string) (map[string]map[string]*DeviceIdentity, error) {
+	devices := make(map[string]map[string]*DeviceIdentity)
+	known := make(map[string]map[string]*DeviceIdentity)
+	query := make(map[string][]string)
+	m.mu.Lock()
+	outdated := make(map[string]bool, len(m.outdated))
+	for userID := range m.outdated {
+		outdated[userID] = true
+	}
+	m.mu.Unlock()
+	for _, userID := range userIDs {
+		userDevices, err := m.Store.LoadDevices(userID)
+		if err != nil {
+			return nil, err
+		}
+		if userDevices == nil || outdated[userID] {
+			known[userID] = userDevices
+			query[userID] = []string{}
+			continue
+		}
+		devices[userID] = userDevices
+	}
+	if len(query) == 0 {
+		return devices, nil
+	}
+
+	resp, err := m.Client.QueryKeys(ctx, &gomatrix.ReqQueryKeys{DeviceKeys: query})
+	if err != nil {
+		return nil, err
+	}
+	for userID := range query {
+		userKeys, ok := resp.DeviceKeys[userID]
+		if !ok {
+			// The user's server could not be reached; use what we know and try again next time.
+			if known[userID] != nil {
+				devices[userID] = known[userID]
+			}
+			continue
+		}
+		userDevices := make(map[string]*DeviceIdentity)
+		for deviceID, keys := range userKeys {
+			device := verifyDeviceKeys(userID, deviceID, &keys)
+			if device == nil {
+				continue
+			}
+			if old := known[userID][deviceID]; old != nil && old.SigningKey != device.SigningKey {
+				device = old
+			}
+			userDevices[deviceID] = device
+		}
+		if err = m.Store.SaveDevices(userID, userDevices); err != nil {
+			return nil, err
+		}
+		devices[userID] = userDevices
+		m.mu.Lock()
+		delete(m.outdated, userID)
+		m.mu.Unlock()
+	}
+	return devices, nil
+}
+
+// verifyDeviceKeys returns the identity of the device if its keys are valid and self-signed, or nil.
+func verifyDeviceKeys(userID, deviceID string, keys *gomatrix.DeviceKeys) *DeviceIdentity {
+	if keys.UserID != userID || keys.DeviceID != deviceID {
+		return nil
+	}
+	device := &DeviceIdentity{
+		UserID:      userID,
+		DeviceID:    deviceID,
+		IdentityKey: keys.Keys["curve25519:"+deviceID],
+		SigningKey:  keys.Keys["ed25519:"+deviceID],
+	}
+	if device.IdentityKey == "" || !verifySignature(keys.Raw, userID, "ed25519:"+deviceID, device.SigningKey) {
+		return nil
+	}
+	return device
+}
+
+// remarshal decodes the generic content of an event into a struct.
+func remarshal(content map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
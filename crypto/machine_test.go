@@ -0,0 +1,215 @@
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/qua3k/gomatrix"
+)
+
+// fakeKeyServer implements the key and to-device endpoints of a homeserver for a single room.
+type fakeKeyServer struct {
+	mu          sync.Mutex
+	members     []string
+	deviceKeys  map[string]map[string]gomatrix.DeviceKeys
+	oneTimeKeys map[string]map[string]map[string]gomatrix.OneTimeKey
+	toDevice    map[string][]gomatrix.Event
+}
+
+func (s *fakeKeyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userID := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	var resp interface{} = struct{}{}
+	switch path := r.URL.Path; {
+	case strings.HasSuffix(path, "/keys/upload"):
+		var req gomatrix.ReqUploadKeys
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.DeviceKeys != nil {
+			s.deviceKeys[userID] = map[string]gomatrix.DeviceKeys{req.DeviceKeys.DeviceID: *req.DeviceKeys}
+		}
+		for deviceID := range s.deviceKeys[userID] {
+			if s.oneTimeKeys[userID] == nil {
+				s.oneTimeKeys[userID] = make(map[string]map[string]gomatrix.OneTimeKey)
+			}
+			if s.oneTimeKeys[userID][deviceID] == nil {
+				s.oneTimeKeys[userID][deviceID] = make(map[string]gomatrix.OneTimeKey)
+			}
+			for keyID, key := range req.OneTimeKeys {
+				s.oneTimeKeys[userID][deviceID][keyID] = key
+			}
+		}
+	case strings.HasSuffix(path, "/keys/query"):
+		var req gomatrix.ReqQueryKeys
+		json.NewDecoder(r.Body).Decode(&req)
+		res := gomatrix.RespQueryKeys{DeviceKeys: make(map[string]map[string]gomatrix.DeviceKeys)}
+		for queried := range req.DeviceKeys {
+			res.DeviceKeys[queried] = s.deviceKeys[queried]
+		}
+		resp = res
+	case strings.HasSuffix(path, "/keys/claim"):
+		var req gomatrix.ReqClaimKeys
+		json.NewDecoder(r.Body).Decode(&req)
+		res := gomatrix.RespClaimKeys{OneTimeKeys: make(map[string]map[string]map[string]gomatrix.OneTimeKey)}
+		for claimed, devices := range req.OneTimeKeys {
+			res.OneTimeKeys[claimed] = make(map[string]map[string]gomatrix.OneTimeKey)
+			for deviceID := range devices {
+				for keyID, key := range s.oneTimeKeys[claimed][deviceID] {
+					res.OneTimeKeys[claimed][deviceID] = map[string]gomatrix.OneTimeKey{keyID: key}
+					delete(s.oneTimeKeys[claimed][deviceID], keyID)
+					break
+				}
+			}
+		}
+		resp = res
+	case strings.Contains(path, "/sendToDevice/"):
+		eventType := strings.Split(path, "/")[5]
+		var req gomatrix.ReqSendToDevice
+		json.NewDecoder(r.Body).Decode(&req)
+		for recipient, devices := range req.Messages {
+			for _, content := range devices {
+				data, _ := json.Marshal(content)
+				event := gomatrix.Event{Type: eventType, Sender: userID}
+				json.Unmarshal(data, &event.Content)
+				s.toDevice[recipient] = append(s.toDevice[recipient], event)
+			}
+		}
+	case strings.HasSuffix(path, "/joined_members"):
+		res := gomatrix.RespJoinedMembers{}
+		json.Unmarshal([]byte(`{"joined":{}}`), &res)
+		for _, member := range s.members {
+			json.Unmarshal([]byte(`{"joined":{"`+member+`":{}}}`), &res)
+		}
+		resp = res
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		resp = gomatrix.ErrUnrecognized
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// sync returns a sync response with the to-device events queued for the user.
+func (s *fakeKeyServer) sync(userID string) *gomatrix.RespSync {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &gomatrix.RespSync{}
+	res.ToDevice.Events = s.toDevice[userID]
+	delete(s.toDevice, userID)
+	return res
+}
+
+func newTestMachine(t *testing.T, url, userID, deviceID string) *OlmMachine {
+	t.Helper()
+	cli, err := gomatrix.NewClient(url, userID, userID)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+	m := NewOlmMachine(cli, deviceID, NewMemoryStore())
+	if err = m.Load(context.Background()); err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	return m
+}
+
+func TestOlmMachineRoundTrip(t *testing.T) {
+	server := &fakeKeyServer{
+		members:     []string{"@alice:example.com", "@bob:example.com"},
+		deviceKeys:  make(map[string]map[string]gomatrix.DeviceKeys),
+		oneTimeKeys: make(map[string]map[string]map[string]gomatrix.OneTimeKey),
+		toDevice:    make(map[string][]gomatrix.Event),
+	}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	alice := newTestMachine(t, srv.URL, "@alice:example.com", "ALICE")
+	bob := newTestMachine(t, srv.URL, "@bob:example.com", "BOB")
+	ctx := context.Background()
+	roomID := "!room:example.com"
+	// Decrypting room events must not write to the store, except for saving the sender's devices the first time.
+	var writes int
+	bob.Store.(*MemoryStore).save = func(*storeData) error { writes++; return nil }
+
+	for i, body := range []string{"hello", "again"} {
+		encrypted, err := alice.EncryptEvent(ctx, roomID, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": body})
+		if err != nil {
+			t.Fatalf("TestOlmMachineRoundTrip => EncryptEvent: %s", err)
+		}
+		if err = bob.ProcessSyncResponse(ctx, server.sync("@bob:example.com"), "since"); err != nil {
+			t.Fatalf("TestOlmMachineRoundTrip => ProcessSyncResponse: %s", err)
+		}
+
+		event := &gomatrix.Event{Type: "m.room.encrypted", ID: "$" + body, RoomID: roomID, Sender: "@alice:example.com"}
+		data, _ := json.Marshal(encrypted)
+		json.Unmarshal(data, &event.Content)
+		writes = 0
+		for _, m := range []*OlmMachine{bob, alice} {
+			decrypted, err := m.DecryptEvent(ctx, event)
+			if err != nil {
+				t.Fatalf("TestOlmMachineRoundTrip => DecryptEvent %d: %s", i, err)
+			}
+			if decrypted.Type != "m.room.message" || decrypted.Content["body"] != body {
+				t.Fatalf("TestOlmMachineRoundTrip => Got: %s %v Expected: m.room.message %q", decrypted.Type, decrypted.Content, body)
+			}
+		}
+
+		if i > 0 && writes != 0 {
+			t.Fatalf("TestOlmMachineRoundTrip => Got: %d store writes Expected: none", writes)
+		}
+
+		replayed := *event
+		replayed.ID = "$replayed"
+		if _, err = bob.DecryptEvent(ctx, &replayed); err != ErrReplayedMessage {
+			t.Fatalf("TestOlmMachineRoundTrip => Got: %v Expected: %v", err, ErrReplayedMessage)
+		}
+		forged := *event
+		forged.Sender = "@mallory:example.com"
+		if _, err = bob.DecryptEvent(ctx, &forged); err != ErrUnknownSender {
+			t.Fatalf("TestOlmMachineRoundTrip => Got: %v Expected: %v", err, ErrUnknownSender)
+		}
+	}
+
+	// Alice's session is rotated once Bob leaves, and Bob does not receive the new key.
+	server.mu.Lock()
+	server.members = server.members[:1]
+	server.mu.Unlock()
+	encrypted, err := alice.EncryptEvent(ctx, roomID, "m.room.message", map[string]interface{}{"body": "secret"})
+	if err != nil {
+		t.Fatalf("TestOlmMachineRoundTrip => EncryptEvent: %s", err)
+	}
+	bob.ProcessSyncResponse(ctx, server.sync("@bob:example.com"), "since")
+	event := &gomatrix.Event{Type: "m.room.encrypted", ID: "$secret", RoomID: roomID}
+	data, _ := json.Marshal(encrypted)
+	json.Unmarshal(data, &event.Content)
+	if _, err = bob.DecryptEvent(ctx, event); err != ErrNoSession {
+		t.Fatalf("TestOlmMachineRoundTrip => Got: %v Expected: %v", err, ErrNoSession)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	account, err := NewAccount()
+	if err != nil {
+		t.Fatalf("TestVerifySignature => NewAccount: %s", err)
+	}
+	// Fields the Go types drop, such as an explicit "fallback": false or unknown ones, are part of what is signed.
+	signed, err := canonicalJSON(map[string]interface{}{"key": "abc", "fallback": false, "x.custom": []int{1}})
+	if err != nil {
+		t.Fatalf("TestVerifySignature => canonicalJSON: %s", err)
+	}
+	raw := `{"x.custom":[1],"key":"abc","fallback":false,"unsigned":{"note":"x"},` +
+		`"signatures":{"@alice:example.com":{"ed25519:DEVICE":"` + account.Sign(signed) + `"}}}`
+	var otk gomatrix.OneTimeKey
+	if err = json.Unmarshal([]byte(raw), &otk); err != nil {
+		t.Fatalf("TestVerifySignature => Failed to unmarshal: %s", err)
+	}
+	if !verifySignature(otk.Raw, "@alice:example.com", "ed25519:DEVICE", account.Ed25519()) {
+		t.Fatal("TestVerifySignature => Got: invalid Expected: the signature over the received JSON to be valid")
+	}
+	tampered := strings.Replace(raw, `"fallback":false`, `"fallback":true`, 1)
+	if verifySignature(json.RawMessage(tampered), "@alice:example.com", "ed25519:DEVICE", account.Ed25519()) {
+		t.Fatal("TestVerifySignature => Got: valid Expected: a changed field to invalidate the signature")
+	}
+}
@@ -0,0 +1,252 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"time"
+)
+
+const (
+	megolmRatchetParts      = 4
+	megolmRatchetPartLength = 32
+	megolmSessionVersion    = 2 // The version byte of exported session keys.
+	megolmKeysInfo          = "MEGOLM_KEYS"
+)
+
+// megolmRatchet is the Megolm ratchet: four 32 byte parts R0..R3 and a counter.
+// See https://gitlab.matrix.org/matrix-org/olm/-/blob/master/docs/megolm.md
+type megolmRatchet struct {
+	Data    [megolmRatchetParts * megolmRatchetPartLength]byte `json:"data"`
+	Counter uint32                                             `json:"counter"`
+}
+
+func (r *megolmRatchet) part(i int) []byte {
+	return r.Data[i*megolmRatchetPartLength : (i+1)*megolmRatchetPartLength]
+}
+
+// rehash sets R(to) = HMAC(R(from), to).
+func (r *megolmRatchet) rehash(from, to int) {
+	copy(r.part(to), hmacSHA256(r.part(from), []byte{byte(to)}))
+}
+
+// advance advances the ratchet by one.
+func (r *megolmRatchet) advance() {
+	mask := uint32(0x00FFFFFF)
+	h := 0
+	r.Counter++
+	// Figure out how many parts need to be rekeyed.
+	for h < megolmRatchetParts {
+		if r.Counter&mask == 0 {
+			break
+		}
+		h++
+		mask >>= 8
+	}
+	// Update R(h)...R(3) based on R(h).
+	for i := megolmRatchetParts - 1; i >= h; i-- {
+		r.rehash(h, i)
+	}
+}
+
+// advanceTo advances the ratchet to the given counter.
+func (r *megolmRatchet) advanceTo(target uint32) {
+	for j := 0; j < megolmRatchetParts; j++ {
+		shift := uint((megolmRatchetParts - j - 1) * 8)
+		mask := ^uint32(0) << shift
+		// '& 0xff' handles integer wraparound.
+		steps := ((target >> shift) - (r.Counter >> shift)) & 0xff
+		if steps == 0 {
+			continue
+		}
+		// For all but the last step, R(j) can be bumped without regard to R(j+1)...R(3).
+		for ; steps > 1; steps-- {
+			r.rehash(j, j)
+		}
+		// On the last step, R(j+1)...R(3) have to be bumped too.
+		for k := megolmRatchetParts - 1; k >= j; k-- {
+			r.rehash(j, k)
+		}
+		r.Counter = target & mask
+	}
+}
+
+func (r *megolmRatchet) cipher() aesSHA256 {
+	return newAESSHA256(r.Data[:], megolmKeysInfo)
+}
+
+// OutboundGroupSession is a Megolm session used to encrypt messages to a room.
+type OutboundGroupSession struct {
+	RoomID     string             `json:"room_id"`
+	Ratchet    megolmRatchet      `json:"ratchet"`
+	SigningKey ed25519.PrivateKey `json:"signing_key"`
+	CreatedAt  time.Time          `json:"created_at"`
+	// The devices the session has been shared with, by user ID and device ID.
+	SharedWith map[string]map[string]bool `json:"shared_with"`
+}
+
+// NewOutboundGroupSession creates a new Megolm session with a random ratchet.
+func NewOutboundGroupSession(roomID string) (*OutboundGroupSession, error) {
+	data, err := randomBytes(megolmRatchetParts * megolmRatchetPartLength)
+	if err != nil {
+		return nil, err
+	}
+	_, signingKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &OutboundGroupSession{
+		RoomID:     roomID,
+		SigningKey: signingKey,
+		CreatedAt:  time.Now(),
+		SharedWith: make(map[string]map[string]bool),
+	}
+	copy(s.Ratchet.Data[:], data)
+	return s, nil
+}
+
+// ID returns the session ID, which is the public part of the session's signing key.
+func (s *OutboundGroupSession) ID() string {
+	return encodeBase64(s.SigningKey.Public().(ed25519.PublicKey))
+}
+
+// MessageIndex returns the index of the next message to be encrypted.
+func (s *OutboundGroupSession) MessageIndex() uint32 {
+	return s.Ratchet.Counter
+}
+
+// SessionKey exports the session at its current index, for sharing with other devices in an m.room_key event.
+func (s *OutboundGroupSession) SessionKey() string {
+	b := []byte{megolmSessionVersion}
+	b = binary.BigEndian.AppendUint32(b, s.Ratchet.Counter)
+	b = append(b, s.Ratchet.Data[:]...)
+	b = append(b, s.SigningKey.Public().(ed25519.PublicKey)...)
+	b = append(b, ed25519.Sign(s.SigningKey, b)...)
+	return encodeBase64(b)
+}
+
+// Encrypt encrypts the plaintext with the current message key and advances the ratchet.
+func (s *OutboundGroupSession) Encrypt(plaintext []byte) (string, error) {
+	c := s.Ratchet.cipher()
+	ciphertext, err := c.encrypt(plaintext)
+	if err != nil {
+		return "", err
+	}
+	msg := (&megolmMessage{index: s.Ratchet.Counter, ciphertext: ciphertext}).encode()
+	msg = append(msg, c.mac(msg)...)
+	msg = append(msg, ed25519.Sign(s.SigningKey, msg)...)
+	s.Ratchet.advance()
+	return encodeBase64(msg), nil
+}
+
+// Expired returns true if the session should be rotated according to the room's m.room.encryption settings.
+// Zero values use the defaults of 100 messages and one week.
+func (s *OutboundGroupSession) Expired(rotationPeriodMsgs int, rotationPeriod time.Duration) bool {
+	if rotationPeriodMsgs <= 0 {
+		rotationPeriodMsgs = 100
+	}
+	if rotationPeriod <= 0 {
+		rotationPeriod = 7 * 24 * time.Hour
+	}
+	return s.Ratchet.Counter >= uint32(rotationPeriodMsgs) || time.Since(s.CreatedAt) >= rotationPeriod
+}
+
+// InboundGroupSession is a Megolm session used to decrypt messages received in a room.
+type InboundGroupSession struct {
+	RoomID     string            `json:"room_id"`
+	SenderKey  string            `json:"sender_key"`  // The Curve25519 key of the device which shared the session.
+	SigningKey ed25519.PublicKey `json:"signing_key"` // Also the session ID.
+	ClaimedKey string            `json:"claimed_key"` // The Ed25519 key the sender claimed to have.
+	Initial    megolmRatchet     `json:"initial"`     // The ratchet at the first known index.
+	Latest     megolmRatchet     `json:"latest"`      // The ratchet at the latest decrypted index, as an optimisation.
+}
+
+// NewInboundGroupSession imports a session key shared in an m.room_key event.
+func NewInboundGroupSession(roomID, senderKey, claimedKey, sessionKey string) (*InboundGroupSession, error) {
+	data, err := decodeBase64(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	const length = 1 + 4 + megolmRatchetParts*megolmRatchetPartLength + ed25519.PublicKeySize
+	if len(data) != length+ed25519.SignatureSize {
+		return nil, errBadMessage
+	}
+	if data[0] != megolmSessionVersion {
+		return nil, errBadVersion
+	}
+	signingKey := ed25519.PublicKey(data[length-ed25519.PublicKeySize : length])
+	if !ed25519.Verify(signingKey, data[:length], data[length:]) {
+		return nil, errBadSignature
+	}
+	s := &InboundGroupSession{
+		RoomID:     roomID,
+		SenderKey:  senderKey,
+		SigningKey: append(ed25519.PublicKey(nil), signingKey...),
+		ClaimedKey: claimedKey,
+	}
+	s.Initial.Counter = binary.BigEndian.Uint32(data[1:5])
+	copy(s.Initial.Data[:], data[5:length-ed25519.PublicKeySize])
+	s.Latest = s.Initial
+	return s, nil
+}
+
+// ID returns the session ID.
+func (s *InboundGroupSession) ID() string {
+	return encodeBase64(s.SigningKey)
+}
+
+// FirstKnownIndex returns the index of the first message which can be decrypted.
+func (s *InboundGroupSession) FirstKnownIndex() uint32 {
+	return s.Initial.Counter
+}
+
+// Decrypt decrypts a message, returning the plaintext and the message index.
+func (s *InboundGroupSession) Decrypt(message string) ([]byte, uint32, error) {
+	data, err := decodeBase64(message)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 1+macLength+ed25519.SignatureSize {
+		return nil, 0, errBadMessage
+	}
+	if data[0] != messageVersion {
+		return nil, 0, errBadVersion
+	}
+	signed, signature := data[:len(data)-ed25519.SignatureSize], data[len(data)-ed25519.SignatureSize:]
+	if !ed25519.Verify(s.SigningKey, signed, signature) {
+		return nil, 0, errBadSignature
+	}
+	body, mac := signed[:len(signed)-macLength], signed[len(signed)-macLength:]
+	ints, bufs, err := decodeFields(body[1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	index64, ok := ints[tagMessageIndex]
+	ciphertext := bufs[tagGroupCiphertext]
+	if !ok || ciphertext == nil {
+		return nil, 0, errBadMessage
+	}
+	index := uint32(index64)
+
+	// Indexes wrap around, so compare the distance from the first known index.
+	if index-s.Initial.Counter >= 1<<31 {
+		return nil, 0, errOldMessageKey
+	}
+	ratchet := s.Initial
+	if index-s.Latest.Counter < 1<<31 {
+		ratchet = s.Latest
+	}
+	ratchet.advanceTo(index)
+
+	c := ratchet.cipher()
+	if !c.verifyMAC(body, mac) {
+		return nil, 0, errBadMAC
+	}
+	plaintext, err := c.decrypt(ciphertext)
+	if err != nil {
+		return nil, 0, err
+	}
+	if index-s.Latest.Counter < 1<<31 {
+		s.Latest = ratchet
+	}
+	return plaintext, index, nil
+}
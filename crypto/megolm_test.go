@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"testing"
+)
+
+func TestMegolmRatchetAdvanceTo(t *testing.T) {
+	var r megolmRatchet
+	for i := range r.Data {
+		r.Data[i] = byte(i)
+	}
+	for _, target := range []uint32{1, 255, 256, 1 << 16, 1<<16 + 257, 1<<24 + 3} {
+		stepped, jumped := r, r
+		for stepped.Counter < target {
+			if target-stepped.Counter > 1<<16 {
+				stepped.advanceTo(stepped.Counter + 1<<16)
+			} else {
+				stepped.advance()
+			}
+		}
+		jumped.advanceTo(target)
+		if stepped != jumped {
+			t.Fatalf("TestMegolmRatchetAdvanceTo => advance and advanceTo differ at %d", target)
+		}
+	}
+}
+
+func TestMegolmSession(t *testing.T) {
+	outbound, err := NewOutboundGroupSession("!room:example.com")
+	if err != nil {
+		t.Fatalf("TestMegolmSession => NewOutboundGroupSession: %s", err)
+	}
+	first, err := outbound.Encrypt([]byte("first"))
+	if err != nil {
+		t.Fatalf("TestMegolmSession => Encrypt: %s", err)
+	}
+	inbound, err := NewInboundGroupSession("!room:example.com", "sender", "claimed", outbound.SessionKey())
+	if err != nil {
+		t.Fatalf("TestMegolmSession => NewInboundGroupSession: %s", err)
+	}
+	if inbound.ID() != outbound.ID() || inbound.FirstKnownIndex() != 1 {
+		t.Fatalf("TestMegolmSession => Got: %s at %d Expected: %s at 1", inbound.ID(), inbound.FirstKnownIndex(), outbound.ID())
+	}
+	if _, _, err = inbound.Decrypt(first); err == nil {
+		t.Fatal("TestMegolmSession => decrypted a message from before the first known index")
+	}
+
+	var messages []string
+	for i := 0; i < 300; i++ {
+		message, err := outbound.Encrypt([]byte{byte(i)})
+		if err != nil {
+			t.Fatalf("TestMegolmSession => Encrypt: %s", err)
+		}
+		messages = append(messages, message)
+	}
+	for _, i := range []int{299, 3, 0, 299} {
+		plaintext, index, err := inbound.Decrypt(messages[i])
+		if err != nil || len(plaintext) != 1 || plaintext[0] != byte(i) || index != uint32(i+1) {
+			t.Fatalf("TestMegolmSession => Decrypt %d Got: %v, %d, %v", i, plaintext, index, err)
+		}
+	}
+
+	tampered := []byte(messages[5])
+	tampered[10] ^= 1
+	if _, _, err = inbound.Decrypt(string(tampered)); err == nil {
+		t.Fatal("TestMegolmSession => decrypted a tampered message")
+	}
+}
@@ -0,0 +1,156 @@
+package crypto
+
+import (
+	"encoding/binary"
+)
+
+// The binary message formats of Olm and Megolm are a minimal subset of protobuf: a version byte followed by
+// varint-tagged integer and length-delimited fields.
+
+const messageVersion = 3
+
+const (
+	// Olm normal messages
+	tagRatchetKey = 0x0A
+	tagCounter    = 0x10
+	tagCiphertext = 0x22
+
+	// Olm pre-key messages
+	tagOneTimeKey  = 0x0A
+	tagBaseKey     = 0x12
+	tagIdentityKey = 0x1A
+	tagMessage     = 0x22
+
+	// Megolm messages
+	tagMessageIndex     = 0x08
+	tagGroupCiphertext  = 0x12
+	wireTypeVarint      = 0
+	wireTypeLengthDelim = 2
+)
+
+func appendVarint(b []byte, tag byte, v uint64) []byte {
+	b = append(b, tag)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytes(b []byte, tag byte, v []byte) []byte {
+	b = append(b, tag)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// decodeFields decodes the fields after the version byte. Integer fields are returned in ints and byte fields
+// in bufs, keyed by tag. Unknown tags are skipped.
+func decodeFields(data []byte) (ints map[byte]uint64, bufs map[byte][]byte, err error) {
+	ints = make(map[byte]uint64)
+	bufs = make(map[byte][]byte)
+	for len(data) > 0 {
+		tag := data[0]
+		data = data[1:]
+		switch tag & 7 {
+		case wireTypeVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, nil, errBadMessage
+			}
+			ints[tag] = v
+			data = data[n:]
+		case wireTypeLengthDelim:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return nil, nil, errBadMessage
+			}
+			bufs[tag] = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return nil, nil, errBadMessage
+		}
+	}
+	return ints, bufs, nil
+}
+
+// olmMessage is an Olm normal message. The encoded form is followed by a MAC.
+type olmMessage struct {
+	ratchetKey []byte
+	counter    uint32
+	ciphertext []byte
+}
+
+func (m *olmMessage) encode() []byte {
+	b := []byte{messageVersion}
+	b = appendBytes(b, tagRatchetKey, m.ratchetKey)
+	b = appendVarint(b, tagCounter, uint64(m.counter))
+	return appendBytes(b, tagCiphertext, m.ciphertext)
+}
+
+// decodeOlmMessage decodes a message, returning it along with the bytes covered by the MAC and the MAC itself.
+func decodeOlmMessage(data []byte) (m *olmMessage, body, mac []byte, err error) {
+	if len(data) < 1+macLength {
+		return nil, nil, nil, errBadMessage
+	}
+	if data[0] != messageVersion {
+		return nil, nil, nil, errBadVersion
+	}
+	body, mac = data[:len(data)-macLength], data[len(data)-macLength:]
+	ints, bufs, err := decodeFields(body[1:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	counter, ok := ints[tagCounter]
+	if !ok || len(bufs[tagRatchetKey]) != keyLength || bufs[tagCiphertext] == nil {
+		return nil, nil, nil, errBadMessage
+	}
+	return &olmMessage{ratchetKey: bufs[tagRatchetKey], counter: uint32(counter), ciphertext: bufs[tagCiphertext]}, body, mac, nil
+}
+
+// olmPreKeyMessage is an Olm pre-key message, which wraps a normal message with the keys needed to create an
+// inbound session.
+type olmPreKeyMessage struct {
+	oneTimeKey  []byte
+	baseKey     []byte
+	identityKey []byte
+	message     []byte
+}
+
+func (m *olmPreKeyMessage) encode() []byte {
+	b := []byte{messageVersion}
+	b = appendBytes(b, tagOneTimeKey, m.oneTimeKey)
+	b = appendBytes(b, tagBaseKey, m.baseKey)
+	b = appendBytes(b, tagIdentityKey, m.identityKey)
+	return appendBytes(b, tagMessage, m.message)
+}
+
+func decodeOlmPreKeyMessage(data []byte) (*olmPreKeyMessage, error) {
+	if len(data) < 1 {
+		return nil, errBadMessage
+	}
+	if data[0] != messageVersion {
+		return nil, errBadVersion
+	}
+	_, bufs, err := decodeFields(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	m := &olmPreKeyMessage{
+		oneTimeKey:  bufs[tagOneTimeKey],
+		baseKey:     bufs[tagBaseKey],
+		identityKey: bufs[tagIdentityKey],
+		message:     bufs[tagMessage],
+	}
+	if len(m.oneTimeKey) != keyLength || len(m.baseKey) != keyLength || len(m.identityKey) != keyLength || m.message == nil {
+		return nil, errBadMessage
+	}
+	return m, nil
+}
+
+// megolmMessage is a Megolm message. The encoded form is followed by a MAC and an Ed25519 signature.
+type megolmMessage struct {
+	index      uint32
+	ciphertext []byte
+}
+
+func (m *megolmMessage) encode() []byte {
+	b := []byte{messageVersion}
+	b = appendVarint(b, tagMessageIndex, uint64(m.index))
+	return appendBytes(b, tagGroupCiphertext, m.ciphertext)
+}
@@ -0,0 +1,469 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+const (
+	olmRootInfo    = "OLM_ROOT"
+	olmRatchetInfo = "OLM_RATCHET"
+	olmKeysInfo    = "OLM_KEYS"
+
+	maxReceiverChains  = 5
+	maxSkippedMessages = 40
+	maxMessageGap      = 2000
+
+	// MaxOneTimeKeys is the number of one-time keys an Account keeps. The OlmMachine tries to keep half of them
+	// published on the server.
+	MaxOneTimeKeys = 100
+)
+
+// Olm message types, as used in m.olm.v1.curve25519-aes-sha2 ciphertext.
+const (
+	MessageTypePreKey = 0
+	MessageTypeNormal = 1
+)
+
+type curve25519KeyPair struct {
+	Private []byte `json:"private"`
+	Public  []byte `json:"public"`
+}
+
+func newCurve25519KeyPair() (curve25519KeyPair, error) {
+	private, public, err := generateCurve25519()
+	return curve25519KeyPair{Private: private, Public: public}, err
+}
+
+type oneTimeKey struct {
+	ID        uint32            `json:"id"`
+	Key       curve25519KeyPair `json:"key"`
+	Published bool              `json:"published"`
+}
+
+// KeyID returns the ID under which the key is uploaded, without the algorithm.
+func (k oneTimeKey) KeyID() string {
+	return encodeBase64(binary.BigEndian.AppendUint32(nil, k.ID))
+}
+
+// Account holds the identity keys and one-time keys of a device.
+type Account struct {
+	IdentityKey curve25519KeyPair  `json:"identity_key"`
+	SigningKey  ed25519.PrivateKey `json:"signing_key"`
+	OneTimeKeys []oneTimeKey       `json:"one_time_keys"`
+	NextKeyID   uint32             `json:"next_key_id"`
+	Shared      bool               `json:"shared"` // Whether the device keys have been uploaded.
+}
+
+// NewAccount creates an Account with new identity keys.
+func NewAccount() (*Account, error) {
+	identity, err := newCurve25519KeyPair()
+	if err != nil {
+		return nil, err
+	}
+	_, signing, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{IdentityKey: identity, SigningKey: signing, NextKeyID: 1}, nil
+}
+
+// Curve25519 returns the public identity key used for Olm.
+func (a *Account) Curve25519() string {
+	return encodeBase64(a.IdentityKey.Public)
+}
+
+// Ed25519 returns the public fingerprint key used for signing.
+func (a *Account) Ed25519() string {
+	return encodeBase64(a.SigningKey.Public().(ed25519.PublicKey))
+}
+
+// Sign signs the message with the account's Ed25519 key.
+func (a *Account) Sign(message []byte) string {
+	return encodeBase64(ed25519.Sign(a.SigningKey, message))
+}
+
+// GenerateOneTimeKeys generates n new one-time keys. The oldest keys are discarded if there are more than
+// MaxOneTimeKeys.
+func (a *Account) GenerateOneTimeKeys(n int) error {
+	for i := 0; i < n; i++ {
+		key, err := newCurve25519KeyPair()
+		if err != nil {
+			return err
+		}
+		a.OneTimeKeys = append(a.OneTimeKeys, oneTimeKey{ID: a.NextKeyID, Key: key})
+		a.NextKeyID++
+	}
+	if len(a.OneTimeKeys) > MaxOneTimeKeys {
+		a.OneTimeKeys = a.OneTimeKeys[len(a.OneTimeKeys)-MaxOneTimeKeys:]
+	}
+	return nil
+}
+
+// UnpublishedOneTimeKeys returns the public one-time keys which have not been uploaded yet, by key ID.
+func (a *Account) UnpublishedOneTimeKeys() map[string]string {
+	keys := make(map[string]string)
+	for _, k := range a.OneTimeKeys {
+		if !k.Published {
+			keys[k.KeyID()] = encodeBase64(k.Key.Public)
+		}
+	}
+	return keys
+}
+
+// MarkKeysAsPublished marks all one-time keys as uploaded.
+func (a *Account) MarkKeysAsPublished() {
+	for i := range a.OneTimeKeys {
+		a.OneTimeKeys[i].Published = true
+	}
+}
+
+// markPublished marks the one-time keys with the given IDs as uploaded.
+func (a *Account) markPublished(keyIDs []string) {
+	published := make(map[string]bool, len(keyIDs))
+	for _, keyID := range keyIDs {
+		published[keyID] = true
+	}
+	for i, k := range a.OneTimeKeys {
+		if published[k.KeyID()] {
+			a.OneTimeKeys[i].Published = true
+		}
+	}
+}
+
+// NewOutboundSession creates an Olm session to the device with the given identity key, using one of its
+// one-time keys. Both keys are base64 encoded.
+func (a *Account) NewOutboundSession(theirIdentityKey, theirOneTimeKey string) (*Session, error) {
+	identityKey, err := decodeBase64(theirIdentityKey)
+	if err != nil {
+		return nil, err
+	}
+	otk, err := decodeBase64(theirOneTimeKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(identityKey) != keyLength || len(otk) != keyLength {
+		return nil, errBadMessage
+	}
+	baseKey, err := newCurve25519KeyPair()
+	if err != nil {
+		return nil, err
+	}
+	ratchetKey, err := newCurve25519KeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := tripleDH(
+		a.IdentityKey.Private, otk,
+		baseKey.Private, identityKey,
+		baseKey.Private, otk,
+	)
+	if err != nil {
+		return nil, err
+	}
+	derived := hkdfSHA256(secret, nil, []byte(olmRootInfo), 64)
+	return &Session{
+		AliceIdentityKey: a.IdentityKey.Public,
+		AliceBaseKey:     baseKey.Public,
+		BobOneTimeKey:    otk,
+		RootKey:          derived[:32],
+		SenderChains:     []senderChain{{RatchetKey: ratchetKey, ChainKey: chainKey{Key: derived[32:]}}},
+		LastUsed:         time.Now(),
+	}, nil
+}
+
+// NewInboundSession creates an Olm session from a pre-key message sent to this account. The one-time key used
+// by the message is removed from the account. The message still has to be decrypted with Session.Decrypt.
+func (a *Account) NewInboundSession(preKeyMessage string) (*Session, error) {
+	data, err := decodeBase64(preKeyMessage)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := decodeOlmPreKeyMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	inner, _, _, err := decodeOlmMessage(msg.message)
+	if err != nil {
+		return nil, err
+	}
+	otkIndex := -1
+	for i, k := range a.OneTimeKeys {
+		if bytes.Equal(k.Key.Public, msg.oneTimeKey) {
+			otkIndex = i
+			break
+		}
+	}
+	if otkIndex < 0 {
+		return nil, errUnknownKey
+	}
+	otk := a.OneTimeKeys[otkIndex].Key
+
+	secret, err := tripleDH(
+		otk.Private, msg.identityKey,
+		a.IdentityKey.Private, msg.baseKey,
+		otk.Private, msg.baseKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	derived := hkdfSHA256(secret, nil, []byte(olmRootInfo), 64)
+	a.OneTimeKeys = append(a.OneTimeKeys[:otkIndex], a.OneTimeKeys[otkIndex+1:]...)
+	return &Session{
+		ReceivedMessage:  true,
+		AliceIdentityKey: msg.identityKey,
+		AliceBaseKey:     msg.baseKey,
+		BobOneTimeKey:    msg.oneTimeKey,
+		RootKey:          derived[:32],
+		ReceiverChains:   []receiverChain{{RatchetKey: inner.ratchetKey, ChainKey: chainKey{Key: derived[32:]}}},
+		LastUsed:         time.Now(),
+	}, nil
+}
+
+func tripleDH(priv1, pub1, priv2, pub2, priv3, pub3 []byte) ([]byte, error) {
+	var secret []byte
+	for _, pair := range [][2][]byte{{priv1, pub1}, {priv2, pub2}, {priv3, pub3}} {
+		s, err := sharedSecret(pair[0], pair[1])
+		if err != nil {
+			return nil, err
+		}
+		secret = append(secret, s...)
+	}
+	return secret, nil
+}
+
+type chainKey struct {
+	Key   []byte `json:"key"`
+	Index uint32 `json:"index"`
+}
+
+func (c chainKey) messageKey() []byte {
+	return hmacSHA256(c.Key, []byte{0x01})
+}
+
+func (c chainKey) next() chainKey {
+	return chainKey{Key: hmacSHA256(c.Key, []byte{0x02}), Index: c.Index + 1}
+}
+
+type senderChain struct {
+	RatchetKey curve25519KeyPair `json:"ratchet_key"`
+	ChainKey   chainKey          `json:"chain_key"`
+}
+
+type receiverChain struct {
+	RatchetKey []byte   `json:"ratchet_key"`
+	ChainKey   chainKey `json:"chain_key"`
+}
+
+type skippedMessageKey struct {
+	RatchetKey []byte `json:"ratchet_key"`
+	Index      uint32 `json:"index"`
+	Key        []byte `json:"key"`
+}
+
+// Session is an Olm session: a double ratchet between two devices.
+// See https://gitlab.matrix.org/matrix-org/olm/-/blob/master/docs/olm.md
+type Session struct {
+	ReceivedMessage    bool                `json:"received_message"`
+	AliceIdentityKey   []byte              `json:"alice_identity_key"`
+	AliceBaseKey       []byte              `json:"alice_base_key"`
+	BobOneTimeKey      []byte              `json:"bob_one_time_key"`
+	RootKey            []byte              `json:"root_key"`
+	SenderChains       []senderChain       `json:"sender_chains"`
+	ReceiverChains     []receiverChain     `json:"receiver_chains"`
+	SkippedMessageKeys []skippedMessageKey `json:"skipped_message_keys"`
+	LastUsed           time.Time           `json:"last_used"`
+}
+
+// ID returns the session ID, which is the same on both sides of the session.
+func (s *Session) ID() string {
+	h := sha256.New()
+	h.Write(s.AliceIdentityKey)
+	h.Write(s.AliceBaseKey)
+	h.Write(s.BobOneTimeKey)
+	return encodeBase64(h.Sum(nil))
+}
+
+// MatchesInboundSession returns true if the pre-key message was sent using this session.
+func (s *Session) MatchesInboundSession(preKeyMessage string) bool {
+	data, err := decodeBase64(preKeyMessage)
+	if err != nil {
+		return false
+	}
+	msg, err := decodeOlmPreKeyMessage(data)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(msg.identityKey, s.AliceIdentityKey) &&
+		bytes.Equal(msg.baseKey, s.AliceBaseKey) &&
+		bytes.Equal(msg.oneTimeKey, s.BobOneTimeKey)
+}
+
+// createChainKey advances the root key with a new ratchet key, returning the new root key and chain key.
+func createChainKey(rootKey, ourPrivate, theirPublic []byte) ([]byte, chainKey, error) {
+	secret, err := sharedSecret(ourPrivate, theirPublic)
+	if err != nil {
+		return nil, chainKey{}, err
+	}
+	derived := hkdfSHA256(secret, rootKey, []byte(olmRatchetInfo), 64)
+	return derived[:32], chainKey{Key: derived[32:]}, nil
+}
+
+// Encrypt encrypts the plaintext, returning the message type and the base64 encoded message. Messages are
+// pre-key messages until a message has been received from the other side.
+func (s *Session) Encrypt(plaintext []byte) (int, string, error) {
+	if len(s.SenderChains) == 0 {
+		ratchetKey, err := newCurve25519KeyPair()
+		if err != nil {
+			return 0, "", err
+		}
+		rootKey, chain, err := createChainKey(s.RootKey, ratchetKey.Private, s.ReceiverChains[0].RatchetKey)
+		if err != nil {
+			return 0, "", err
+		}
+		s.RootKey = rootKey
+		s.SenderChains = []senderChain{{RatchetKey: ratchetKey, ChainKey: chain}}
+	}
+	sender := &s.SenderChains[0]
+	c := newAESSHA256(sender.ChainKey.messageKey(), olmKeysInfo)
+	counter := sender.ChainKey.Index
+	sender.ChainKey = sender.ChainKey.next()
+
+	ciphertext, err := c.encrypt(plaintext)
+	if err != nil {
+		return 0, "", err
+	}
+	msg := (&olmMessage{ratchetKey: sender.RatchetKey.Public, counter: counter, ciphertext: ciphertext}).encode()
+	msg = append(msg, c.mac(msg)...)
+	s.LastUsed = time.Now()
+
+	if s.ReceivedMessage {
+		return MessageTypeNormal, encodeBase64(msg), nil
+	}
+	preKey := &olmPreKeyMessage{
+		oneTimeKey:  s.BobOneTimeKey,
+		baseKey:     s.AliceBaseKey,
+		identityKey: s.AliceIdentityKey,
+		message:     msg,
+	}
+	return MessageTypePreKey, encodeBase64(preKey.encode()), nil
+}
+
+// Decrypt decrypts a message of the given type.
+func (s *Session) Decrypt(msgType int, message string) ([]byte, error) {
+	data, err := decodeBase64(message)
+	if err != nil {
+		return nil, err
+	}
+	if msgType == MessageTypePreKey {
+		preKey, err := decodeOlmPreKeyMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		data = preKey.message
+	}
+	msg, body, mac, err := decodeOlmMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var plaintext []byte
+	chainIndex := -1
+	for i, chain := range s.ReceiverChains {
+		if bytes.Equal(chain.RatchetKey, msg.ratchetKey) {
+			chainIndex = i
+			break
+		}
+	}
+	switch {
+	case chainIndex < 0:
+		if len(s.SenderChains) == 0 {
+			return nil, errUnknownChain
+		}
+		rootKey, chain, err := createChainKey(s.RootKey, s.SenderChains[0].RatchetKey.Private, msg.ratchetKey)
+		if err != nil {
+			return nil, err
+		}
+		newChain := receiverChain{RatchetKey: msg.ratchetKey, ChainKey: chain}
+		var skipped []skippedMessageKey
+		if plaintext, skipped, err = decryptWithChain(&newChain, msg, body, mac); err != nil {
+			return nil, err
+		}
+		s.ReceiverChains = append([]receiverChain{newChain}, s.ReceiverChains...)
+		if len(s.ReceiverChains) > maxReceiverChains {
+			s.ReceiverChains = s.ReceiverChains[:maxReceiverChains]
+		}
+		s.RootKey = rootKey
+		s.SenderChains = nil
+		s.addSkippedMessageKeys(skipped)
+	case s.ReceiverChains[chainIndex].ChainKey.Index > msg.counter:
+		if plaintext, err = s.decryptSkipped(msg, body, mac); err != nil {
+			return nil, err
+		}
+	default:
+		chain := s.ReceiverChains[chainIndex]
+		var skipped []skippedMessageKey
+		if plaintext, skipped, err = decryptWithChain(&chain, msg, body, mac); err != nil {
+			return nil, err
+		}
+		s.ReceiverChains[chainIndex] = chain
+		s.addSkippedMessageKeys(skipped)
+	}
+	s.ReceivedMessage = true
+	s.LastUsed = time.Now()
+	return plaintext, nil
+}
+
+// decryptWithChain advances the chain to the message's counter and decrypts it. The chain is only modified if
+// decryption succeeds; the keys of the messages skipped on the way are returned.
+func decryptWithChain(chain *receiverChain, msg *olmMessage, body, mac []byte) ([]byte, []skippedMessageKey, error) {
+	if msg.counter-chain.ChainKey.Index > maxMessageGap {
+		return nil, nil, errTooManySkips
+	}
+	key := chain.ChainKey
+	var skipped []skippedMessageKey
+	for key.Index < msg.counter {
+		skipped = append(skipped, skippedMessageKey{RatchetKey: chain.RatchetKey, Index: key.Index, Key: key.messageKey()})
+		key = key.next()
+	}
+	c := newAESSHA256(key.messageKey(), olmKeysInfo)
+	if !c.verifyMAC(body, mac) {
+		return nil, nil, errBadMAC
+	}
+	plaintext, err := c.decrypt(msg.ciphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain.ChainKey = key.next()
+	return plaintext, skipped, nil
+}
+
+func (s *Session) decryptSkipped(msg *olmMessage, body, mac []byte) ([]byte, error) {
+	for i, k := range s.SkippedMessageKeys {
+		if k.Index != msg.counter || !bytes.Equal(k.RatchetKey, msg.ratchetKey) {
+			continue
+		}
+		c := newAESSHA256(k.Key, olmKeysInfo)
+		if !c.verifyMAC(body, mac) {
+			return nil, errBadMAC
+		}
+		plaintext, err := c.decrypt(msg.ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		s.SkippedMessageKeys = append(s.SkippedMessageKeys[:i], s.SkippedMessageKeys[i+1:]...)
+		return plaintext, nil
+	}
+	return nil, errOldMessageKey
+}
+
+func (s *Session) addSkippedMessageKeys(keys []skippedMessageKey) {
+	s.SkippedMessageKeys = append(s.SkippedMessageKeys, keys...)
+	if len(s.SkippedMessageKeys) > maxSkippedMessages {
+		s.SkippedMessageKeys = s.SkippedMessageKeys[len(s.SkippedMessageKeys)-maxSkippedMessages:]
+	}
+}
@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"testing"
+)
+
+func TestOlmSession(t *testing.T) {
+	alice, err := NewAccount()
+	if err != nil {
+		t.Fatalf("TestOlmSession => NewAccount: %s", err)
+	}
+	bob, err := NewAccount()
+	if err != nil {
+		t.Fatalf("TestOlmSession => NewAccount: %s", err)
+	}
+	if err = bob.GenerateOneTimeKeys(1); err != nil {
+		t.Fatalf("TestOlmSession => GenerateOneTimeKeys: %s", err)
+	}
+	var otk string
+	for _, key := range bob.UnpublishedOneTimeKeys() {
+		otk = key
+	}
+
+	aliceSession, err := alice.NewOutboundSession(bob.Curve25519(), otk)
+	if err != nil {
+		t.Fatalf("TestOlmSession => NewOutboundSession: %s", err)
+	}
+	msgType, body, err := aliceSession.Encrypt([]byte("hello"))
+	if err != nil || msgType != MessageTypePreKey {
+		t.Fatalf("TestOlmSession => Encrypt Got: %d, %v Expected: a pre-key message", msgType, err)
+	}
+	bobSession, err := bob.NewInboundSession(body)
+	if err != nil {
+		t.Fatalf("TestOlmSession => NewInboundSession: %s", err)
+	}
+	if len(bob.OneTimeKeys) != 0 {
+		t.Fatal("TestOlmSession => NewInboundSession did not remove the one-time key")
+	}
+	if !bobSession.MatchesInboundSession(body) || bobSession.ID() != aliceSession.ID() {
+		t.Fatal("TestOlmSession => the sessions do not match")
+	}
+	if plaintext, err := bobSession.Decrypt(msgType, body); err != nil || string(plaintext) != "hello" {
+		t.Fatalf("TestOlmSession => Decrypt Got: %q, %v Expected: %q", plaintext, err, "hello")
+	}
+
+	// Turn the ratchet a few times, skipping and reordering messages on the way.
+	sessions := [2]*Session{aliceSession, bobSession}
+	for i := 0; i < 6; i++ {
+		from, to := sessions[i%2], sessions[(i+1)%2]
+		type message struct {
+			msgType int
+			body    string
+		}
+		var messages []message
+		for j := 0; j < 3; j++ {
+			msgType, body, err := from.Encrypt([]byte{byte(i), byte(j)})
+			if err != nil {
+				t.Fatalf("TestOlmSession => Encrypt: %s", err)
+			}
+			messages = append(messages, message{msgType, body})
+		}
+		for _, j := range []int{2, 0, 1} {
+			plaintext, err := to.Decrypt(messages[j].msgType, messages[j].body)
+			if err != nil || len(plaintext) != 2 || plaintext[0] != byte(i) || plaintext[1] != byte(j) {
+				t.Fatalf("TestOlmSession => Decrypt message %d/%d Got: %v, %v", i, j, plaintext, err)
+			}
+		}
+		if _, err := to.Decrypt(messages[0].msgType, messages[0].body); err == nil {
+			t.Fatal("TestOlmSession => decrypting a message twice succeeded")
+		}
+	}
+	if msgType, _, _ := bobSession.Encrypt([]byte("x")); msgType != MessageTypeNormal {
+		t.Fatalf("TestOlmSession => Encrypt Got: type %d Expected: %d", msgType, MessageTypeNormal)
+	}
+}
@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var (
+	errBadMAC        = errors.New("bad message MAC")
+	errBadPadding    = errors.New("bad ciphertext padding")
+	errBadMessage    = errors.New("malformed message")
+	errBadVersion    = errors.New("unsupported message version")
+	errBadSignature  = errors.New("bad signature")
+	errUnknownKey    = errors.New("unknown key")
+	errTooManySkips  = errors.New("message index too far ahead")
+	errUnknownChain  = errors.New("no chain for the message's ratchet key")
+	errOldMessageKey = errors.New("message key for this index was already used or discarded")
+)
+
+const (
+	keyLength = 32 // The length of Curve25519 and Ed25519 public keys.
+	macLength = 8  // The length of the truncated HMAC-SHA-256 appended to messages.
+)
+
+// encodeBase64 encodes data as unpadded standard base64, which is used for all keys and messages.
+func encodeBase64(data []byte) string {
+	return base64.RawStdEncoding.EncodeToString(data)
+}
+
+// decodeBase64 decodes unpadded standard base64, tolerating padding.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(string(bytes.TrimRight([]byte(s), "=")))
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, b)
+	return b, err
+}
+
+// generateCurve25519 returns a new Curve25519 key pair.
+func generateCurve25519() (private, public []byte, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key.Bytes(), key.PublicKey().Bytes(), nil
+}
+
+// sharedSecret returns the Curve25519 Diffie-Hellman shared secret of the given keys.
+func sharedSecret(private, public []byte) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ecdh.X25519().NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	return priv.ECDH(pub)
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// hkdfSHA256 implements HKDF (RFC 5869) with SHA-256. A nil salt is equivalent to a salt of zeros.
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	prk := hmacSHA256(salt, secret)
+	var out, t []byte
+	for i := byte(1); len(out) < length; i++ {
+		h := hmac.New(sha256.New, prk)
+		h.Write(t)
+		h.Write(info)
+		h.Write([]byte{i})
+		t = h.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+// aesSHA256 is the AES-256-CBC and HMAC-SHA-256 cipher used by Olm and Megolm. The keys are derived from a
+// secret with HKDF and the given info.
+type aesSHA256 struct {
+	aesKey, macKey, iv []byte
+}
+
+func newAESSHA256(secret []byte, info string) aesSHA256 {
+	derived := hkdfSHA256(secret, nil, []byte(info), 80)
+	return aesSHA256{aesKey: derived[:32], macKey: derived[32:64], iv: derived[64:80]}
+}
+
+func (c aesSHA256) encrypt(plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(c.aesKey)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := make([]byte, len(plaintext)+padding)
+	copy(padded, plaintext)
+	for i := len(plaintext); i < len(padded); i++ {
+		padded[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, c.iv).CryptBlocks(padded, padded)
+	return padded, nil
+}
+
+func (c aesSHA256) decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errBadPadding
+	}
+	block, err := aes.NewCipher(c.aesKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, c.iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errBadPadding
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// mac returns the truncated MAC of the message.
+func (c aesSHA256) mac(message []byte) []byte {
+	return hmacSHA256(c.macKey, message)[:macLength]
+}
+
+func (c aesSHA256) verifyMAC(message, mac []byte) bool {
+	return hmac.Equal(c.mac(message), mac)
+}
+
+// canonicalJSON encodes v as Matrix canonical JSON: object keys sorted, no insignificant whitespace and no
+// escaping of non-ASCII characters. See https://spec.matrix.org/v1.1/appendices/#canonical-json
+func canonicalJSON(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// Round-trip through a generic value so that struct fields are sorted too.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic interface{}
+	if err = dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
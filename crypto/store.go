@@ -0,0 +1,273 @@
+package crypto
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/qua3k/gomatrix/internal/atomicfile"
+)
+
+// DeviceIdentity is the identity of another device, as returned by /keys/query.
+type DeviceIdentity struct {
+	UserID      string `json:"user_id"`
+	DeviceID    string `json:"device_id"`
+	IdentityKey string `json:"identity_key"` // Curve25519
+	SigningKey  string `json:"signing_key"`  // Ed25519
+}
+
+// Store persists the state of an OlmMachine: the account, Olm sessions, Megolm sessions and known devices.
+//
+// Load methods return nil values without an error if nothing has been saved. The OlmMachine changes the values it
+// loads and saves them again afterwards, so a Store must not hand out or keep values it shares with the caller.
+type Store interface {
+	LoadAccount() (*Account, error)
+	SaveAccount(account *Account) error
+
+	// LoadSessions returns the Olm sessions with the device with the given Curve25519 key.
+	LoadSessions(senderKey string) ([]*Session, error)
+	// SaveSession adds the session, or updates it if a session with the same ID exists.
+	SaveSession(senderKey string, session *Session) error
+
+	LoadInboundGroupSession(roomID, senderKey, sessionID string) (*InboundGroupSession, error)
+	SaveInboundGroupSession(session *InboundGroupSession) error
+
+	LoadOutboundGroupSession(roomID string) (*OutboundGroupSession, error)
+	SaveOutboundGroupSession(session *OutboundGroupSession) error
+	RemoveOutboundGroupSession(roomID string) error
+
+	// LoadDevices returns the devices of the user by device ID, or nil if they have never been saved.
+	LoadDevices(userID string) (map[string]*DeviceIdentity, error)
+	SaveDevices(userID string, devices map[string]*DeviceIdentity) error
+}
+
+// storeData is the data kept by a MemoryStore, and the JSON format of a FileStore.
+type storeData struct {
+	Account       *Account                              `json:"account,omitempty"`
+	Sessions      map[string][]*Session                 `json:"sessions"`
+	InboundGroup  map[string]*InboundGroupSession       `json:"inbound_group_sessions"`
+	OutboundGroup map[string]*OutboundGroupSession      `json:"outbound_group_sessions"`
+	Devices       map[string]map[string]*DeviceIdentity `json:"devices"`
+}
+
+func groupSessionKey(roomID, senderKey, sessionID string) string {
+	return roomID + "|" + senderKey + "|" + sessionID
+}
+
+// MemoryStore implements Store in memory, keeping copies of the values it is given. Everything is lost on restart, which makes the device unable to decrypt
+// messages sent to its previous sessions; use a FileStore or another persistent Store in practice.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data storeData
+	// save is called with the lock held after every change. Used by FileStore.
+	save func(data *storeData) error
+}
+
+// NewMemoryStore constructs a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+	s.data.init()
+	return s
+}
+
+func (d *storeData) init() {
+	if d.Sessions == nil {
+		d.Sessions = make(map[string][]*Session)
+	}
+	if d.InboundGroup == nil {
+		d.InboundGroup = make(map[string]*InboundGroupSession)
+	}
+	if d.OutboundGroup == nil {
+		d.OutboundGroup = make(map[string]*OutboundGroupSession)
+	}
+	if d.Devices == nil {
+		d.Devices = make(map[string]map[string]*DeviceIdentity)
+	}
+}
+
+func (s *MemoryStore) changed() error {
+	if s.save == nil {
+		return nil
+	}
+	return s.save(&s.data)
+}
+
+// LoadAccount implements Store.
+func (s *MemoryStore) LoadAccount() (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.data.Account)
+}
+
+// SaveAccount implements Store.
+func (s *MemoryStore) SaveAccount(account *Account) error {
+	account, err := clone(account)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Account = account
+	return s.changed()
+}
+
+// LoadSessions implements Store.
+func (s *MemoryStore) LoadSessions(senderKey string) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sessions []*Session
+	for _, session := range s.data.Sessions[senderKey] {
+		c, err := clone(session)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, c)
+	}
+	return sessions, nil
+}
+
+// SaveSession implements Store.
+func (s *MemoryStore) SaveSession(senderKey string, session *Session) error {
+	session, err := clone(session)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := s.data.Sessions[senderKey]
+	for i, existing := range sessions {
+		if existing.ID() == session.ID() {
+			sessions[i] = session
+			return s.changed()
+		}
+	}
+	s.data.Sessions[senderKey] = append(sessions, session)
+	return s.changed()
+}
+
+// LoadInboundGroupSession implements Store.
+func (s *MemoryStore) LoadInboundGroupSession(roomID, senderKey, sessionID string) (*InboundGroupSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.data.InboundGroup[groupSessionKey(roomID, senderKey, sessionID)])
+}
+
+// SaveInboundGroupSession implements Store.
+func (s *MemoryStore) SaveInboundGroupSession(session *InboundGroupSession) error {
+	session, err := clone(session)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.InboundGroup[groupSessionKey(session.RoomID, session.SenderKey, session.ID())] = session
+	return s.changed()
+}
+
+// LoadOutboundGroupSession implements Store.
+func (s *MemoryStore) LoadOutboundGroupSession(roomID string) (*OutboundGroupSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.data.OutboundGroup[roomID])
+}
+
+// SaveOutboundGroupSession implements Store.
+func (s *MemoryStore) SaveOutboundGroupSession(session *OutboundGroupSession) error {
+	session, err := clone(session)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.OutboundGroup[session.RoomID] = session
+	return s.changed()
+}
+
+// RemoveOutboundGroupSession implements Store.
+func (s *MemoryStore) RemoveOutboundGroupSession(roomID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.OutboundGroup, roomID)
+	return s.changed()
+}
+
+// LoadDevices implements Store.
+func (s *MemoryStore) LoadDevices(userID string) (map[string]*DeviceIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	devices, ok := s.data.Devices[userID]
+	if !ok {
+		return nil, nil
+	}
+	return copyDevices(devices), nil
+}
+
+// SaveDevices implements Store.
+func (s *MemoryStore) SaveDevices(userID string, devices map[string]*DeviceIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Devices[userID] = copyDevices(devices)
+	return s.changed()
+}
+
+func copyDevices(devices map[string]*DeviceIdentity) map[string]*DeviceIdentity {
+	c := make(map[string]*DeviceIdentity, len(devices))
+	for deviceID, device := range devices {
+		d := *device
+		c[deviceID] = &d
+	}
+	return c
+}
+
+// clone returns a deep copy of the value, made through its JSON encoding, which is complete as it is what a
+// FileStore persists.
+func clone[T any](v *T) (*T, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	c := new(T)
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// FileStore implements Store by keeping everything in memory and writing it to a single JSON file after every
+// change. The file is replaced atomically, so it always contains a consistent state. Decrypting room events does
+// not change the store, so the file is only written when keys or sessions change.
+//
+// The file contains the device's private keys and room keys unencrypted and is created readable by the owner only.
+type FileStore struct {
+	MemoryStore
+	Path string
+}
+
+// NewFileStore opens the store at the given path, creating it if it does not exist.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{Path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &s.data); err != nil {
+			return nil, err
+		}
+	}
+	s.data.init()
+	s.save = s.write
+	return s, nil
+}
+
+func (s *FileStore) write(data *storeData) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(s.Path, b)
+}
@@ -0,0 +1,46 @@
+package crypto
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreCopies(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "crypto.json"))
+	if err != nil {
+		t.Fatalf("TestFileStoreCopies => NewFileStore: %s", err)
+	}
+	session, err := NewOutboundGroupSession("!room:example.com")
+	if err != nil {
+		t.Fatalf("TestFileStoreCopies => NewOutboundGroupSession: %s", err)
+	}
+	if err = store.SaveOutboundGroupSession(session); err != nil {
+		t.Fatalf("TestFileStoreCopies => SaveOutboundGroupSession: %s", err)
+	}
+
+	// Changes to saved and loaded sessions only reach the store when they are saved, so that they never race with
+	// the store writing its file.
+	session.SharedWith["@bob:example.com"] = map[string]bool{"BOB": true}
+	loaded, err := store.LoadOutboundGroupSession("!room:example.com")
+	if err != nil || loaded == nil || len(loaded.SharedWith) != 0 {
+		t.Fatalf("TestFileStoreCopies => Got: %+v, %v Expected: the session as it was saved", loaded, err)
+	}
+	if _, err = loaded.Encrypt([]byte("hello")); err != nil {
+		t.Fatalf("TestFileStoreCopies => Encrypt: %s", err)
+	}
+	again, err := store.LoadOutboundGroupSession("!room:example.com")
+	if err != nil || again.MessageIndex() != 0 {
+		t.Fatalf("TestFileStoreCopies => Got: %+v, %v Expected: the session at index 0", again, err)
+	}
+
+	if err = store.SaveOutboundGroupSession(loaded); err != nil {
+		t.Fatalf("TestFileStoreCopies => SaveOutboundGroupSession: %s", err)
+	}
+	reopened, err := NewFileStore(store.Path)
+	if err != nil {
+		t.Fatalf("TestFileStoreCopies => NewFileStore: %s", err)
+	}
+	if saved, err := reopened.LoadOutboundGroupSession("!room:example.com"); err != nil || saved.MessageIndex() != 1 {
+		t.Fatalf("TestFileStoreCopies => Got: %+v, %v Expected: the session at index 1", saved, err)
+	}
+}
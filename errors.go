@@ -0,0 +1,55 @@
+package gomatrix
+
+// Sentinel errors for the standard Matrix errcodes. They match any RespError, or HTTPError wrapping one, with the
+// same errcode:
+//
+//	if errors.Is(err, gomatrix.ErrForbidden) {
+//		// ...
+//	}
+//
+// Use errors.As with a RespError to access the message and any extra fields.
+// See https://spec.matrix.org/v1.1/client-server-api/#common-error-codes
+var (
+	// Common error codes.
+	ErrForbidden       = RespError{ErrCode: "M_FORBIDDEN"}
+	ErrUnknownToken    = RespError{ErrCode: "M_UNKNOWN_TOKEN"}
+	ErrMissingToken    = RespError{ErrCode: "M_MISSING_TOKEN"}
+	ErrBadJSON         = RespError{ErrCode: "M_BAD_JSON"}
+	ErrNotJSON         = RespError{ErrCode: "M_NOT_JSON"}
+	ErrNotFound        = RespError{ErrCode: "M_NOT_FOUND"}
+	ErrLimitExceeded   = RespError{ErrCode: "M_LIMIT_EXCEEDED"}
+	ErrUnknown         = RespError{ErrCode: "M_UNKNOWN"}
+	ErrUnrecognized    = RespError{ErrCode: "M_UNRECOGNIZED"}
+	ErrUnauthorized    = RespError{ErrCode: "M_UNAUTHORIZED"}
+	ErrUserDeactivated = RespError{ErrCode: "M_USER_DEACTIVATED"}
+
+	// Other error codes.
+	ErrUserInUse                   = RespError{ErrCode: "M_USER_IN_USE"}
+	ErrInvalidUsername             = RespError{ErrCode: "M_INVALID_USERNAME"}
+	ErrRoomInUse                   = RespError{ErrCode: "M_ROOM_IN_USE"}
+	ErrInvalidRoomState            = RespError{ErrCode: "M_INVALID_ROOM_STATE"}
+	ErrThreePIDInUse               = RespError{ErrCode: "M_THREEPID_IN_USE"}
+	ErrThreePIDNotFound            = RespError{ErrCode: "M_THREEPID_NOT_FOUND"}
+	ErrThreePIDAuthFailed          = RespError{ErrCode: "M_THREEPID_AUTH_FAILED"}
+	ErrThreePIDDenied              = RespError{ErrCode: "M_THREEPID_DENIED"}
+	ErrServerNotTrusted            = RespError{ErrCode: "M_SERVER_NOT_TRUSTED"}
+	ErrUnsupportedRoomVersion      = RespError{ErrCode: "M_UNSUPPORTED_ROOM_VERSION"}
+	ErrIncompatibleRoomVersion     = RespError{ErrCode: "M_INCOMPATIBLE_ROOM_VERSION"}
+	ErrBadState                    = RespError{ErrCode: "M_BAD_STATE"}
+	ErrGuestAccessForbidden        = RespError{ErrCode: "M_GUEST_ACCESS_FORBIDDEN"}
+	ErrCaptchaNeeded               = RespError{ErrCode: "M_CAPTCHA_NEEDED"}
+	ErrCaptchaInvalid              = RespError{ErrCode: "M_CAPTCHA_INVALID"}
+	ErrMissingParam                = RespError{ErrCode: "M_MISSING_PARAM"}
+	ErrInvalidParam                = RespError{ErrCode: "M_INVALID_PARAM"}
+	ErrTooLarge                    = RespError{ErrCode: "M_TOO_LARGE"}
+	ErrExclusive                   = RespError{ErrCode: "M_EXCLUSIVE"}
+	ErrResourceLimitExceeded       = RespError{ErrCode: "M_RESOURCE_LIMIT_EXCEEDED"}
+	ErrCannotLeaveServerNoticeRoom = RespError{ErrCode: "M_CANNOT_LEAVE_SERVER_NOTICE_ROOM"}
+	ErrWeakPassword                = RespError{ErrCode: "M_WEAK_PASSWORD"}
+	ErrUnableToAuthoriseJoin       = RespError{ErrCode: "M_UNABLE_TO_AUTHORISE_JOIN"}
+	ErrUnableToGrantJoin           = RespError{ErrCode: "M_UNABLE_TO_GRANT_JOIN"}
+	ErrThreePIDMediumNotSupported  = RespError{ErrCode: "M_THREEPID_MEDIUM_NOT_SUPPORTED"}
+
+	// ErrUnknownPos is returned by sliding sync when the connection has expired and must be restarted.
+	ErrUnknownPos = RespError{ErrCode: "M_UNKNOWN_POS"}
+)
@@ -0,0 +1,31 @@
+package gomatrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestErrorsIs(t *testing.T) {
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"Invalid access token","soft_logout":true}`))
+	}))
+
+	_, err := cli.JoinedRooms(context.Background())
+	if !errors.Is(err, ErrUnknownToken) {
+		t.Fatalf("TestErrorsIs => %v is not %v", err, ErrUnknownToken)
+	}
+	if errors.Is(err, ErrForbidden) {
+		t.Fatalf("TestErrorsIs => %v is %v", err, ErrForbidden)
+	}
+	if !errors.Is(err, HTTPError{Code: http.StatusUnauthorized}) {
+		t.Fatalf("TestErrorsIs => %v is not a 401 HTTPError", err)
+	}
+
+	var respErr RespError
+	if !errors.As(err, &respErr) || !respErr.SoftLogout || respErr.Err != "Invalid access token" {
+		t.Fatalf("TestErrorsIs => Got: %+v Expected: a soft logout RespError", respErr)
+	}
+}
@@ -1,7 +1,9 @@
 package gomatrix
 
 import (
+	"encoding/json"
 	"html"
+	"reflect"
 	"regexp"
 )
 
@@ -25,6 +27,40 @@ type Event struct {
 
 	// Strictly for m.room.redaction events
 	Redacts string `json:"redacts,omitempty"` // The event ID that was redacted if a m.room.redaction event
+
+	// Raw is the original JSON of the event, if it was decoded from JSON. MarshalJSON returns it as long as the
+	// other fields still match it, so fields this type doesn't know about survive a round trip. It doubles the
+	// memory used by each decoded event; set it to nil to drop it once it is no longer needed.
+	Raw json.RawMessage `json:"-"`
+
+	parsed *parsedContent // The cached result of ParseContent, shared between copies of the event.
+}
+
+// UnmarshalJSON decodes the event and keeps a copy of the original JSON in Raw.
+func (event *Event) UnmarshalJSON(data []byte) error {
+	type rawEvent Event
+	if err := json.Unmarshal(data, (*rawEvent)(event)); err != nil {
+		return err
+	}
+	event.Raw = make(json.RawMessage, len(data))
+	copy(event.Raw, data)
+	event.parsed = &parsedContent{}
+	return nil
+}
+
+// MarshalJSON encodes the event as Raw if it is set and the event has not been changed since it was decoded, and
+// from its fields otherwise.
+func (event Event) MarshalJSON() ([]byte, error) {
+	type rawEvent Event
+	current := rawEvent(event)
+	current.Raw, current.parsed = nil, nil
+	if event.Raw != nil {
+		var original rawEvent
+		if json.Unmarshal(event.Raw, &original) == nil && reflect.DeepEqual(original, current) {
+			return event.Raw, nil
+		}
+	}
+	return json.Marshal(current)
 }
 
 // Body returns the value of the "body" key in the event content if it is
@@ -51,10 +87,12 @@ func (event *Event) MessageType() (msgtype string, ok bool) {
 
 // TextMessage is the contents of a Matrix formated message event.
 type TextMessage struct {
-	Body          string `json:"body"`
-	Format        string `json:"format"`
-	FormattedBody string `json:"formatted_body"`
-	MsgType       string `json:"msgtype"`
+	Body          string       `json:"body"`
+	Format        string       `json:"format"`
+	FormattedBody string       `json:"formatted_body"`
+	MsgType       string       `json:"msgtype"`
+	RelatesTo     *RelatesTo   `json:"m.relates_to,omitempty"`
+	NewContent    *TextMessage `json:"m.new_content,omitempty"` // The replacement content of an edit, see NewEdit.
 }
 
 // ThumbnailInfo contains info about an thumbnail image - http://matrix.org/docs/spec/client_server/r0.2.0.html#m-image
@@ -67,39 +105,43 @@ type ThumbnailInfo struct {
 
 // ImageInfo contains info about an image - http://matrix.org/docs/spec/client_server/r0.2.0.html#m-image
 type ImageInfo struct {
-	Height        uint          `json:"h,omitempty"`
-	Width         uint          `json:"w,omitempty"`
-	Mimetype      string        `json:"mimetype,omitempty"`
-	Size          uint          `json:"size,omitempty"`
-	ThumbnailInfo ThumbnailInfo `json:"thumbnail_info,omitempty"`
-	ThumbnailURL  string        `json:"thumbnail_url,omitempty"`
+	Height        uint           `json:"h,omitempty"`
+	Width         uint           `json:"w,omitempty"`
+	Mimetype      string         `json:"mimetype,omitempty"`
+	Size          uint           `json:"size,omitempty"`
+	ThumbnailInfo ThumbnailInfo  `json:"thumbnail_info,omitempty"`
+	ThumbnailURL  string         `json:"thumbnail_url,omitempty"`
+	ThumbnailFile *EncryptedFile `json:"thumbnail_file,omitempty"` // Replaces ThumbnailURL in encrypted rooms.
 }
 
 // VideoInfo contains info about a video - http://matrix.org/docs/spec/client_server/r0.2.0.html#m-video
 type VideoInfo struct {
-	Mimetype      string        `json:"mimetype,omitempty"`
-	ThumbnailInfo ThumbnailInfo `json:"thumbnail_info"`
-	ThumbnailURL  string        `json:"thumbnail_url,omitempty"`
-	Height        uint          `json:"h,omitempty"`
-	Width         uint          `json:"w,omitempty"`
-	Duration      uint          `json:"duration,omitempty"`
-	Size          uint          `json:"size,omitempty"`
+	Mimetype      string         `json:"mimetype,omitempty"`
+	ThumbnailInfo ThumbnailInfo  `json:"thumbnail_info"`
+	ThumbnailURL  string         `json:"thumbnail_url,omitempty"`
+	ThumbnailFile *EncryptedFile `json:"thumbnail_file,omitempty"` // Replaces ThumbnailURL in encrypted rooms.
+	Height        uint           `json:"h,omitempty"`
+	Width         uint           `json:"w,omitempty"`
+	Duration      uint           `json:"duration,omitempty"`
+	Size          uint           `json:"size,omitempty"`
 }
 
 // VideoMessage is an m.video  - http://matrix.org/docs/spec/client_server/r0.2.0.html#m-video
 type VideoMessage struct {
-	MsgType string    `json:"msgtype"`
-	Body    string    `json:"body"`
-	URL     string    `json:"url"`
-	Info    VideoInfo `json:"info"`
+	MsgType string         `json:"msgtype"`
+	Body    string         `json:"body"`
+	URL     string         `json:"url,omitempty"`
+	File    *EncryptedFile `json:"file,omitempty"` // Replaces URL in encrypted rooms.
+	Info    VideoInfo      `json:"info"`
 }
 
 // ImageMessage is an m.image event
 type ImageMessage struct {
-	MsgType string    `json:"msgtype"`
-	Body    string    `json:"body"`
-	URL     string    `json:"url"`
-	Info    ImageInfo `json:"info"`
+	MsgType string         `json:"msgtype"`
+	Body    string         `json:"body"`
+	URL     string         `json:"url,omitempty"`
+	File    *EncryptedFile `json:"file,omitempty"` // Replaces URL in encrypted rooms.
+	Info    ImageInfo      `json:"info"`
 }
 
 // An HTMLMessage is the contents of a Matrix HTML formated message event.
@@ -118,13 +160,15 @@ type FileInfo struct {
 
 // FileMessage is an m.file event - http://matrix.org/docs/spec/client_server/r0.2.0.html#m-file
 type FileMessage struct {
-	MsgType       string    `json:"msgtype"`
-	Body          string    `json:"body"`
-	URL           string    `json:"url"`
-	Filename      string    `json:"filename"`
-	Info          FileInfo  `json:"info,omitempty"`
-	ThumbnailURL  string    `json:"thumbnail_url,omitempty"`
-	ThumbnailInfo ImageInfo `json:"thumbnail_info,omitempty"`
+	MsgType       string         `json:"msgtype"`
+	Body          string         `json:"body"`
+	URL           string         `json:"url,omitempty"`
+	File          *EncryptedFile `json:"file,omitempty"` // Replaces URL in encrypted rooms.
+	Filename      string         `json:"filename"`
+	Info          FileInfo       `json:"info,omitempty"`
+	ThumbnailURL  string         `json:"thumbnail_url,omitempty"`
+	ThumbnailFile *EncryptedFile `json:"thumbnail_file,omitempty"` // Replaces ThumbnailURL in encrypted rooms.
+	ThumbnailInfo ImageInfo      `json:"thumbnail_info,omitempty"`
 }
 
 // LocationMessage is an m.location event - http://matrix.org/docs/spec/client_server/r0.2.0.html#m-location
@@ -145,10 +189,11 @@ type AudioInfo struct {
 
 // AudioMessage is an m.audio event - http://matrix.org/docs/spec/client_server/r0.2.0.html#m-audio
 type AudioMessage struct {
-	MsgType string    `json:"msgtype"`
-	Body    string    `json:"body"`
-	URL     string    `json:"url"`
-	Info    AudioInfo `json:"info,omitempty"`
+	MsgType string         `json:"msgtype"`
+	Body    string         `json:"body"`
+	URL     string         `json:"url,omitempty"`
+	File    *EncryptedFile `json:"file,omitempty"` // Replaces URL in encrypted rooms.
+	Info    AudioInfo      `json:"info,omitempty"`
 }
 
 var htmlRegex = regexp.MustCompile("<[^<]+?>")
@@ -0,0 +1,209 @@
+package gomatrix
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/qua3k/gomatrix/internal/atomicfile"
+)
+
+// FileStore implements the Storer and SlidingSyncStorer interfaces by persisting data as JSON files in a directory:
+//
+//	<dir>/tokens.json      filter IDs, next batch tokens and sliding sync positions of every user
+//	<dir>/rooms/<id>.json  the state of a single room
+//
+// Every write replaces the whole file atomically, so the process may be killed at any point and the store will
+// still contain the previously saved data. It is safe to use the store from multiple goroutines.
+//
+// Storer methods cannot return errors, so failed writes are reported to OnError instead. Rooms which failed to
+// be written are written again by the next SaveNextBatch or SaveSlidingSyncPos, and the token or position is only
+// saved once every room has been, so that it never advances past room state which is not on disk.
+type FileStore struct {
+	Dir     string
+	OnError func(err error) // Called with any error encountered while writing. Errors are ignored if nil.
+
+	mu      sync.Mutex
+	tokens  fileStoreTokens
+	rooms   map[string]*Room
+	unsaved map[string]bool // IDs of rooms whose last write failed.
+}
+
+type fileStoreTokens struct {
+	Filters        map[string]string                    `json:"filters"`
+	NextBatch      map[string]string                    `json:"next_batch"`
+	SlidingSyncPos map[string]map[string]SlidingSyncPos `json:"sliding_sync_pos,omitempty"` // User IDs to connection IDs to positions.
+}
+
+// NewFileStore opens the store in the given directory, creating it if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "rooms"), 0700); err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		Dir: dir,
+		tokens: fileStoreTokens{
+			Filters:   make(map[string]string),
+			NextBatch: make(map[string]string),
+		},
+		rooms:   make(map[string]*Room),
+		unsaved: make(map[string]bool),
+	}
+	data, err := ioutil.ReadFile(s.tokensPath())
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.tokens); err != nil {
+		return nil, err
+	}
+	if s.tokens.Filters == nil {
+		s.tokens.Filters = make(map[string]string)
+	}
+	if s.tokens.NextBatch == nil {
+		s.tokens.NextBatch = make(map[string]string)
+	}
+	return s, nil
+}
+
+// SaveFilterID to disk.
+func (s *FileStore) SaveFilterID(userID, filterID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens.Filters[userID] = filterID
+	s.saveTokens()
+}
+
+// LoadFilterID from disk.
+func (s *FileStore) LoadFilterID(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens.Filters[userID]
+}
+
+// SaveNextBatch to disk, unless a room could not be saved. The token is then left as it was.
+func (s *FileStore) SaveNextBatch(userID, nextBatchToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.writeUnsavedRooms() {
+		return
+	}
+	s.tokens.NextBatch[userID] = nextBatchToken
+	s.saveTokens()
+}
+
+// LoadNextBatch from disk.
+func (s *FileStore) LoadNextBatch(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens.NextBatch[userID]
+}
+
+// SaveSlidingSyncPos to disk, unless a room could not be saved. The position is then left as it was.
+func (s *FileStore) SaveSlidingSyncPos(userID, connID string, pos SlidingSyncPos) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.writeUnsavedRooms() {
+		return
+	}
+	if s.tokens.SlidingSyncPos == nil {
+		s.tokens.SlidingSyncPos = make(map[string]map[string]SlidingSyncPos)
+	}
+	if s.tokens.SlidingSyncPos[userID] == nil {
+		s.tokens.SlidingSyncPos[userID] = make(map[string]SlidingSyncPos)
+	}
+	s.tokens.SlidingSyncPos[userID][connID] = pos
+	s.saveTokens()
+}
+
+// LoadSlidingSyncPos from disk.
+func (s *FileStore) LoadSlidingSyncPos(userID, connID string) SlidingSyncPos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens.SlidingSyncPos[userID][connID]
+}
+
+// SaveRoom to disk.
+func (s *FileStore) SaveRoom(room *Room) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rooms[room.ID] = room
+	s.writeRoom(room)
+}
+
+// LoadRoom from disk. Returns nil if the room has never been saved.
+func (s *FileStore) LoadRoom(roomID string) *Room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room, ok := s.rooms[roomID]; ok {
+		return room
+	}
+	data, err := ioutil.ReadFile(s.roomPath(roomID))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.handleError(err)
+		}
+		return nil
+	}
+	room := NewRoom(roomID)
+	if err = json.Unmarshal(data, room); err != nil {
+		s.handleError(err)
+		return nil
+	}
+	s.rooms[roomID] = room
+	return room
+}
+
+// writeRoom writes the room to its file, remembering it as unsaved if that fails.
+func (s *FileStore) writeRoom(room *Room) error {
+	data, err := json.Marshal(room)
+	if err == nil {
+		err = atomicfile.Write(s.roomPath(room.ID), data)
+	}
+	if err != nil {
+		s.unsaved[room.ID] = true
+		s.handleError(err)
+		return err
+	}
+	delete(s.unsaved, room.ID)
+	return nil
+}
+
+// writeUnsavedRooms writes the rooms whose last write failed again, and reports whether all of them are saved.
+func (s *FileStore) writeUnsavedRooms() bool {
+	for roomID := range s.unsaved {
+		if s.writeRoom(s.rooms[roomID]) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *FileStore) saveTokens() {
+	data, err := json.Marshal(&s.tokens)
+	if err != nil {
+		s.handleError(err)
+		return
+	}
+	s.handleError(atomicfile.Write(s.tokensPath(), data))
+}
+
+func (s *FileStore) handleError(err error) {
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *FileStore) tokensPath() string {
+	return filepath.Join(s.Dir, "tokens.json")
+}
+
+// roomPath returns the path of the room's file. Room IDs are encoded as they contain characters which are not
+// valid in file names on every platform.
+func (s *FileStore) roomPath(roomID string) string {
+	return filepath.Join(s.Dir, "rooms", base64.RawURLEncoding.EncodeToString([]byte(roomID))+".json")
+}
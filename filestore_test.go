@@ -0,0 +1,120 @@
+package gomatrix
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("TestFileStoreReopen => NewFileStore: %s", err)
+	}
+	s.OnError = func(err error) { t.Fatalf("TestFileStoreReopen => OnError: %s", err) }
+
+	stateKey := "@alice:example.com"
+	room := NewRoom("!room:example.com")
+	room.UpdateState(&Event{
+		Type:     "m.room.member",
+		StateKey: &stateKey,
+		Content:  map[string]interface{}{"membership": "join"},
+	})
+	s.SaveRoom(room)
+	s.SaveFilterID("@alice:example.com", "filter")
+	s.SaveNextBatch("@alice:example.com", "s1")
+	s.SaveNextBatch("@alice:example.com", "s2")
+	s.SaveSlidingSyncPos("@alice:example.com", "conn", SlidingSyncPos{Pos: "5", ToDeviceSince: "td"})
+
+	s, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("TestFileStoreReopen => NewFileStore: %s", err)
+	}
+	if got := s.LoadFilterID("@alice:example.com"); got != "filter" {
+		t.Fatalf("TestFileStoreReopen => LoadFilterID Got: %q Expected: %q", got, "filter")
+	}
+	if got := s.LoadNextBatch("@alice:example.com"); got != "s2" {
+		t.Fatalf("TestFileStoreReopen => LoadNextBatch Got: %q Expected: %q", got, "s2")
+	}
+	if got := s.LoadSlidingSyncPos("@alice:example.com", "conn"); got != (SlidingSyncPos{Pos: "5", ToDeviceSince: "td"}) {
+		t.Fatalf("TestFileStoreReopen => LoadSlidingSyncPos Got: %+v Expected: 5 and td", got)
+	}
+	loaded := s.LoadRoom("!room:example.com")
+	if loaded == nil {
+		t.Fatal("TestFileStoreReopen => LoadRoom Got: nil Expected: the saved room")
+	}
+	if got := loaded.GetMembershipState("@alice:example.com"); got != "join" {
+		t.Fatalf("TestFileStoreReopen => GetMembershipState Got: %q Expected: %q", got, "join")
+	}
+	if s.LoadRoom("!unknown:example.com") != nil {
+		t.Fatal("TestFileStoreReopen => LoadRoom of an unknown room Got: a room Expected: nil")
+	}
+}
+
+func TestSyncDoesNotAdvancePastUnsavedRooms(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("TestSyncDoesNotAdvancePastUnsavedRooms => NewFileStore: %s", err)
+	}
+	var writeErrs int
+	store.OnError = func(err error) { writeErrs++ }
+	store.SaveFilterID("@alice:example.com", "filter")
+	store.SaveNextBatch("@alice:example.com", "s1")
+	// Replace the rooms directory with a file, so that rooms cannot be written.
+	roomsDir := filepath.Join(dir, "rooms")
+	if err = os.RemoveAll(roomsDir); err == nil {
+		err = ioutil.WriteFile(roomsDir, nil, 0600)
+	}
+	if err != nil {
+		t.Fatalf("TestSyncDoesNotAdvancePastUnsavedRooms => Failed to break the rooms directory: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("since") != "s1" {
+			// The next sync after the batch: stop syncing.
+			cancel()
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(`{"next_batch":"s2","rooms":{"join":{"!room:example.com":{"state":{"events":[
+			{"type":"m.room.name","state_key":"","content":{"name":"Room"}}
+		]}}}}}`))
+	}))
+	cli.Store = store
+	cli.Syncer = NewDefaultSyncer(cli.UserID, store)
+	if err = cli.Sync(ctx); err != context.Canceled {
+		t.Fatalf("TestSyncDoesNotAdvancePastUnsavedRooms => Sync Got: %v Expected: %v", err, context.Canceled)
+	}
+	if writeErrs == 0 {
+		t.Fatal("TestSyncDoesNotAdvancePastUnsavedRooms => Got: no write errors Expected: the room write to fail")
+	}
+	if err = os.Remove(roomsDir); err == nil {
+		err = os.Mkdir(roomsDir, 0700)
+	}
+	if err != nil {
+		t.Fatalf("TestSyncDoesNotAdvancePastUnsavedRooms => Failed to restore the rooms directory: %s", err)
+	}
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("TestSyncDoesNotAdvancePastUnsavedRooms => NewFileStore: %s", err)
+	}
+	if got := reopened.LoadNextBatch("@alice:example.com"); got != "s1" {
+		t.Fatalf("TestSyncDoesNotAdvancePastUnsavedRooms => LoadNextBatch Got: %q Expected: %q", got, "s1")
+	}
+
+	// Now that rooms can be written again, the next token is saved along with the room which failed.
+	store.SaveNextBatch("@alice:example.com", "s2")
+	if reopened, err = NewFileStore(dir); err != nil {
+		t.Fatalf("TestSyncDoesNotAdvancePastUnsavedRooms => NewFileStore: %s", err)
+	}
+	if got := reopened.LoadNextBatch("@alice:example.com"); got != "s2" || reopened.LoadRoom("!room:example.com") == nil {
+		t.Fatalf("TestSyncDoesNotAdvancePastUnsavedRooms => LoadNextBatch Got: %q Expected: %q and the room", got, "s2")
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/qua3k/gomatrix
 
-go 1.17
+go 1.20
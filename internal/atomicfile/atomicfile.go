@@ -0,0 +1,39 @@
+// Package atomicfile replaces files atomically, for the stores which persist data to disk.
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write replaces the file at path with data. The data is written to a temporary file in the same directory which
+// is synced to disk and then renamed over path, so readers see either the old or the new contents. The file is
+// created readable by the owner only.
+func Write(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Sync the directory so the rename itself survives a crash. Not every platform supports this.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Thumbnail resizing methods - https://spec.matrix.org/v1.11/client-server-api/#thumbnails
+const (
+	ThumbnailMethodCrop  = "crop"
+	ThumbnailMethodScale = "scale"
+)
+
+// ErrInvalidMXC is returned when a content URI is not of the form mxc://<server-name>/<media-id>.
+var ErrInvalidMXC = errors.New("invalid mxc uri")
+
+// ParseMXC splits an MXC content URI into its server name and media ID.
+func ParseMXC(mxc string) (serverName, mediaID string, err error) {
+	rest := strings.TrimPrefix(mxc, "mxc://")
+	parts := strings.Split(rest, "/")
+	if rest == mxc || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidMXC, mxc)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Media is downloaded content. The caller must close it once done reading.
+type Media struct {
+	io.ReadCloser
+	ContentType string
+	FileName    string // The file name from the Content-Disposition header, if any.
+	Size        int64  // The size in bytes, or -1 if unknown.
+}
+
+// MediaConfig returns the content repository's configuration, trying the authenticated endpoint before the legacy
+// one. The result is cached on the client, and is used by UploadToContentRepo to refuse uploads which are too large.
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediaconfig
+func (cli *Client) MediaConfig(ctx context.Context) (*RespMediaConfig, error) {
+	cli.mediaConfigMutex.Lock()
+	defer cli.mediaConfigMutex.Unlock()
+	if cli.mediaConfig != nil {
+		return cli.mediaConfig, nil
+	}
+	var resp *RespMediaConfig
+	err := cli.MakeRequest(ctx, "GET", cli.BuildBaseURL("_matrix/client/v1/media/config"), nil, &resp)
+	if isUnsupportedEndpoint(err) {
+		err = cli.MakeRequest(ctx, "GET", cli.BuildBaseURL("_matrix/media/v3/config"), nil, &resp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cli.mediaConfig = resp
+	return resp, nil
+}
+
+// Download streams the content of an MXC URI, trying the authenticated endpoint before the legacy one.
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediadownloadservernamemediaid
+func (cli *Client) Download(ctx context.Context, mxc string) (*Media, error) {
+	serverName, mediaID, err := ParseMXC(mxc)
+	if err != nil {
+		return nil, err
+	}
+	return cli.downloadMedia(ctx,
+		cli.BuildBaseURL("_matrix/client/v1/media/download", serverName, mediaID),
+		cli.BuildBaseURL("_matrix/media/v3/download", serverName, mediaID))
+}
+
+// DownloadThumbnail streams a thumbnail of the content of an MXC URI, trying the authenticated endpoint before the
+// legacy one. method is ThumbnailMethodCrop or ThumbnailMethodScale.
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediathumbnailservernamemediaid
+func (cli *Client) DownloadThumbnail(ctx context.Context, mxc string, width, height int, method string) (*Media, error) {
+	serverName, mediaID, err := ParseMXC(mxc)
+	if err != nil {
+		return nil, err
+	}
+	query := map[string]string{
+		"width":  strconv.Itoa(width),
+		"height": strconv.Itoa(height),
+		"method": method,
+	}
+	return cli.downloadMedia(ctx,
+		cli.BuildBaseURLWithQuery([]string{"_matrix/client/v1/media/thumbnail", serverName, mediaID}, query),
+		cli.BuildBaseURLWithQuery([]string{"_matrix/media/v3/thumbnail", serverName, mediaID}, query))
+}
+
+func (cli *Client) downloadMedia(ctx context.Context, authenticatedURL, legacyURL string) (*Media, error) {
+	media, err := cli.getMedia(ctx, authenticatedURL)
+	if isUnsupportedEndpoint(err) {
+		media, err = cli.getMedia(ctx, legacyURL)
+	}
+	return media, err
+}
+
+func (cli *Client) getMedia(ctx context.Context, httpURL string) (*Media, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", httpURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cli.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cli.AccessToken)
+	}
+	res, err := cli.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+		contents, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		var wrap error
+		var respErr RespError
+		if _ = json.Unmarshal(contents, &respErr); respErr.ErrCode != "" {
+			wrap = respErr
+		}
+		return nil, HTTPError{
+			Contents:     contents,
+			Code:         res.StatusCode,
+			Message:      "Failed to GET media from " + req.URL.Path,
+			WrappedError: wrap,
+		}
+	}
+
+	media := &Media{ReadCloser: res.Body, ContentType: res.Header.Get("Content-Type"), Size: res.ContentLength}
+	if _, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition")); err == nil {
+		media.FileName = params["filename"]
+	}
+	return media, nil
+}
+
+// isUnsupportedEndpoint reports whether err is the response of a homeserver which does not implement an endpoint,
+// as opposed to a 404 for missing media.
+func isUnsupportedEndpoint(err error) bool {
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.Code {
+	case http.StatusMethodNotAllowed:
+		return true
+	case http.StatusNotFound:
+		return httpErr.WrappedError == nil || errors.Is(err, ErrUnrecognized)
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package gomatrix
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDownload(t *testing.T) {
+	var paths []string
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/_matrix/client/v1/"):
+			// An older homeserver without authenticated media.
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errcode":"M_UNRECOGNIZED","error":"Unrecognized request"}`))
+		case r.URL.Path == "/_matrix/media/v3/download/example.com/abc":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Disposition", `inline; filename="hello.txt"`)
+			w.Write([]byte("hello"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"Not found"}`))
+		}
+	}))
+
+	media, err := cli.Download(context.Background(), "mxc://example.com/abc")
+	if err != nil {
+		t.Fatalf("TestDownload => Download: %s", err)
+	}
+	defer media.Close()
+	body, _ := ioutil.ReadAll(media)
+	if string(body) != "hello" || media.ContentType != "text/plain" || media.FileName != "hello.txt" || media.Size != 5 {
+		t.Fatalf("TestDownload => Got: %q %+v Expected: hello text/plain hello.txt 5", body, media)
+	}
+	if len(paths) != 2 || paths[0] != "/_matrix/client/v1/media/download/example.com/abc" {
+		t.Fatalf("TestDownload => Got: %v Expected: the authenticated endpoint to be tried first", paths)
+	}
+
+	if _, err = cli.Download(context.Background(), "mxc://example.com/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("TestDownload => Got: %v Expected: %v", err, ErrNotFound)
+	}
+	if _, err = cli.Download(context.Background(), "https://example.com/abc"); !errors.Is(err, ErrInvalidMXC) {
+		t.Fatalf("TestDownload => Got: %v Expected: %v", err, ErrInvalidMXC)
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	uploaded := false
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/v1/media/config":
+			w.Write([]byte(`{"m.upload.size":4}`))
+		case "/_matrix/media/v3/upload":
+			uploaded = true
+			if body, _ := ioutil.ReadAll(r.Body); len(body) > 4 {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				w.Write([]byte(`{"errcode":"M_TOO_LARGE","error":"Upload request body is too large"}`))
+				return
+			}
+			w.Write([]byte(`{"content_uri":"mxc://example.com/abc"}`))
+		}
+	}))
+
+	if _, err := cli.UploadToContentRepo(context.Background(), strings.NewReader("hello"), "text/plain", 5); !errors.Is(err, ErrTooLarge) || uploaded {
+		t.Fatalf("TestUploadTooLarge => Got: %v uploaded %v Expected: %v", err, uploaded, ErrTooLarge)
+	}
+	// Without a length, the limit is left to the server.
+	if _, err := cli.UploadToContentRepo(context.Background(), strings.NewReader("hello"), "text/plain", -1); !errors.Is(err, ErrTooLarge) || !uploaded {
+		t.Fatalf("TestUploadTooLarge => Got: %v uploaded %v Expected: %v from the server", err, uploaded, ErrTooLarge)
+	}
+	resp, err := cli.UploadToContentRepo(context.Background(), strings.NewReader("hi"), "text/plain", 2)
+	if err != nil || resp.ContentURI != "mxc://example.com/abc" {
+		t.Fatalf("TestUploadTooLarge => Got: %v %v Expected: mxc://example.com/abc", resp, err)
+	}
+}
@@ -0,0 +1,148 @@
+package gomatrix
+
+import (
+	"context"
+	"sort"
+)
+
+// Member is a user's membership of a room, from their m.room.member event.
+type Member struct {
+	UserID      string
+	Membership  string // join, invite, leave, ban or knock.
+	DisplayName string // The display name the user set, which may be empty or shared with other members.
+	AvatarURL   string
+}
+
+func memberFromEvent(event *Event) Member {
+	m := Member{UserID: *event.StateKey}
+	m.Membership, _ = event.Content["membership"].(string)
+	m.DisplayName, _ = event.Content["displayname"].(string)
+	m.AvatarURL, _ = event.Content["avatar_url"].(string)
+	return m
+}
+
+// Member returns the membership of the user, or false if the room has no m.room.member event for them.
+func (room *Room) Member(userID string) (Member, bool) {
+	event := room.GetStateEvent("m.room.member", userID)
+	if event == nil {
+		return Member{}, false
+	}
+	return memberFromEvent(event), true
+}
+
+// Members returns the members of the room with any of the given memberships, or all members if none are given,
+// sorted by user ID.
+//
+// When members are lazy-loaded, the list only contains the members the server has sent so far. Use LoadMembers
+// to fetch the others.
+func (room *Room) Members(memberships ...string) []Member {
+	var members []Member
+	for _, event := range room.GetStateEvents("m.room.member") {
+		if event.StateKey == nil {
+			continue
+		}
+		m := memberFromEvent(event)
+		if len(memberships) == 0 || containsString(memberships, m.Membership) {
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members
+}
+
+// MemberDisplayName returns the name the user should be shown as. This is their display name, followed by their
+// user ID in parentheses if another joined or invited member has the same display name, or just their user ID if
+// they have no display name. See https://spec.matrix.org/v1.7/client-server-api/#calculating-the-display-name-for-a-user
+//
+// Clashes are counted from the room's state directly, so this is cheap enough to call for every event shown.
+func (room *Room) MemberDisplayName(userID string) string {
+	member, ok := room.Member(userID)
+	if !ok || member.DisplayName == "" {
+		return userID
+	}
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	for stateKey, event := range room.state["m.room.member"] {
+		if stateKey == userID || event.Content["displayname"] != member.DisplayName {
+			continue
+		}
+		if membership := event.Content["membership"]; membership == "join" || membership == "invite" {
+			return member.DisplayName + " (" + userID + ")"
+		}
+	}
+	return member.DisplayName
+}
+
+// JoinedMemberCount returns the number of joined members, from the room summary if sync has sent one and from the
+// room's state otherwise, which may be incomplete when members are lazy-loaded.
+func (room *Room) JoinedMemberCount() int {
+	room.mu.RLock()
+	joined := room.joinedCount
+	room.mu.RUnlock()
+	if joined != nil {
+		return *joined
+	}
+	count := 0
+	for _, event := range room.GetStateEvents("m.room.member") {
+		if event.Content["membership"] == "join" {
+			count++
+		}
+	}
+	return count
+}
+
+// MembersLoaded reports whether the full member list has been loaded with LoadMembers. Rooms synced without
+// lazy-loading have all members regardless.
+func (room *Room) MembersLoaded() bool {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	return room.membersLoaded
+}
+
+// LoadMembers fetches the m.room.member events of the room from /members and adds them to its state, for rooms
+// whose members are lazy-loaded by sync. Later member changes arrive through sync as usual, so this only needs to
+// be called once per room; it does nothing if the members have already been loaded. The room is saved to the
+// client's store afterwards.
+//
+// The members are requested at the next batch token in the client's store. Members the room already has came
+// from sync at that token or later, so they are kept rather than replaced by the possibly older ones from /members.
+func (cli *Client) LoadMembers(ctx context.Context, room *Room) error {
+	if room.MembersLoaded() {
+		return nil
+	}
+	var at string
+	if cli.Store != nil {
+		at = cli.Store.LoadNextBatch(cli.UserID)
+	}
+	resp, err := cli.GetMembers(ctx, at, "", "", room.ID)
+	if err != nil {
+		return err
+	}
+	for i := range resp.Chunk {
+		event := &resp.Chunk[i]
+		if event.Type != "m.room.member" || event.StateKey == nil {
+			continue
+		}
+		if room.GetStateEvent("m.room.member", *event.StateKey) != nil {
+			continue
+		}
+		event.RoomID = room.ID
+		room.UpdateState(event)
+	}
+	room.mu.Lock()
+	room.membersLoaded = true
+	room.mu.Unlock()
+	if cli.Store != nil {
+		cli.Store.SaveRoom(room)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
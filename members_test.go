@@ -0,0 +1,68 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRoomMembers(t *testing.T) {
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/v3/rooms/!room:example.com/members" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if at := r.URL.Query().Get("at"); at != "s1" {
+			t.Errorf("TestRoomMembers => Got at: %q Expected: s1", at)
+		}
+		w.Write([]byte(`{"chunk":[
+			{"type":"m.room.member","state_key":"@alice:example.com","content":{"membership":"leave"}},
+			{"type":"m.room.member","state_key":"@carol:example.com","content":{"membership":"join","displayname":"Alice"}},
+			{"type":"m.room.member","state_key":"@dave:example.com","content":{"membership":"leave","displayname":"Dave"}}
+		]}`))
+	}))
+	syncer := NewDefaultSyncer(cli.UserID, cli.Store)
+	var res RespSync
+	err := json.Unmarshal([]byte(`{"rooms":{"join":{"!room:example.com":{
+		"state":{"events":[
+			{"type":"m.room.member","state_key":"@alice:example.com","content":{"membership":"join","displayname":"Alice"}}
+		]},
+		"timeline":{"events":[
+			{"type":"m.room.member","state_key":"@bob:example.com","content":{"membership":"invite","displayname":"Bob","avatar_url":"mxc://example.com/bob"}}
+		]}
+	}}}}`), &res)
+	if err != nil {
+		t.Fatalf("TestRoomMembers => Failed to unmarshal: %s", err)
+	}
+	if err = syncer.ProcessResponse(&res, "since"); err != nil {
+		t.Fatalf("TestRoomMembers => ProcessResponse: %s", err)
+	}
+	cli.Store.SaveNextBatch(cli.UserID, "s1")
+	room := cli.Store.LoadRoom("!room:example.com")
+
+	if bob, ok := room.Member("@bob:example.com"); !ok || bob.Membership != "invite" || bob.AvatarURL != "mxc://example.com/bob" {
+		t.Fatalf("TestRoomMembers => Member Got: %+v Expected: bob invited with an avatar", bob)
+	}
+	if got := room.MemberDisplayName("@alice:example.com"); got != "Alice" {
+		t.Fatalf("TestRoomMembers => MemberDisplayName Got: %q Expected: Alice", got)
+	}
+
+	if err = cli.LoadMembers(context.Background(), room); err != nil || !room.MembersLoaded() {
+		t.Fatalf("TestRoomMembers => LoadMembers: %v", err)
+	}
+	for userID, expected := range map[string]string{
+		"@alice:example.com": "Alice (@alice:example.com)",
+		"@carol:example.com": "Alice (@carol:example.com)",
+		"@dave:example.com":  "Dave",
+		"@eve:example.com":   "@eve:example.com",
+	} {
+		if got := room.MemberDisplayName(userID); got != expected {
+			t.Fatalf("TestRoomMembers => MemberDisplayName Got: %q Expected: %q", got, expected)
+		}
+	}
+	// Alice's membership from sync is kept over the older one from /members.
+	if joined := room.Members("join"); len(joined) != 2 || joined[0].UserID != "@alice:example.com" || joined[1].UserID != "@carol:example.com" {
+		t.Fatalf("TestRoomMembers => Members Got: %+v Expected: alice and carol", joined)
+	}
+}
@@ -0,0 +1,452 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by ModifyPowerLevels and the helpers built on it.
+var (
+	ErrSelfDemotion        = errors.New("refusing to lower own power level")
+	ErrPowerLevelsConflict = errors.New("power levels were changed concurrently")
+)
+
+// UnmarshalJSON decodes the power levels. Levels may be numbers or, as in rooms created before room version 10,
+// strings containing integers.
+func (pl *RespPowerLevels) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Ban           *powerLevel           `json:"ban"`
+		Events        map[string]powerLevel `json:"events"`
+		EventsDefault powerLevel            `json:"events_default"`
+		Invite        *powerLevel           `json:"invite"`
+		Kick          *powerLevel           `json:"kick"`
+		Notifications struct {
+			Room *powerLevel `json:"room"`
+		} `json:"notifications"`
+		Redact       *powerLevel           `json:"redact"`
+		StateDefault *powerLevel           `json:"state_default"`
+		Users        map[string]powerLevel `json:"users"`
+		UsersDefault powerLevel            `json:"users_default"`
+		Room         powerLevel            `json:"room"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*pl = RespPowerLevels{
+		Ban:           raw.Ban.intPtr(),
+		Events:        levelMap(raw.Events),
+		EventsDefault: int(raw.EventsDefault),
+		Invite:        raw.Invite.intPtr(),
+		Kick:          raw.Kick.intPtr(),
+		Redact:        raw.Redact.intPtr(),
+		StateDefault:  raw.StateDefault.intPtr(),
+		Users:         levelMap(raw.Users),
+		UsersDefault:  int(raw.UsersDefault),
+		Room:          int(raw.Room),
+	}
+	pl.Notifications.Room = raw.Notifications.Room.intPtr()
+	return nil
+}
+
+// powerLevel is a power level in JSON, which is a number or a string containing an integer.
+type powerLevel int
+
+func (l *powerLevel) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		i, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid power level %q", s)
+		}
+		*l = powerLevel(i)
+		return nil
+	}
+	var i int
+	if err := json.Unmarshal(data, &i); err != nil {
+		return err
+	}
+	*l = powerLevel(i)
+	return nil
+}
+
+func (l *powerLevel) intPtr() *int {
+	if l == nil {
+		return nil
+	}
+	i := int(*l)
+	return &i
+}
+
+func levelMap(levels map[string]powerLevel) map[string]int {
+	if levels == nil {
+		return nil
+	}
+	m := make(map[string]int, len(levels))
+	for key, level := range levels {
+		m[key] = int(level)
+	}
+	return m
+}
+
+// PowerLevels returns the power levels from the m.room.power_levels event in the room's current state. If the
+// room has none, the levels which apply without one are returned, where the room creator has 100 and every other
+// power level is 0 except for kick, ban, redact and room notifications, which stay at 50.
+// See https://spec.matrix.org/v1.7/client-server-api/#mroompower_levels
+func (room *Room) PowerLevels() (*RespPowerLevels, error) {
+	if event := room.GetStateEvent("m.room.power_levels", ""); event != nil {
+		data, err := json.Marshal(event.Content)
+		if err != nil {
+			return nil, err
+		}
+		var pl RespPowerLevels
+		if err = json.Unmarshal(data, &pl); err != nil {
+			return nil, err
+		}
+		return &pl, nil
+	}
+
+	stateDefault := 0
+	pl := &RespPowerLevels{Users: map[string]int{}, StateDefault: &stateDefault}
+	if create := room.GetStateEvent("m.room.create", ""); create != nil {
+		// The creator key was removed in room version 11 in favour of the sender.
+		creator, _ := create.Content["creator"].(string)
+		if creator == "" {
+			creator = create.Sender
+		}
+		pl.Users[creator] = 100
+	}
+	return pl, nil
+}
+
+// UserLevel returns the power level of the user.
+func (pl *RespPowerLevels) UserLevel(userID string) int {
+	if level, ok := pl.Users[userID]; ok {
+		return level
+	}
+	return pl.UsersDefault
+}
+
+// EventLevel returns the power level required to send events of the given type.
+func (pl *RespPowerLevels) EventLevel(eventType string, isState bool) int {
+	if level, ok := pl.Events[eventType]; ok {
+		return level
+	}
+	if isState {
+		return pl.StateDefaultLevel()
+	}
+	return pl.EventsDefault
+}
+
+// StateDefaultLevel returns the power level required to send state events whose type has no level of its own.
+func (pl *RespPowerLevels) StateDefaultLevel() int {
+	return levelOrDefault(pl.StateDefault, 50)
+}
+
+// BanLevel returns the power level required to ban users.
+func (pl *RespPowerLevels) BanLevel() int {
+	return levelOrDefault(pl.Ban, 50)
+}
+
+// KickLevel returns the power level required to kick users.
+func (pl *RespPowerLevels) KickLevel() int {
+	return levelOrDefault(pl.Kick, 50)
+}
+
+// RedactLevel returns the power level required to redact events sent by other users.
+func (pl *RespPowerLevels) RedactLevel() int {
+	return levelOrDefault(pl.Redact, 50)
+}
+
+// InviteLevel returns the power level required to invite users.
+func (pl *RespPowerLevels) InviteLevel() int {
+	return levelOrDefault(pl.Invite, 0)
+}
+
+// CanSendEvent reports whether the user may send events of the given type, which is a state event type if isState.
+func (pl *RespPowerLevels) CanSendEvent(userID, eventType string, isState bool) bool {
+	return pl.UserLevel(userID) >= pl.EventLevel(eventType, isState)
+}
+
+// CanKick reports whether the user may kick the target, which requires the kick level and a higher level than
+// the target's.
+func (pl *RespPowerLevels) CanKick(userID, targetID string) bool {
+	level := pl.UserLevel(userID)
+	return level >= pl.KickLevel() && level > pl.UserLevel(targetID)
+}
+
+// CanBan reports whether the user may ban or unban the target, which requires the ban level and a higher level
+// than the target's.
+func (pl *RespPowerLevels) CanBan(userID, targetID string) bool {
+	level := pl.UserLevel(userID)
+	return level >= pl.BanLevel() && level > pl.UserLevel(targetID)
+}
+
+// CanRedact reports whether the user may redact an event sent by sender. Users may always redact their own events
+// if they may send redactions at all.
+func (pl *RespPowerLevels) CanRedact(userID, sender string) bool {
+	if !pl.CanSendEvent(userID, "m.room.redaction", false) {
+		return false
+	}
+	return userID == sender || pl.UserLevel(userID) >= pl.RedactLevel()
+}
+
+// CanInvite reports whether the user may invite other users.
+func (pl *RespPowerLevels) CanInvite(userID string) bool {
+	return pl.UserLevel(userID) >= pl.InviteLevel()
+}
+
+// NotificationLevel returns the power level required to send the notification with the given key, of which the
+// spec only defines "room". Unknown keys require 50.
+func (pl *RespPowerLevels) NotificationLevel(key string) int {
+	if key == "room" {
+		return levelOrDefault(pl.Notifications.Room, 50)
+	}
+	return 50
+}
+
+// CanNotifyRoom reports whether the user may notify the whole room with @room.
+func (pl *RespPowerLevels) CanNotifyRoom(userID string) bool {
+	return pl.UserLevel(userID) >= pl.NotificationLevel("room")
+}
+
+func levelOrDefault(level *int, def int) int {
+	if level == nil {
+		return def
+	}
+	return *level
+}
+
+// CheckPowerLevelsChange returns an M_FORBIDDEN RespError if the sender may not change the power levels from old to
+// updated, following the authorization rules for m.room.power_levels events. Otherwise it returns nil.
+// See https://spec.matrix.org/v1.7/rooms/v10/#authorization-rules
+func CheckPowerLevelsChange(sender string, old, updated *RespPowerLevels) error {
+	level := old.UserLevel(sender)
+	if !old.CanSendEvent(sender, "m.room.power_levels", true) {
+		return forbidden("%s may not send m.room.power_levels", sender)
+	}
+
+	for _, l := range []struct {
+		name          string
+		before, after int
+	}{
+		{"ban", old.BanLevel(), updated.BanLevel()},
+		{"kick", old.KickLevel(), updated.KickLevel()},
+		{"redact", old.RedactLevel(), updated.RedactLevel()},
+		{"invite", old.InviteLevel(), updated.InviteLevel()},
+		{"events_default", old.EventsDefault, updated.EventsDefault},
+		{"state_default", old.StateDefaultLevel(), updated.StateDefaultLevel()},
+		{"users_default", old.UsersDefault, updated.UsersDefault},
+		{"notifications.room", old.NotificationLevel("room"), updated.NotificationLevel("room")},
+	} {
+		if l.before != l.after && (l.before > level || l.after > level) {
+			return forbidden("%s may not change %s from %d to %d", sender, l.name, l.before, l.after)
+		}
+	}
+
+	for eventType := range unionKeys(old.Events, updated.Events) {
+		before, hadBefore := old.Events[eventType]
+		after, hasAfter := updated.Events[eventType]
+		if hadBefore == hasAfter && before == after {
+			continue
+		}
+		if (hadBefore && before > level) || (hasAfter && after > level) {
+			return forbidden("%s may not change the level of %s", sender, eventType)
+		}
+	}
+
+	for userID := range unionKeys(old.Users, updated.Users) {
+		before, hadBefore := old.Users[userID]
+		after, hasAfter := updated.Users[userID]
+		if hadBefore == hasAfter && before == after {
+			continue
+		}
+		// Users may lower their own level, but not that of others at or above it.
+		if (userID != sender && hadBefore && before >= level) || (hasAfter && after > level) {
+			return forbidden("%s may not change the level of %s", sender, userID)
+		}
+	}
+	return nil
+}
+
+// ModifyPowerLevels changes the power levels of the room with a read-modify-write: it fetches the current
+// m.room.power_levels content, calls modify with it, and sends the result. Keys which modify did not change are
+// sent as they were read, including ones RespPowerLevels does not know about.
+//
+// The change is refused with ErrSelfDemotion if it would lower the current user's level, or with the error from
+// CheckPowerLevelsChange if the user may not make it.
+//
+// The m.room.power_levels event is fetched again just before sending, and ErrPowerLevelsConflict is returned if it
+// is no longer the event that was read. The Matrix API has no conditional state updates, so a change made between
+// that check and the write can still be overwritten.
+func (cli *Client) ModifyPowerLevels(ctx context.Context, roomID string, modify func(pl *RespPowerLevels) error) (*RespSendEvent, error) {
+	event, err := cli.powerLevelsEvent(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	read := event.Content
+	old, err := decodePowerLevels(read)
+	if err != nil {
+		return nil, err
+	}
+	updated, _ := decodePowerLevels(read)
+	if err = modify(updated); err != nil {
+		return nil, err
+	}
+	if updated.UserLevel(cli.UserID) < old.UserLevel(cli.UserID) {
+		return nil, ErrSelfDemotion
+	}
+	if err = CheckPowerLevelsChange(cli.UserID, old, updated); err != nil {
+		return nil, err
+	}
+	content, err := mergePowerLevels(read, old, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := cli.powerLevelsEvent(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if current.ID != event.ID {
+		return nil, ErrPowerLevelsConflict
+	}
+	return cli.SendStateEvent(ctx, roomID, "m.room.power_levels", "", content)
+}
+
+// SetUserPowerLevel sets the power level of a user in the room. See ModifyPowerLevels.
+func (cli *Client) SetUserPowerLevel(ctx context.Context, roomID, userID string, level int) (*RespSendEvent, error) {
+	return cli.ModifyPowerLevels(ctx, roomID, func(pl *RespPowerLevels) error {
+		if pl.Users == nil {
+			pl.Users = make(map[string]int)
+		}
+		pl.Users[userID] = level
+		return nil
+	})
+}
+
+// ResetUserPowerLevel removes a user from the users of the power levels, so that users_default applies to them.
+// See ModifyPowerLevels.
+func (cli *Client) ResetUserPowerLevel(ctx context.Context, roomID, userID string) (*RespSendEvent, error) {
+	return cli.ModifyPowerLevels(ctx, roomID, func(pl *RespPowerLevels) error {
+		delete(pl.Users, userID)
+		return nil
+	})
+}
+
+// SetEventPowerLevel sets the power level required to send events of the given type. See ModifyPowerLevels.
+func (cli *Client) SetEventPowerLevel(ctx context.Context, roomID, eventType string, level int) (*RespSendEvent, error) {
+	return cli.ModifyPowerLevels(ctx, roomID, func(pl *RespPowerLevels) error {
+		if pl.Events == nil {
+			pl.Events = make(map[string]int)
+		}
+		pl.Events[eventType] = level
+		return nil
+	})
+}
+
+// powerLevelsEvent returns the room's current m.room.power_levels event. It is taken from the room's full state,
+// as the endpoint for a single state event only returns its content and not its ID.
+func (cli *Client) powerLevelsEvent(ctx context.Context, roomID string) (*Event, error) {
+	var state []Event
+	if err := cli.MakeRequest(ctx, "GET", cli.BuildURL("rooms", roomID, "state"), nil, &state); err != nil {
+		return nil, err
+	}
+	for i := range state {
+		if state[i].Type == "m.room.power_levels" && state[i].StateKey != nil && *state[i].StateKey == "" {
+			return &state[i], nil
+		}
+	}
+	return nil, RespError{ErrCode: ErrNotFound.ErrCode, Err: "Room has no m.room.power_levels event"}
+}
+
+func decodePowerLevels(content map[string]interface{}) (*RespPowerLevels, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var pl RespPowerLevels
+	if err = json.Unmarshal(data, &pl); err != nil {
+		return nil, err
+	}
+	return &pl, nil
+}
+
+// mergePowerLevels returns the content which was read with the keys that differ between old and updated replaced.
+func mergePowerLevels(read map[string]interface{}, old, updated *RespPowerLevels) (map[string]interface{}, error) {
+	before, err := toJSONMap(old)
+	if err != nil {
+		return nil, err
+	}
+	after, err := toJSONMap(updated)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{}, len(read))
+	for key, value := range read {
+		merged[key] = value
+	}
+	keys := make(map[string]struct{}, len(after))
+	for key := range before {
+		keys[key] = struct{}{}
+	}
+	for key := range after {
+		keys[key] = struct{}{}
+	}
+	for key := range keys {
+		if reflect.DeepEqual(before[key], after[key]) {
+			continue
+		}
+		value, ok := after[key]
+		if key == "notifications" {
+			// Keep notification levels other than room.
+			notifications := make(map[string]interface{})
+			if readNotifications, ok := read[key].(map[string]interface{}); ok {
+				for k, v := range readNotifications {
+					notifications[k] = v
+				}
+			}
+			if updated.Notifications.Room != nil {
+				notifications["room"] = *updated.Notifications.Room
+			} else {
+				delete(notifications, "room")
+			}
+			value = notifications
+		}
+		if ok {
+			merged[key] = value
+		} else {
+			delete(merged, key)
+		}
+	}
+	return merged, nil
+}
+
+func toJSONMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func unionKeys(a, b map[string]int) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for key := range a {
+		keys[key] = struct{}{}
+	}
+	for key := range b {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+func forbidden(format string, args ...interface{}) error {
+	return RespError{ErrCode: ErrForbidden.ErrCode, Err: fmt.Sprintf(format, args...)}
+}
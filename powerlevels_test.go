@@ -0,0 +1,162 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestRoomPowerLevels(t *testing.T) {
+	room := NewRoom("!room:example.com")
+	create := `{"type":"m.room.create","state_key":"","sender":"@alice:example.com","content":{"room_version":"11"}}`
+	var event Event
+	json.Unmarshal([]byte(create), &event)
+	room.UpdateState(&event)
+
+	// Without power levels, only the creator has any rights beyond sending messages.
+	pl, err := room.PowerLevels()
+	if err != nil {
+		t.Fatalf("TestRoomPowerLevels => PowerLevels: %s", err)
+	}
+	if !pl.CanSendEvent("@alice:example.com", "m.room.name", true) || !pl.CanSendEvent("@bob:example.com", "m.room.name", true) {
+		t.Fatal("TestRoomPowerLevels => Got: state events forbidden Expected: state_default 0 without power levels")
+	}
+	if !pl.CanKick("@alice:example.com", "@bob:example.com") || pl.CanKick("@bob:example.com", "@carol:example.com") {
+		t.Fatal("TestRoomPowerLevels => CanKick Got: wrong answer Expected: only the creator may kick")
+	}
+
+	powerLevels := `{"type":"m.room.power_levels","state_key":"","content":{
+		"users":{"@alice:example.com":100,"@mod:example.com":50},
+		"events":{"m.room.topic":0},"events_default":0,"invite":50}}`
+	event = Event{}
+	json.Unmarshal([]byte(powerLevels), &event)
+	room.UpdateState(&event)
+	if pl, err = room.PowerLevels(); err != nil {
+		t.Fatalf("TestRoomPowerLevels => PowerLevels: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		got      bool
+		expected bool
+	}{
+		{"user sends message", pl.CanSendEvent("@bob:example.com", "m.room.message", false), true},
+		{"user sends state with default 50", pl.CanSendEvent("@bob:example.com", "m.room.name", true), false},
+		{"user sends overridden state", pl.CanSendEvent("@bob:example.com", "m.room.topic", true), true},
+		{"mod kicks user", pl.CanKick("@mod:example.com", "@bob:example.com"), true},
+		{"mod kicks mod", pl.CanKick("@mod:example.com", "@mod:example.com"), false},
+		{"mod bans admin", pl.CanBan("@mod:example.com", "@alice:example.com"), false},
+		{"user redacts own", pl.CanRedact("@bob:example.com", "@bob:example.com"), true},
+		{"user redacts other", pl.CanRedact("@bob:example.com", "@mod:example.com"), false},
+		{"mod redacts other", pl.CanRedact("@mod:example.com", "@bob:example.com"), true},
+		{"user invites", pl.CanInvite("@bob:example.com"), false},
+		{"mod notifies room", pl.CanNotifyRoom("@mod:example.com"), true},
+		{"user notifies room", pl.CanNotifyRoom("@bob:example.com"), false},
+	} {
+		if tc.got != tc.expected {
+			t.Fatalf("TestRoomPowerLevels => %s Got: %v Expected: %v", tc.name, tc.got, tc.expected)
+		}
+	}
+}
+
+func TestRespPowerLevelsStringLevels(t *testing.T) {
+	var pl RespPowerLevels
+	err := json.Unmarshal([]byte(`{"users":{"@mod:example.com":"50"},"kick":"60","state_default":"0","notifications":{"room":"20"}}`), &pl)
+	if err != nil {
+		t.Fatalf("TestRespPowerLevelsStringLevels => Failed to unmarshal: %s", err)
+	}
+	if pl.UserLevel("@mod:example.com") != 50 || pl.KickLevel() != 60 || pl.StateDefaultLevel() != 0 || pl.NotificationLevel("room") != 20 {
+		t.Fatalf("TestRespPowerLevelsStringLevels => Got: %+v Expected: the levels from the strings", pl)
+	}
+	if err = json.Unmarshal([]byte(`{"kick":"high"}`), &pl); err == nil {
+		t.Fatal("TestRespPowerLevelsStringLevels => Got: no error Expected: an error for a non-integer level")
+	}
+}
+
+func TestRespPowerLevelsRoundTrip(t *testing.T) {
+	var pl RespPowerLevels
+	if err := json.Unmarshal([]byte(`{"state_default":0,"notifications":{"room":0},"users":{}}`), &pl); err != nil {
+		t.Fatalf("TestRespPowerLevelsRoundTrip => Failed to unmarshal: %s", err)
+	}
+	data, err := json.Marshal(&pl)
+	if err != nil {
+		t.Fatalf("TestRespPowerLevelsRoundTrip => Failed to marshal: %s", err)
+	}
+	if string(data) != `{"notifications":{"room":0},"state_default":0,"users":{}}` {
+		t.Fatalf("TestRespPowerLevelsRoundTrip => Got: %s Expected: the explicit zeros to be kept", data)
+	}
+	pl = RespPowerLevels{}
+	if pl.StateDefaultLevel() != 50 || pl.NotificationLevel("room") != 50 {
+		t.Fatalf("TestRespPowerLevelsRoundTrip => Got: %d, %d Expected: 50 for absent levels", pl.StateDefaultLevel(), pl.NotificationLevel("room"))
+	}
+}
+
+func TestModifyPowerLevels(t *testing.T) {
+	eventID := "$pl1"
+	content := `{"users":{"@alice:example.com":100,"@mod:example.com":100},"historical":100,"notifications":{"room":50,"custom":10}}`
+	var gets int
+	var sent map[string]interface{}
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/_matrix/client/v3/rooms/!room:example.com/state":
+			gets++
+			w.Write([]byte(`[{"type":"m.room.create","state_key":"","event_id":"$create","content":{}},
+				{"type":"m.room.power_levels","state_key":"","event_id":"` + eventID + `","content":` + content + `}]`))
+		case r.Method == "PUT" && r.URL.Path == "/_matrix/client/v3/rooms/!room:example.com/state/m.room.power_levels":
+			json.NewDecoder(r.Body).Decode(&sent)
+			w.Write([]byte(`{"event_id":"$pl"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	ctx := context.Background()
+
+	if _, err := cli.SetUserPowerLevel(ctx, "!room:example.com", "@bob:example.com", 50); err != nil {
+		t.Fatalf("TestModifyPowerLevels => SetUserPowerLevel: %s", err)
+	}
+	users, _ := sent["users"].(map[string]interface{})
+	notifications, _ := sent["notifications"].(map[string]interface{})
+	if users["@bob:example.com"] != 50.0 || sent["historical"] != 100.0 || notifications["custom"] != 10.0 || gets != 2 {
+		t.Fatalf("TestModifyPowerLevels => Got: %v after %d reads Expected: bob at 50 with other keys kept", sent, gets)
+	}
+	if _, hasStateDefault := sent["state_default"]; hasStateDefault {
+		t.Fatalf("TestModifyPowerLevels => Got: %v Expected: unchanged defaults not to be added", sent)
+	}
+
+	sent = nil
+	for _, tc := range []struct {
+		name     string
+		modify   func(pl *RespPowerLevels) error
+		expected error
+	}{
+		{"self-demotion", func(pl *RespPowerLevels) error { pl.Users["@alice:example.com"] = 50; return nil }, ErrSelfDemotion},
+		{"above own level", func(pl *RespPowerLevels) error { pl.Users["@bob:example.com"] = 101; return nil }, ErrForbidden},
+		{"demote peer", func(pl *RespPowerLevels) error { delete(pl.Users, "@mod:example.com"); return nil }, ErrForbidden},
+	} {
+		if _, err := cli.ModifyPowerLevels(ctx, "!room:example.com", tc.modify); !errors.Is(err, tc.expected) || sent != nil {
+			t.Fatalf("TestModifyPowerLevels => %s Got: %v Expected: %v", tc.name, err, tc.expected)
+		}
+	}
+
+	// A level of 0 is sent explicitly, as omitting it would mean 50.
+	if _, err := cli.ModifyPowerLevels(ctx, "!room:example.com", func(pl *RespPowerLevels) error {
+		stateDefault := 0
+		pl.StateDefault = &stateDefault
+		return nil
+	}); err != nil || sent["state_default"] != 0.0 {
+		t.Fatalf("TestModifyPowerLevels => Got: %v, %v Expected: state_default 0", sent, err)
+	}
+
+	sent = nil
+	// The power levels change between the read and the write.
+	_, err := cli.ModifyPowerLevels(ctx, "!room:example.com", func(pl *RespPowerLevels) error {
+		eventID = "$pl2"
+		pl.Users["@bob:example.com"] = 50
+		return nil
+	})
+	if err != ErrPowerLevelsConflict || sent != nil {
+		t.Fatalf("TestModifyPowerLevels => Got: %v Expected: %v", err, ErrPowerLevelsConflict)
+	}
+}
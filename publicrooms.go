@@ -0,0 +1,88 @@
+package gomatrix
+
+import (
+	"context"
+)
+
+// PublicRoomsIterator pages through a public room directory, following next_batch tokens. Use it like a
+// bufio.Scanner:
+//
+//	it := cli.IteratePublicRooms("example.com", nil, 1000)
+//	for it.Next(ctx) {
+//		room := it.Room()
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type PublicRoomsIterator struct {
+	cli    *Client
+	server string
+	req    *ReqPublicRoomsFiltered
+	max    int
+
+	page  []PublicRoom
+	room  PublicRoom
+	count int
+	since string
+	done  bool
+	err   error
+}
+
+// IteratePublicRooms returns an iterator over the public room directory of the given server, or of the client's
+// homeserver if server is empty. If req is nil the whole directory is listed with GET /publicRooms, otherwise
+// req is used as the filter and its Since field is ignored. The iterator stops after max rooms, or at the end
+// of the directory if max is 0.
+func (cli *Client) IteratePublicRooms(server string, req *ReqPublicRoomsFiltered, max int) *PublicRoomsIterator {
+	if req != nil {
+		r := *req
+		req = &r
+	}
+	return &PublicRoomsIterator{cli: cli, server: server, req: req, max: max}
+}
+
+// Next advances the iterator to the next room, fetching the next page if needed. Returns false when the
+// directory is exhausted, the limit has been reached or an error occurred.
+func (it *PublicRoomsIterator) Next(ctx context.Context) bool {
+	if it.err != nil || (it.max > 0 && it.count >= it.max) {
+		return false
+	}
+	for len(it.page) == 0 {
+		if it.done {
+			return false
+		}
+		var resp *RespPublicRooms
+		if it.req == nil {
+			limit := 0
+			if it.max > 0 {
+				limit = it.max - it.count
+			}
+			resp, it.err = it.cli.PublicRooms(ctx, limit, it.since, it.server)
+		} else {
+			it.req.Since = it.since
+			if it.max > 0 {
+				it.req.Limit = it.max - it.count
+			}
+			resp, it.err = it.cli.PublicRoomsFiltered(ctx, it.server, it.req)
+		}
+		if it.err != nil {
+			return false
+		}
+		it.page = resp.Chunk
+		// Guard against servers which return the same token forever.
+		it.done = resp.NextBatch == "" || resp.NextBatch == it.since
+		it.since = resp.NextBatch
+	}
+	it.room, it.page = it.page[0], it.page[1:]
+	it.count++
+	return true
+}
+
+// Room returns the current room. Only valid after Next returned true.
+func (it *PublicRoomsIterator) Room() PublicRoom {
+	return it.room
+}
+
+// Err returns the error which stopped the iterator, if any.
+func (it *PublicRoomsIterator) Err() error {
+	return it.err
+}
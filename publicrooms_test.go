@@ -0,0 +1,43 @@
+package gomatrix
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestIteratePublicRooms(t *testing.T) {
+	var requests int
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if got := r.URL.Query().Get("server"); got != "example.org" {
+			t.Fatalf("TestIteratePublicRooms => server Got: %q Expected: %q", got, "example.org")
+		}
+		switch since := r.URL.Query().Get("since"); since {
+		case "":
+			fmt.Fprint(w, `{"chunk":[{"room_id":"!1:example.org"},{"room_id":"!2:example.org"}],"next_batch":"p2"}`)
+		case "p2":
+			fmt.Fprint(w, `{"chunk":[{"room_id":"!3:example.org"}]}`)
+		default:
+			t.Fatalf("TestIteratePublicRooms => unexpected since %q", since)
+		}
+	}))
+
+	var rooms []string
+	it := cli.IteratePublicRooms("example.org", nil, 0)
+	for it.Next(context.Background()) {
+		rooms = append(rooms, it.Room().RoomID)
+	}
+	if it.Err() != nil || len(rooms) != 3 || requests != 2 {
+		t.Fatalf("TestIteratePublicRooms => Got: %v after %d requests, %v Expected: 3 rooms after 2 requests", rooms, requests, it.Err())
+	}
+
+	requests = 0
+	it = cli.IteratePublicRooms("example.org", nil, 2)
+	for it.Next(context.Background()) {
+	}
+	if requests != 1 {
+		t.Fatalf("TestIteratePublicRooms => Got: %d requests Expected: 1 with a limit of 2", requests)
+	}
+}
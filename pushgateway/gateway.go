@@ -0,0 +1,124 @@
+// Package pushgateway implements the receiving side of the Matrix Push Gateway API, which homeservers use to
+// deliver notifications to a push provider.
+//
+// Specification can be found at https://spec.matrix.org/v1.7/push-gateway-api/
+package pushgateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/qua3k/gomatrix"
+)
+
+// NotifyPath is the path homeservers send notifications to. Register the Handler on it.
+const NotifyPath = "/_matrix/push/v1/notify"
+
+// Notification priorities.
+const (
+	PrioHigh = "high"
+	PrioLow  = "low"
+)
+
+// ErrRejected should be returned by a Handler's Notify function when the pushkey of a device is no longer valid,
+// so that the homeserver stops sending notifications to it.
+var ErrRejected = errors.New("pushkey rejected")
+
+// NotifyRequest is the JSON request for https://spec.matrix.org/v1.7/push-gateway-api/#post_matrixpushv1notify
+type NotifyRequest struct {
+	Notification Notification `json:"notification"`
+}
+
+// NotifyResponse is the JSON response for https://spec.matrix.org/v1.7/push-gateway-api/#post_matrixpushv1notify
+type NotifyResponse struct {
+	Rejected []string `json:"rejected"`
+}
+
+// Notification is a notification sent by the homeserver. Only Devices is always present; the event fields are
+// omitted for notifications which only update the unread counts, and the content is omitted for pushers whose
+// format is "event_id_only".
+type Notification struct {
+	EventID           string                 `json:"event_id,omitempty"`
+	RoomID            string                 `json:"room_id,omitempty"`
+	Type              string                 `json:"type,omitempty"`
+	Sender            string                 `json:"sender,omitempty"`
+	SenderDisplayName string                 `json:"sender_display_name,omitempty"`
+	RoomName          string                 `json:"room_name,omitempty"`
+	RoomAlias         string                 `json:"room_alias,omitempty"`
+	UserIsTarget      bool                   `json:"user_is_target,omitempty"`
+	Prio              string                 `json:"prio,omitempty"`
+	Content           map[string]interface{} `json:"content,omitempty"`
+	Counts            Counts                 `json:"counts"`
+	Devices           []Device               `json:"devices"`
+}
+
+// Counts holds the unread counts of the user the notification is for.
+type Counts struct {
+	Unread      int `json:"unread,omitempty"`
+	MissedCalls int `json:"missed_calls,omitempty"`
+}
+
+// Device is a device the notification should be delivered to.
+type Device struct {
+	AppID     string                 `json:"app_id"`
+	PushKey   string                 `json:"pushkey"`
+	PushKeyTS int64                  `json:"pushkey_ts,omitempty"` // When the pushkey was last updated, in seconds.
+	Data      map[string]interface{} `json:"data,omitempty"`       // The pusher's data, without the URL.
+	Tweaks    map[string]interface{} `json:"tweaks,omitempty"`     // The tweaks from the matching push rule, such as sound.
+}
+
+// Handler is an http.Handler which implements the notify endpoint of a push gateway.
+type Handler struct {
+	// Notify delivers the notification to a single device. It returns ErrRejected, possibly wrapped, if the
+	// pushkey is no longer valid, or any other error if delivery failed and the homeserver should retry.
+	Notify func(ctx context.Context, n *Notification, device *Device) error
+	// OnRejected, if set, is called with the devices whose pushkeys were rejected before responding.
+	OnRejected func(ctx context.Context, n *Notification, rejected []Device)
+}
+
+// ServeHTTP handles a single notify request, delivering the notification to each of its devices in turn.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, gomatrix.RespError{ErrCode: gomatrix.ErrUnrecognized.ErrCode, Err: "Method not allowed"})
+		return
+	}
+	var req NotifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, gomatrix.RespError{ErrCode: gomatrix.ErrNotJSON.ErrCode, Err: err.Error()})
+		return
+	}
+	n := &req.Notification
+
+	var rejected []Device
+	var failed error
+	for i := range n.Devices {
+		err := h.Notify(r.Context(), n, &n.Devices[i])
+		if errors.Is(err, ErrRejected) {
+			rejected = append(rejected, n.Devices[i])
+		} else if err != nil && failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		writeError(w, http.StatusBadGateway, gomatrix.RespError{ErrCode: gomatrix.ErrUnknown.ErrCode, Err: failed.Error()})
+		return
+	}
+	if len(rejected) > 0 && h.OnRejected != nil {
+		h.OnRejected(r.Context(), n, rejected)
+	}
+
+	resp := NotifyResponse{Rejected: make([]string, 0, len(rejected))}
+	for _, device := range rejected {
+		resp.Rejected = append(resp.Rejected, device.PushKey)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeError(w http.ResponseWriter, code int, err gomatrix.RespError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(err)
+}
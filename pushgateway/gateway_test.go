@@ -0,0 +1,57 @@
+package pushgateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var delivered []string
+	var rejectedCallback []Device
+	h := &Handler{
+		Notify: func(ctx context.Context, n *Notification, device *Device) error {
+			if device.PushKey == "stale" {
+				return fmt.Errorf("gone: %w", ErrRejected)
+			}
+			delivered = append(delivered, n.EventID+" "+device.PushKey)
+			return nil
+		},
+		OnRejected: func(ctx context.Context, n *Notification, rejected []Device) {
+			rejectedCallback = rejected
+		},
+	}
+	body := `{"notification":{"event_id":"$1","room_id":"!room:example.com","prio":"high","counts":{"unread":2},
+		"devices":[{"app_id":"app","pushkey":"fresh","tweaks":{"sound":"bing"}},{"app_id":"app","pushkey":"stale"}]}}`
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", NotifyPath, strings.NewReader(body)))
+
+	var resp NotifyResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("TestHandler => Got: %d %v Expected: 200", w.Code, err)
+	}
+	if len(resp.Rejected) != 1 || resp.Rejected[0] != "stale" {
+		t.Fatalf("TestHandler => Rejected Got: %v Expected: [stale]", resp.Rejected)
+	}
+	if len(rejectedCallback) != 1 || rejectedCallback[0].PushKey != "stale" {
+		t.Fatalf("TestHandler => OnRejected Got: %v Expected: [stale]", rejectedCallback)
+	}
+	if len(delivered) != 1 || delivered[0] != "$1 fresh" {
+		t.Fatalf("TestHandler => Delivered Got: %v Expected: [$1 fresh]", delivered)
+	}
+
+	// Other failures are reported so that the homeserver retries.
+	h.Notify = func(ctx context.Context, n *Notification, device *Device) error {
+		return errors.New("provider unavailable")
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", NotifyPath, strings.NewReader(body)))
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("TestHandler => Got: %d Expected: %d", w.Code, http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,324 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Push rule kinds, in the order in which they are evaluated - https://spec.matrix.org/v1.7/client-server-api/#push-rules
+const (
+	PushRuleKindOverride  = "override"
+	PushRuleKindContent   = "content"
+	PushRuleKindRoom      = "room"
+	PushRuleKindSender    = "sender"
+	PushRuleKindUnderride = "underride"
+)
+
+// Push condition kinds - https://spec.matrix.org/v1.7/client-server-api/#conditions-1
+const (
+	PushCondEventMatch                   = "event_match"
+	PushCondEventPropertyIs              = "event_property_is"
+	PushCondEventPropertyContains        = "event_property_contains"
+	PushCondContainsDisplayName          = "contains_display_name"
+	PushCondRoomMemberCount              = "room_member_count"
+	PushCondSenderNotificationPermission = "sender_notification_permission"
+)
+
+// PushRuleset is a set of push rules, grouped by kind - https://spec.matrix.org/v1.7/client-server-api/#push-rules
+type PushRuleset struct {
+	Override  []PushRule `json:"override,omitempty"`
+	Content   []PushRule `json:"content,omitempty"`
+	Room      []PushRule `json:"room,omitempty"`
+	Sender    []PushRule `json:"sender,omitempty"`
+	Underride []PushRule `json:"underride,omitempty"`
+}
+
+// PushRule is a single push rule - https://spec.matrix.org/v1.7/client-server-api/#push-rules
+type PushRule struct {
+	RuleID     string          `json:"rule_id"`
+	Default    bool            `json:"default"`
+	Enabled    bool            `json:"enabled"`
+	Actions    []interface{}   `json:"actions"`              // Strings such as "notify" or tweaks such as {"set_tweak": "sound", "value": "default"}.
+	Conditions []PushCondition `json:"conditions,omitempty"` // Only for override and underride rules.
+	Pattern    string          `json:"pattern,omitempty"`    // Only for content rules.
+}
+
+// PushCondition is a condition of a push rule - https://spec.matrix.org/v1.7/client-server-api/#conditions-1
+type PushCondition struct {
+	Kind    string          `json:"kind"`
+	Key     string          `json:"key,omitempty"`
+	Pattern string          `json:"pattern,omitempty"`
+	Is      string          `json:"is,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"` // For event_property_is and event_property_contains.
+}
+
+// PushActions is the result of evaluating push rules for an event.
+type PushActions struct {
+	Notify    bool
+	Highlight bool
+	Sound     string    // The sound to play, or empty for none.
+	Rule      *PushRule // The rule which matched, or nil if none did.
+}
+
+// ParsePushActions interprets the actions of a push rule.
+func ParsePushActions(actions []interface{}) PushActions {
+	var result PushActions
+	for _, action := range actions {
+		switch a := action.(type) {
+		case string:
+			result.Notify = result.Notify || a == "notify" || a == "coalesce"
+		case map[string]interface{}:
+			switch a["set_tweak"] {
+			case "sound":
+				result.Sound, _ = a["value"].(string)
+			case "highlight":
+				// The value defaults to true if omitted.
+				highlight, ok := a["value"].(bool)
+				result.Highlight = highlight || !ok
+			}
+		}
+	}
+	return result
+}
+
+// Evaluate returns the actions of the first enabled rule which matches the event, evaluating override, content,
+// room, sender and underride rules in that order. userID is the user the rules belong to, and room provides the
+// state needed by contains_display_name, room_member_count and sender_notification_permission conditions; it may
+// be nil, in which case those conditions never match.
+func (rs *PushRuleset) Evaluate(userID string, event *Event, room *Room) PushActions {
+	e := pushEvaluation{userID: userID, event: event, room: room}
+	kinds := []struct {
+		rules   []PushRule
+		matches func(rule *PushRule) bool
+	}{
+		{rs.Override, e.matchesConditions},
+		{rs.Content, e.matchesContent},
+		{rs.Room, func(rule *PushRule) bool { return rule.RuleID == event.RoomID }},
+		{rs.Sender, func(rule *PushRule) bool { return rule.RuleID == event.Sender }},
+		{rs.Underride, e.matchesConditions},
+	}
+	for _, kind := range kinds {
+		for i := range kind.rules {
+			rule := &kind.rules[i]
+			if rule.Enabled && kind.matches(rule) {
+				actions := ParsePushActions(rule.Actions)
+				actions.Rule = rule
+				return actions
+			}
+		}
+	}
+	return PushActions{}
+}
+
+// pushEvaluation holds the state for evaluating rules against a single event.
+type pushEvaluation struct {
+	userID string
+	event  *Event
+	room   *Room
+	flat   map[string]interface{} // The event as generic JSON, decoded on first use.
+}
+
+func (e *pushEvaluation) matchesContent(rule *PushRule) bool {
+	return e.matchesCondition(&PushCondition{Kind: PushCondEventMatch, Key: "content.body", Pattern: rule.Pattern})
+}
+
+func (e *pushEvaluation) matchesConditions(rule *PushRule) bool {
+	for i := range rule.Conditions {
+		if !e.matchesCondition(&rule.Conditions[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *pushEvaluation) matchesCondition(cond *PushCondition) bool {
+	switch cond.Kind {
+	case PushCondEventMatch:
+		value, ok := e.property(cond.Key).(string)
+		if !ok {
+			return false
+		}
+		// Bodies are matched by word, everything else as a whole.
+		return globMatch(cond.Pattern, value, cond.Key == "content.body")
+	case PushCondEventPropertyIs:
+		var expected interface{}
+		if json.Unmarshal(cond.Value, &expected) != nil || !isScalar(expected) {
+			return false
+		}
+		return e.property(cond.Key) == expected
+	case PushCondEventPropertyContains:
+		var expected interface{}
+		if json.Unmarshal(cond.Value, &expected) != nil || !isScalar(expected) {
+			return false
+		}
+		values, _ := e.property(cond.Key).([]interface{})
+		for _, value := range values {
+			if value == expected {
+				return true
+			}
+		}
+		return false
+	case PushCondContainsDisplayName:
+		if e.room == nil {
+			return false
+		}
+		body, _ := e.event.Body()
+		displayName := e.displayName()
+		if body == "" || displayName == "" {
+			return false
+		}
+		return regexpMatch(regexp.QuoteMeta(displayName), body, true)
+	case PushCondRoomMemberCount:
+		if e.room == nil {
+			return false
+		}
+		return compareMemberCount(cond.Is, e.room.JoinedMemberCount())
+	case PushCondSenderNotificationPermission:
+		if e.room == nil {
+			return false
+		}
+		pl, err := e.room.PowerLevels()
+		if err != nil {
+			return false
+		}
+		return pl.UserLevel(e.event.Sender) >= pl.NotificationLevel(cond.Key)
+	}
+	// Unknown conditions never match.
+	return false
+}
+
+// property returns the value at the dotted path in the event's JSON, or nil.
+func (e *pushEvaluation) property(key string) interface{} {
+	if e.flat == nil {
+		e.flat = make(map[string]interface{})
+		if data, err := json.Marshal(e.event); err == nil {
+			json.Unmarshal(data, &e.flat)
+		}
+	}
+	var value interface{} = e.flat
+	for _, part := range splitPropertyPath(key) {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+func (e *pushEvaluation) displayName() string {
+	member := e.room.GetStateEvent("m.room.member", e.userID)
+	if member == nil {
+		return ""
+	}
+	displayName, _ := member.Content["displayname"].(string)
+	return displayName
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, float64, bool, nil:
+		return true
+	}
+	return false
+}
+
+// splitPropertyPath splits a dotted path, where "\." is a literal dot and "\\" a literal backslash.
+func splitPropertyPath(key string) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case c == '\\' && i+1 < len(key) && (key[i+1] == '.' || key[i+1] == '\\'):
+			i++
+			part.WriteByte(key[i])
+		case c == '.':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return append(parts, part.String())
+}
+
+// globMatch matches a case-insensitive glob, where * matches any sequence of characters and ? any single one,
+// against the whole value or, if words is true, against any sequence of whole words in it.
+func globMatch(pattern, value string, words bool) bool {
+	var expr strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*?")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return regexpMatch(expr.String(), value, words)
+}
+
+// regexpMatch matches a case-insensitive regular expression like globMatch.
+func regexpMatch(expr, value string, words bool) bool {
+	if words {
+		expr = `(^|[^\pL\pN_])` + expr + `([^\pL\pN_]|$)`
+	} else {
+		expr = `^` + expr + `$`
+	}
+	re := compilePattern(`(?is)` + expr)
+	return re != nil && re.MatchString(value)
+}
+
+// maxCompiledPatterns bounds compiledPatterns. The patterns come from the user's push rules and display name, so
+// the limit is only reached if those keep changing.
+const maxCompiledPatterns = 1024
+
+// compiledPatterns caches the regular expressions of push rule patterns, as every rule is matched against every
+// event. Invalid expressions are cached as nil.
+var compiledPatterns = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compilePattern returns the compiled expression, or nil if it is invalid.
+func compilePattern(expr string) *regexp.Regexp {
+	compiledPatterns.Lock()
+	defer compiledPatterns.Unlock()
+	re, ok := compiledPatterns.m[expr]
+	if !ok {
+		if len(compiledPatterns.m) >= maxCompiledPatterns {
+			compiledPatterns.m = make(map[string]*regexp.Regexp)
+		}
+		re, _ = regexp.Compile(expr)
+		compiledPatterns.m[expr] = re
+	}
+	return re
+}
+
+var memberCountRegex = regexp.MustCompile(`^(==|<=|>=|<|>)?([0-9]+)$`)
+
+func compareMemberCount(is string, count int) bool {
+	m := memberCountRegex.FindStringSubmatch(is)
+	if m == nil {
+		return false
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil {
+		return false
+	}
+	switch m[1] {
+	case "<":
+		return count < n
+	case ">":
+		return count > n
+	case "<=":
+		return count <= n
+	case ">=":
+		return count >= n
+	}
+	return count == n
+}
@@ -0,0 +1,117 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGetPushRules(t *testing.T) {
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The trailing slash is part of the endpoint, and servers answer the path without it with a 404.
+		if r.URL.Path != "/_matrix/client/v3/pushrules/" {
+			t.Errorf("TestGetPushRules => Got path: %s Expected: /_matrix/client/v3/pushrules/", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"global":{"override":[{"rule_id":".m.rule.master","default":true,"enabled":false,"actions":[]}]}}`))
+	}))
+	resp, err := cli.GetPushRules(context.Background())
+	if err != nil {
+		t.Fatalf("TestGetPushRules => GetPushRules: %s", err)
+	}
+	if len(resp.Global.Override) != 1 || resp.Global.Override[0].RuleID != ".m.rule.master" {
+		t.Fatalf("TestGetPushRules => Got: %+v Expected: the master rule", resp.Global)
+	}
+}
+
+func TestPushRulesetEvaluate(t *testing.T) {
+	var rs PushRuleset
+	err := json.Unmarshal([]byte(`{
+		"override": [
+			{"rule_id": ".m.rule.master", "default": true, "enabled": false, "actions": []},
+			{"rule_id": "mention", "enabled": true, "actions": ["notify", {"set_tweak": "highlight"}],
+			 "conditions": [{"kind": "event_property_contains", "key": "content.m\\.mentions.user_ids", "value": "@alice:example.com"}]},
+			{"rule_id": "notice", "enabled": true, "actions": [],
+			 "conditions": [{"kind": "event_property_is", "key": "content.msgtype", "value": "m.notice"}]},
+			{"rule_id": "room_ping", "enabled": true, "actions": ["notify"],
+			 "conditions": [{"kind": "event_match", "key": "content.body", "pattern": "@room"},
+			                {"kind": "sender_notification_permission", "key": "room"}]}
+		],
+		"content": [
+			{"rule_id": "cake", "enabled": true, "pattern": "ca?e*", "actions": ["notify", {"set_tweak": "sound", "value": "default"}]}
+		],
+		"underride": [
+			{"rule_id": "name", "enabled": true, "actions": ["notify", {"set_tweak": "highlight", "value": false}],
+			 "conditions": [{"kind": "contains_display_name"}]},
+			{"rule_id": "one_to_one", "enabled": true, "actions": ["notify"],
+			 "conditions": [{"kind": "room_member_count", "is": "2"}]}
+		]
+	}`), &rs)
+	if err != nil {
+		t.Fatalf("TestPushRulesetEvaluate => Failed to unmarshal: %s", err)
+	}
+
+	room := NewRoom("!room:example.com")
+	for _, raw := range []string{
+		`{"type":"m.room.member","state_key":"@alice:example.com","sender":"@alice:example.com","content":{"membership":"join","displayname":"Alice"}}`,
+		`{"type":"m.room.member","state_key":"@bob:example.com","sender":"@bob:example.com","content":{"membership":"join"}}`,
+		`{"type":"m.room.member","state_key":"@carol:example.com","sender":"@carol:example.com","content":{"membership":"leave"}}`,
+		`{"type":"m.room.power_levels","state_key":"","sender":"@bob:example.com","content":{"users":{"@bob:example.com":50}}}`,
+	} {
+		var event Event
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			t.Fatalf("TestPushRulesetEvaluate => Failed to unmarshal: %s", err)
+		}
+		room.UpdateState(&event)
+	}
+
+	for _, tc := range []struct {
+		sender  string
+		content string
+		rule    string
+		notify  bool
+		high    bool
+	}{
+		{"@bob:example.com", `{"body":"hi","m.mentions":{"user_ids":["@alice:example.com"]}}`, "mention", true, true},
+		{"@bob:example.com", `{"body":"hi alice","msgtype":"m.notice"}`, "notice", false, false},
+		{"@bob:example.com", `{"body":"hey @room!"}`, "room_ping", true, false},
+		{"@dave:example.com", `{"body":"hey @room!"}`, "one_to_one", true, false},
+		{"@bob:example.com", `{"body":"I like CAKEs"}`, "cake", true, false},
+		{"@bob:example.com", `{"body":"pancakes"}`, "one_to_one", true, false},
+		{"@bob:example.com", `{"body":"hello, alice."}`, "name", true, false},
+		{"@bob:example.com", `{"body":"malice"}`, "one_to_one", true, false},
+	} {
+		event := &Event{Type: "m.room.message", RoomID: room.ID, Sender: tc.sender}
+		if err := json.Unmarshal([]byte(tc.content), &event.Content); err != nil {
+			t.Fatalf("TestPushRulesetEvaluate => Failed to unmarshal: %s", err)
+		}
+		actions := rs.Evaluate("@alice:example.com", event, room)
+		if actions.Rule == nil || actions.Rule.RuleID != tc.rule || actions.Notify != tc.notify || actions.Highlight != tc.high {
+			t.Fatalf("TestPushRulesetEvaluate => %s Got: %+v Expected: rule %s notify %v highlight %v", tc.content, actions, tc.rule, tc.notify, tc.high)
+		}
+	}
+
+	// Once Carol joins, the room no longer has two members and nothing matches.
+	carol := "@carol:example.com"
+	room.UpdateState(&Event{Type: "m.room.member", StateKey: &carol, Content: map[string]interface{}{"membership": "join"}})
+	event := &Event{Type: "m.room.message", RoomID: room.ID, Sender: "@bob:example.com", Content: map[string]interface{}{"body": "malice"}}
+	if actions := rs.Evaluate("@alice:example.com", event, room); actions.Rule != nil {
+		t.Fatalf("TestPushRulesetEvaluate => Got: %s Expected: no match", actions.Rule.RuleID)
+	}
+
+	// The member count of the room summary takes precedence over the members in the state, which may be lazy-loaded.
+	joined := 2
+	room.UpdateSummary(RoomSummary{JoinedMemberCount: &joined})
+	if actions := rs.Evaluate("@alice:example.com", event, room); actions.Rule == nil || actions.Rule.RuleID != "one_to_one" {
+		t.Fatalf("TestPushRulesetEvaluate => Got: %+v Expected: one_to_one from the summary's count", actions.Rule)
+	}
+}
+
+func TestSplitPropertyPath(t *testing.T) {
+	got := splitPropertyPath(`content.m\.relates_to.a\\b`)
+	if len(got) != 3 || got[0] != "content" || got[1] != "m.relates_to" || got[2] != `a\b` {
+		t.Fatalf("TestSplitPropertyPath => Got: %q Expected: [content m.relates_to a\\b]", got)
+	}
+}
@@ -0,0 +1,120 @@
+package gomatrix
+
+import (
+	"sync"
+)
+
+// Receipt types - https://spec.matrix.org/v1.4/client-server-api/#receipts
+const (
+	ReceiptTypeRead        = "m.read"
+	ReceiptTypeReadPrivate = "m.read.private" // Only visible to the user who sent it.
+)
+
+// ThreadIDMain is the thread ID of receipts for the main timeline, which excludes events in threads.
+const ThreadIDMain = "main"
+
+// ReceiptContent is the content of an m.receipt event, keyed by event ID, receipt type and user ID -
+// https://spec.matrix.org/v1.4/client-server-api/#mreceipt
+type ReceiptContent map[string]map[string]map[string]Receipt
+
+// Receipt is a single receipt of an m.receipt event.
+type Receipt struct {
+	Timestamp int64  `json:"ts"`
+	ThreadID  string `json:"thread_id,omitempty"`
+}
+
+// ReadReceipt is the latest receipt of a user, as returned by ReceiptTracker.
+type ReadReceipt struct {
+	EventID   string
+	Type      string // ReceiptTypeRead or ReceiptTypeReadPrivate
+	Timestamp int64
+	ThreadID  string // Empty for unthreaded receipts.
+}
+
+// ReceiptTracker keeps track of the latest read receipt of every user in each room, as reported by m.receipt
+// events. Register it with a DefaultSyncer to keep it up to date:
+//
+//	receipts := gomatrix.NewReceiptTracker()
+//	syncer.OnEventType("m.receipt", receipts.Handle)
+//
+// Receipts only say which event a user has read up to. Whether that implies they have read an earlier event
+// depends on the order of the room's timeline, which the tracker does not know.
+//
+// It is safe to use the tracker from multiple goroutines.
+type ReceiptTracker struct {
+	mu sync.RWMutex
+	// Room ID to user ID to thread ID to the latest receipt in the thread.
+	rooms map[string]map[string]map[string]ReadReceipt
+}
+
+// NewReceiptTracker returns an empty ReceiptTracker.
+func NewReceiptTracker() *ReceiptTracker {
+	return &ReceiptTracker{rooms: make(map[string]map[string]map[string]ReadReceipt)}
+}
+
+// Handle updates the tracker from an m.receipt event. It is an OnEventListener.
+func (t *ReceiptTracker) Handle(event *Event) {
+	if event.Type != "m.receipt" || event.RoomID == "" {
+		return
+	}
+	content, err := event.ParseContent()
+	if err != nil {
+		return
+	}
+	receipts, ok := content.(*ReceiptContent)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	users := t.rooms[event.RoomID]
+	if users == nil {
+		users = make(map[string]map[string]ReadReceipt)
+		t.rooms[event.RoomID] = users
+	}
+	for eventID, types := range *receipts {
+		for receiptType, byUser := range types {
+			if receiptType != ReceiptTypeRead && receiptType != ReceiptTypeReadPrivate {
+				continue
+			}
+			for userID, receipt := range byUser {
+				threads := users[userID]
+				if threads == nil {
+					threads = make(map[string]ReadReceipt)
+					users[userID] = threads
+				}
+				if existing, ok := threads[receipt.ThreadID]; ok && existing.Timestamp > receipt.Timestamp {
+					continue
+				}
+				threads[receipt.ThreadID] = ReadReceipt{
+					EventID:   eventID,
+					Type:      receiptType,
+					Timestamp: receipt.Timestamp,
+					ThreadID:  receipt.ThreadID,
+				}
+			}
+		}
+	}
+}
+
+// LastRead returns the most recent read receipt of the user in the room, in any thread. Returns false if the
+// user has not sent a receipt since the tracker was started.
+func (t *ReceiptTracker) LastRead(roomID, userID string) (receipt ReadReceipt, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, r := range t.rooms[roomID][userID] {
+		if !ok || r.Timestamp > receipt.Timestamp {
+			receipt, ok = r, true
+		}
+	}
+	return
+}
+
+// LastReadInThread returns the most recent read receipt of the user in the given thread of the room. Use
+// ThreadIDMain for the main timeline, or an empty thread ID for unthreaded receipts.
+func (t *ReceiptTracker) LastReadInThread(roomID, userID, threadID string) (receipt ReadReceipt, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	receipt, ok = t.rooms[roomID][userID][threadID]
+	return
+}
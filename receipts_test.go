@@ -0,0 +1,32 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReceiptTracker(t *testing.T) {
+	tracker := NewReceiptTracker()
+	for _, raw := range []string{
+		`{"type":"m.receipt","content":{"$1":{"m.read":{"@bob:example.com":{"ts":100}}}}}`,
+		`{"type":"m.receipt","content":{"$2":{"m.read":{"@bob:example.com":{"ts":200,"thread_id":"$root"}}}}}`,
+		`{"type":"m.receipt","content":{"$0":{"m.read":{"@bob:example.com":{"ts":50}}}}}`,
+	} {
+		var event Event
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			t.Fatalf("TestReceiptTracker => Failed to unmarshal: %s", err)
+		}
+		event.RoomID = "!room:example.com"
+		tracker.Handle(&event)
+	}
+
+	if r, ok := tracker.LastRead("!room:example.com", "@bob:example.com"); !ok || r.EventID != "$2" {
+		t.Fatalf("TestReceiptTracker => LastRead Got: %+v Expected: $2", r)
+	}
+	if r, ok := tracker.LastReadInThread("!room:example.com", "@bob:example.com", ""); !ok || r.EventID != "$1" {
+		t.Fatalf("TestReceiptTracker => LastReadInThread Got: %+v Expected: $1", r)
+	}
+	if _, ok := tracker.LastRead("!room:example.com", "@carol:example.com"); ok {
+		t.Fatal("TestReceiptTracker => LastRead Got: a receipt Expected: none")
+	}
+}
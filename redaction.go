@@ -0,0 +1,92 @@
+package gomatrix
+
+import "strconv"
+
+// Redact returns a copy of the event with everything removed except the keys the redaction algorithm of the given
+// room version preserves. An empty room version is treated as version 1, and unknown ones as the latest version
+// this package knows about. The event itself is not modified.
+// See https://spec.matrix.org/v1.8/rooms/#room-versions
+func Redact(event *Event, roomVersion string) *Event {
+	version := 1
+	if roomVersion != "" {
+		var err error
+		if version, err = strconv.Atoi(roomVersion); err != nil || version > 11 {
+			version = 11
+		}
+	}
+
+	var keep []string
+	switch event.Type {
+	case "m.room.member":
+		keep = []string{"membership"}
+		if version >= 9 {
+			keep = append(keep, "join_authorised_via_users_server")
+		}
+	case "m.room.create":
+		if version >= 11 {
+			// The whole content is preserved.
+			for key := range event.Content {
+				keep = append(keep, key)
+			}
+		} else {
+			keep = []string{"creator"}
+		}
+	case "m.room.join_rules":
+		keep = []string{"join_rule"}
+		if version >= 8 {
+			keep = append(keep, "allow")
+		}
+	case "m.room.power_levels":
+		keep = []string{"ban", "events", "events_default", "kick", "redact", "state_default", "users", "users_default"}
+		if version >= 11 {
+			keep = append(keep, "invite")
+		}
+	case "m.room.aliases":
+		if version <= 5 {
+			keep = []string{"aliases"}
+		}
+	case "m.room.history_visibility":
+		keep = []string{"history_visibility"}
+	case "m.room.redaction":
+		if version >= 11 {
+			keep = []string{"redacts"}
+		}
+	}
+
+	content := make(map[string]interface{}, len(keep))
+	for _, key := range keep {
+		if value, ok := event.Content[key]; ok {
+			content[key] = value
+		}
+	}
+	if event.Type == "m.room.member" && version >= 11 {
+		// Only the signature of a third party invite is preserved.
+		if invite, ok := event.Content["third_party_invite"].(map[string]interface{}); ok {
+			if signed, ok := invite["signed"]; ok {
+				content["third_party_invite"] = map[string]interface{}{"signed": signed}
+			}
+		}
+	}
+
+	// Unsigned data, prev_content and the top-level redacts key are not preserved. Raw is dropped so that
+	// ParseContent decodes the redacted content.
+	return &Event{
+		Content:   content,
+		Type:      event.Type,
+		ID:        event.ID,
+		RoomID:    event.RoomID,
+		Sender:    event.Sender,
+		Timestamp: event.Timestamp,
+		StateKey:  event.StateKey,
+	}
+}
+
+// RedactedEventID returns the ID of the event an m.room.redaction event redacts, which is in the content from
+// room version 11 and at the top level before it.
+func (event *Event) RedactedEventID() string {
+	if event.Redacts != "" {
+		return event.Redacts
+	}
+	redacts, _ := event.Content["redacts"].(string)
+	return redacts
+}
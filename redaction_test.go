@@ -0,0 +1,77 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	member := &Event{Type: "m.room.member", ID: "$m", Content: map[string]interface{}{
+		"membership":                       "join",
+		"displayname":                      "Alice",
+		"join_authorised_via_users_server": "@admin:example.com",
+		"third_party_invite":               map[string]interface{}{"display_name": "alice", "signed": map[string]interface{}{"token": "abc"}},
+	}, Unsigned: map[string]interface{}{"age": 1.0}}
+	powerLevels := &Event{Type: "m.room.power_levels", Content: map[string]interface{}{"ban": 50.0, "invite": 0.0, "notifications": map[string]interface{}{}}}
+
+	for _, tc := range []struct {
+		event    *Event
+		version  string
+		expected string
+	}{
+		{member, "", `{"membership":"join"}`},
+		{member, "9", `{"join_authorised_via_users_server":"@admin:example.com","membership":"join"}`},
+		{member, "11", `{"join_authorised_via_users_server":"@admin:example.com","membership":"join","third_party_invite":{"signed":{"token":"abc"}}}`},
+		{powerLevels, "10", `{"ban":50}`},
+		{powerLevels, "11", `{"ban":50,"invite":0}`},
+		{&Event{Type: "m.room.create", Content: map[string]interface{}{"creator": "@a:b", "room_version": "10"}}, "10", `{"creator":"@a:b"}`},
+		{&Event{Type: "m.room.create", Content: map[string]interface{}{"room_version": "11"}}, "11", `{"room_version":"11"}`},
+		{&Event{Type: "m.room.message", Content: map[string]interface{}{"body": "hi"}}, "11", `{}`},
+	} {
+		redacted := Redact(tc.event, tc.version)
+		got, _ := json.Marshal(redacted.Content)
+		if string(got) != tc.expected || redacted.Unsigned != nil {
+			t.Fatalf("TestRedact => %s v%s Got: %s %v Expected: %s", tc.event.Type, tc.version, got, redacted.Unsigned, tc.expected)
+		}
+	}
+	if member.Content["displayname"] != "Alice" {
+		t.Fatal("TestRedact => Got: the original event was modified Expected: a copy")
+	}
+}
+
+func TestDefaultSyncerAppliesRedactions(t *testing.T) {
+	store := NewInMemoryStore()
+	syncer := NewDefaultSyncer("@alice:example.com", store)
+	var res RespSync
+	err := json.Unmarshal([]byte(`{"rooms":{"join":{"!room:example.com":{
+		"state":{"events":[
+			{"type":"m.room.create","state_key":"","event_id":"$create","content":{"room_version":"10"}},
+			{"type":"m.room.topic","state_key":"","event_id":"$topic","content":{"topic":"secret"}},
+			{"type":"m.room.name","state_key":"","event_id":"$name","content":{"name":"Room"}}
+		]},
+		"timeline":{"events":[
+			{"type":"m.room.redaction","event_id":"$redaction","sender":"@bob:example.com","redacts":"$topic","content":{}}
+		]}
+	}}}}`), &res)
+	if err != nil {
+		t.Fatalf("TestDefaultSyncerAppliesRedactions => Failed to unmarshal: %s", err)
+	}
+	if err = syncer.ProcessResponse(&res, "since"); err != nil {
+		t.Fatalf("TestDefaultSyncerAppliesRedactions => ProcessResponse: %s", err)
+	}
+
+	room := store.LoadRoom("!room:example.com")
+	topic := room.GetStateEvent("m.room.topic", "")
+	if topic == nil || len(topic.Content) != 0 || topic.ID != "$topic" {
+		t.Fatalf("TestDefaultSyncerAppliesRedactions => Got: %+v Expected: a redacted topic", topic)
+	}
+	if because, _ := topic.Unsigned["redacted_because"].(*Event); because == nil || because.ID != "$redaction" {
+		t.Fatalf("TestDefaultSyncerAppliesRedactions => redacted_because Got: %v Expected: $redaction", topic.Unsigned)
+	}
+	if name := room.GetStateEvent("m.room.name", ""); name == nil || name.Content["name"] != "Room" {
+		t.Fatalf("TestDefaultSyncerAppliesRedactions => Got: %+v Expected: the name to be untouched", name)
+	}
+	if original := res.Rooms.Join["!room:example.com"].State.Events[1]; original.Content["topic"] != "secret" {
+		t.Fatal("TestDefaultSyncerAppliesRedactions => Got: the original event was modified Expected: it to be replaced")
+	}
+}
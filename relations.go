@@ -0,0 +1,221 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Relation types - https://spec.matrix.org/v1.7/client-server-api/#forming-relationships-between-events
+const (
+	RelTypeReplace    = "m.replace"
+	RelTypeAnnotation = "m.annotation"
+	RelTypeThread     = "m.thread"
+	RelTypeReference  = "m.reference"
+)
+
+// RelatesTo is the m.relates_to object of an event's content, which relates it to another event.
+type RelatesTo struct {
+	RelType   string     `json:"rel_type,omitempty"`
+	EventID   string     `json:"event_id,omitempty"`
+	Key       string     `json:"key,omitempty"` // The reaction, for m.annotation relations.
+	InReplyTo *InReplyTo `json:"m.in_reply_to,omitempty"`
+	// For m.thread relations, whether InReplyTo only exists for clients which do not support threads.
+	IsFallingBack bool `json:"is_falling_back,omitempty"`
+}
+
+// InReplyTo identifies the event a message replies to - https://spec.matrix.org/v1.7/client-server-api/#rich-replies
+type InReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// ReactionContent is the content of an m.reaction event - https://spec.matrix.org/v1.7/client-server-api/#event-annotations-and-reactions
+type ReactionContent struct {
+	RelatesTo RelatesTo `json:"m.relates_to"`
+}
+
+// NewReaction returns the content of a reaction to the event, where key is usually an emoji.
+func NewReaction(to *Event, key string) ReactionContent {
+	return ReactionContent{RelatesTo: RelatesTo{RelType: RelTypeAnnotation, EventID: to.ID, Key: key}}
+}
+
+// NewEdit returns the content of an edit which replaces the text of the original event with that of newContent.
+// Edits of an edit apply to the event it edited, as edits must always refer to the original event.
+// See https://spec.matrix.org/v1.7/client-server-api/#event-replacements
+func NewEdit(original *Event, newContent TextMessage) TextMessage {
+	eventID := original.ID
+	if rel := original.RelatesTo(); rel != nil && rel.RelType == RelTypeReplace {
+		eventID = rel.EventID
+	}
+	newContent.RelatesTo = nil
+	newContent.NewContent = nil
+	edit := newContent
+	edit.Body = "* " + newContent.Body
+	if newContent.FormattedBody != "" {
+		edit.FormattedBody = "* " + newContent.FormattedBody
+	}
+	edit.NewContent = &newContent
+	edit.RelatesTo = &RelatesTo{RelType: RelTypeReplace, EventID: eventID}
+	return edit
+}
+
+// SetReply makes the message a reply to the event, adding the legacy reply fallback to its body and formatted body.
+// If the event is in a thread, the reply is sent in the same thread.
+// See https://spec.matrix.org/v1.7/client-server-api/#rich-replies
+func (m *TextMessage) SetReply(to *Event) {
+	m.RelatesTo = &RelatesTo{InReplyTo: &InReplyTo{EventID: to.ID}}
+	if root := to.ThreadRoot(); root != "" {
+		m.RelatesTo.RelType = RelTypeThread
+		m.RelatesTo.EventID = root
+	}
+
+	body, _ := to.Body()
+	body = StripReplyFallback(body)
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if i == 0 {
+			line = "<" + to.Sender + "> " + line
+		}
+		lines[i] = "> " + line
+	}
+
+	var quoted string
+	if format, _ := to.Content["format"].(string); format == "org.matrix.custom.html" {
+		quoted, _ = to.Content["formatted_body"].(string)
+		quoted = StripReplyFallbackHTML(quoted)
+	} else {
+		quoted = strings.ReplaceAll(html.EscapeString(body), "\n", "<br/>")
+	}
+	if m.Format == "" {
+		m.Format = "org.matrix.custom.html"
+		m.FormattedBody = strings.ReplaceAll(html.EscapeString(m.Body), "\n", "<br/>")
+	}
+	m.FormattedBody = `<mx-reply><blockquote>` +
+		`<a href="https://matrix.to/#/` + url.PathEscape(to.RoomID) + "/" + url.PathEscape(to.ID) + `">In reply to</a> ` +
+		`<a href="https://matrix.to/#/` + url.PathEscape(to.Sender) + `">` + html.EscapeString(to.Sender) + `</a>` +
+		`<br/>` + quoted + `</blockquote></mx-reply>` + m.FormattedBody
+	m.Body = strings.Join(lines, "\n") + "\n\n" + m.Body
+}
+
+// SetThread makes the message part of the thread the event belongs to, or starts a thread from the event if it is
+// not in one. The message falls back to a reply to the event for clients which do not support threads.
+// See https://spec.matrix.org/v1.7/client-server-api/#threading
+func (m *TextMessage) SetThread(in *Event) {
+	root := in.ThreadRoot()
+	if root == "" {
+		root = in.ID
+	}
+	m.RelatesTo = &RelatesTo{
+		RelType:       RelTypeThread,
+		EventID:       root,
+		InReplyTo:     &InReplyTo{EventID: in.ID},
+		IsFallingBack: true,
+	}
+}
+
+// SendReply sends an m.text reply to the event, in its thread if it is in one.
+func (cli *Client) SendReply(ctx context.Context, to *Event, text string) (*RespSendEvent, error) {
+	content := TextMessage{MsgType: "m.text", Body: text}
+	content.SetReply(to)
+	return cli.SendMessageEvent(ctx, to.RoomID, "m.room.message", content)
+}
+
+// SendThreadText sends an m.text message in the thread of the event, or starts a thread from it. See SetThread.
+func (cli *Client) SendThreadText(ctx context.Context, in *Event, text string) (*RespSendEvent, error) {
+	content := TextMessage{MsgType: "m.text", Body: text}
+	content.SetThread(in)
+	return cli.SendMessageEvent(ctx, in.RoomID, "m.room.message", content)
+}
+
+// SendEdit replaces the text of an m.text or m.notice event, keeping its msgtype.
+func (cli *Client) SendEdit(ctx context.Context, original *Event, text string) (*RespSendEvent, error) {
+	msgtype, _ := original.MessageType()
+	return cli.SendMessageEvent(ctx, original.RoomID, "m.room.message", NewEdit(original, TextMessage{MsgType: msgtype, Body: text}))
+}
+
+// SendReaction reacts to the event with the key, which is usually an emoji.
+func (cli *Client) SendReaction(ctx context.Context, to *Event, key string) (*RespSendEvent, error) {
+	return cli.SendMessageEvent(ctx, to.RoomID, "m.reaction", NewReaction(to, key))
+}
+
+// RelatesTo returns the m.relates_to object of the event's content, or nil if there is none.
+func (event *Event) RelatesTo() *RelatesTo {
+	raw, ok := event.Content["m.relates_to"]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var rel RelatesTo
+	if err = json.Unmarshal(data, &rel); err != nil {
+		return nil
+	}
+	return &rel
+}
+
+// ReplyTo returns the ID of the event the event replies to, or an empty string if it is not a reply. The reply
+// fallback of thread messages is not a reply.
+func (event *Event) ReplyTo() string {
+	rel := event.RelatesTo()
+	if rel == nil || rel.InReplyTo == nil || (rel.RelType == RelTypeThread && rel.IsFallingBack) {
+		return ""
+	}
+	return rel.InReplyTo.EventID
+}
+
+// ThreadRoot returns the ID of the root of the thread the event is in, or an empty string if it is not in one.
+func (event *Event) ThreadRoot() string {
+	if rel := event.RelatesTo(); rel != nil && rel.RelType == RelTypeThread {
+		return rel.EventID
+	}
+	return ""
+}
+
+// Replaces returns the ID of the event the event edits and the replacement content, or ok false if it is not
+// an edit.
+func (event *Event) Replaces() (eventID string, newContent map[string]interface{}, ok bool) {
+	rel := event.RelatesTo()
+	if rel == nil || rel.RelType != RelTypeReplace {
+		return "", nil, false
+	}
+	newContent, _ = event.Content["m.new_content"].(map[string]interface{})
+	return rel.EventID, newContent, true
+}
+
+// Reaction returns the ID of the event the event reacts to and the reaction key, or ok false if it is not
+// a reaction.
+func (event *Event) Reaction() (eventID, key string, ok bool) {
+	rel := event.RelatesTo()
+	if event.Type != "m.reaction" || rel == nil || rel.RelType != RelTypeAnnotation {
+		return "", "", false
+	}
+	return rel.EventID, rel.Key, true
+}
+
+// StripReplyFallback removes the legacy reply fallback from the start of a plain text body.
+func StripReplyFallback(body string) string {
+	if !strings.HasPrefix(body, "> ") {
+		return body
+	}
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], "> ") {
+		i++
+	}
+	if i < len(lines) && lines[i] == "" {
+		i++
+	}
+	return strings.Join(lines[i:], "\n")
+}
+
+var mxReplyRegex = regexp.MustCompile(`(?s)^<mx-reply>.*?</mx-reply>`)
+
+// StripReplyFallbackHTML removes the legacy reply fallback from the start of a formatted body.
+func StripReplyFallbackHTML(formattedBody string) string {
+	return mxReplyRegex.ReplaceAllLiteralString(formattedBody, "")
+}
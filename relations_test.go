@@ -0,0 +1,80 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// roundTrip sends content through JSON as the homeserver would and returns the received event.
+func roundTrip(t *testing.T, eventType string, content interface{}) *Event {
+	t.Helper()
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+	event := &Event{Type: eventType, ID: "$new", RoomID: "!room:example.com", Sender: "@bot:example.com"}
+	if err = json.Unmarshal(data, &event.Content); err != nil {
+		t.Fatalf("Failed to unmarshal: %s", err)
+	}
+	return event
+}
+
+func TestRelations(t *testing.T) {
+	original := &Event{
+		Type: "m.room.message", ID: "$orig", RoomID: "!room:example.com", Sender: "@alice:example.com",
+		Content: map[string]interface{}{"msgtype": "m.text", "body": "first line\nsecond <line>"},
+	}
+
+	reply := TextMessage{MsgType: "m.text", Body: "an answer"}
+	reply.SetReply(original)
+	if reply.Body != "> <@alice:example.com> first line\n> second <line>\n\nan answer" {
+		t.Fatalf("TestRelations => Reply body Got: %q", reply.Body)
+	}
+	if !strings.HasSuffix(reply.FormattedBody, "second &lt;line&gt;</blockquote></mx-reply>an answer") {
+		t.Fatalf("TestRelations => Reply formatted body Got: %q", reply.FormattedBody)
+	}
+	replyEvent := roundTrip(t, "m.room.message", reply)
+	if replyEvent.ReplyTo() != "$orig" || replyEvent.ThreadRoot() != "" {
+		t.Fatalf("TestRelations => ReplyTo Got: %q Expected: $orig", replyEvent.ReplyTo())
+	}
+	if body, _ := replyEvent.Body(); StripReplyFallback(body) != "an answer" {
+		t.Fatalf("TestRelations => StripReplyFallback Got: %q Expected: an answer", StripReplyFallback(body))
+	}
+	if got := StripReplyFallbackHTML(reply.FormattedBody); got != "an answer" {
+		t.Fatalf("TestRelations => StripReplyFallbackHTML Got: %q Expected: an answer", got)
+	}
+
+	// A thread started from the original, and a reply within it, both stay in the thread.
+	threaded := TextMessage{MsgType: "m.text", Body: "in thread"}
+	threaded.SetThread(original)
+	threadEvent := roundTrip(t, "m.room.message", threaded)
+	if threadEvent.ThreadRoot() != "$orig" || threadEvent.ReplyTo() != "" {
+		t.Fatalf("TestRelations => Thread Got: %q %q Expected: $orig and no reply", threadEvent.ThreadRoot(), threadEvent.ReplyTo())
+	}
+	threadReply := TextMessage{MsgType: "m.text", Body: "reply in thread"}
+	threadReply.SetReply(threadEvent)
+	threadReplyEvent := roundTrip(t, "m.room.message", threadReply)
+	if threadReplyEvent.ThreadRoot() != "$orig" || threadReplyEvent.ReplyTo() != "$new" {
+		t.Fatalf("TestRelations => Thread reply Got: %q %q Expected: $orig $new", threadReplyEvent.ThreadRoot(), threadReplyEvent.ReplyTo())
+	}
+
+	// Editing an edit replaces the original event.
+	edit := roundTrip(t, "m.room.message", NewEdit(original, TextMessage{MsgType: "m.text", Body: "fixed"}))
+	edit2 := roundTrip(t, "m.room.message", NewEdit(edit, TextMessage{MsgType: "m.text", Body: "fixed again"}))
+	eventID, newContent, ok := edit2.Replaces()
+	if body, _ := edit2.Body(); !ok || eventID != "$orig" || newContent["body"] != "fixed again" || body != "* fixed again" {
+		t.Fatalf("TestRelations => Replaces Got: %v %q %v %q Expected: $orig fixed again", ok, eventID, newContent, body)
+	}
+	if _, hasRel := newContent["m.relates_to"]; hasRel {
+		t.Fatal("TestRelations => m.new_content Got: m.relates_to Expected: none")
+	}
+
+	reaction := roundTrip(t, "m.reaction", NewReaction(original, "👍"))
+	if eventID, key, ok := reaction.Reaction(); !ok || eventID != "$orig" || key != "👍" {
+		t.Fatalf("TestRelations => Reaction Got: %v %q %q Expected: $orig 👍", ok, eventID, key)
+	}
+	if _, _, ok := original.Reaction(); ok {
+		t.Fatal("TestRelations => Reaction Got: a reaction Expected: none")
+	}
+}
@@ -102,7 +102,7 @@ type ReqPublicRoomsFiltered struct {
 	IncludeAllNetworks   bool   `json:"include_all_networks,omitempty"`
 	Limit                int    `json:"limit,omitempty"`
 	Since                string `json:"since,omitempty"`
-	ThirdPartyInstanceID string `json:"third_party_instance_id"`
+	ThirdPartyInstanceID string `json:"third_party_instance_id,omitempty"`
 }
 
 type ReqSearchUsers struct {
@@ -110,6 +110,176 @@ type ReqSearchUsers struct {
 	SearchTerm string `json:"search_term"`
 }
 
+// ReqSetProfile is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3profileuseridavatar_url
 type ReqSetProfile struct {
 	AvatarUrl string `json:"avatar_url"`
 }
+
+// ReqSetDisplayName is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3profileuseriddisplayname
+type ReqSetDisplayName struct {
+	DisplayName string `json:"displayname"`
+}
+
+// ReqSlidingSync is the JSON request for simplified sliding sync, see
+// https://github.com/matrix-org/matrix-spec-proposals/pull/4186
+//
+// The server does not remember request parameters between requests, so every request must contain all lists,
+// room subscriptions and extensions the client is interested in.
+type ReqSlidingSync struct {
+	ConnID            string                                 `json:"conn_id,omitempty"`
+	TxnID             string                                 `json:"txn_id,omitempty"`
+	Lists             map[string]SlidingSyncList             `json:"lists,omitempty"`
+	RoomSubscriptions map[string]SlidingSyncRoomSubscription `json:"room_subscriptions,omitempty"`
+	Extensions        SlidingSyncExtensions                  `json:"extensions"`
+}
+
+// SlidingSyncRoomSubscription describes which state and how much timeline to return for a room.
+type SlidingSyncRoomSubscription struct {
+	RequiredState [][2]string `json:"required_state"` // Pairs of [event type, state key]. "*" matches any value.
+	TimelineLimit int         `json:"timeline_limit"`
+}
+
+// SlidingSyncList is a window over the list of rooms the user is in, sorted by recent activity.
+type SlidingSyncList struct {
+	SlidingSyncRoomSubscription
+	Ranges  [][2]int            `json:"ranges"` // Inclusive [start, end] indexes of the rooms to return.
+	Filters *SlidingSyncFilters `json:"filters,omitempty"`
+}
+
+// SlidingSyncFilters restricts which rooms are included in a SlidingSyncList.
+type SlidingSyncFilters struct {
+	IsDM         *bool     `json:"is_dm,omitempty"`
+	IsEncrypted  *bool     `json:"is_encrypted,omitempty"`
+	IsInvite     *bool     `json:"is_invite,omitempty"`
+	Spaces       []string  `json:"spaces,omitempty"`
+	RoomTypes    []*string `json:"room_types,omitempty"` // A nil entry matches rooms without a type.
+	NotRoomTypes []*string `json:"not_room_types,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	NotTags      []string  `json:"not_tags,omitempty"`
+}
+
+// SlidingSyncExtensions enables the sliding sync extensions. A nil extension is disabled.
+type SlidingSyncExtensions struct {
+	ToDevice    *SlidingSyncToDeviceExtension `json:"to_device,omitempty"`
+	E2EE        *SlidingSyncExtension         `json:"e2ee,omitempty"`
+	AccountData *SlidingSyncRoomsExtension    `json:"account_data,omitempty"`
+	Typing      *SlidingSyncRoomsExtension    `json:"typing,omitempty"`
+	Receipts    *SlidingSyncRoomsExtension    `json:"receipts,omitempty"`
+}
+
+// SlidingSyncExtension is the request for an extension which takes no further parameters.
+type SlidingSyncExtension struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SlidingSyncRoomsExtension is the request for an extension which returns per-room data. If Lists and Rooms are
+// both empty, data is returned for all rooms in the response.
+type SlidingSyncRoomsExtension struct {
+	Enabled bool     `json:"enabled"`
+	Lists   []string `json:"lists,omitempty"`
+	Rooms   []string `json:"rooms,omitempty"`
+}
+
+// SlidingSyncToDeviceExtension is the request for the to-device extension.
+type SlidingSyncToDeviceExtension struct {
+	Enabled bool   `json:"enabled"`
+	Since   string `json:"since,omitempty"` // Set by SlidingSync from the previous response.
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// ReqUploadKeys is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysupload
+type ReqUploadKeys struct {
+	DeviceKeys  *DeviceKeys           `json:"device_keys,omitempty"`
+	OneTimeKeys map[string]OneTimeKey `json:"one_time_keys,omitempty"` // Keyed by "<algorithm>:<key ID>".
+}
+
+// ReqQueryKeys is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysquery
+type ReqQueryKeys struct {
+	DeviceKeys map[string][]string `json:"device_keys"` // User IDs to device IDs. An empty list queries all devices.
+	Timeout    int64               `json:"timeout,omitempty"`
+}
+
+// ReqClaimKeys is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysclaim
+type ReqClaimKeys struct {
+	OneTimeKeys map[string]map[string]string `json:"one_time_keys"` // User IDs to device IDs to key algorithms.
+	Timeout     int64                        `json:"timeout,omitempty"`
+}
+
+// ReqSendToDevice is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3sendtodeviceeventtypetxnid
+type ReqSendToDevice struct {
+	Messages map[string]map[string]interface{} `json:"messages"` // User IDs to device IDs (or "*") to contents.
+}
+
+// ReqUpdateDevice is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3devicesdeviceid
+type ReqUpdateDevice struct {
+	DisplayName *string `json:"display_name,omitempty"` // Left unchanged if nil. A pointer to "" clears the name.
+}
+
+// ReqDeleteDevice is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#delete_matrixclientv3devicesdeviceid
+type ReqDeleteDevice struct {
+	Auth interface{} `json:"auth,omitempty"`
+}
+
+// ReqDeleteDevices is the JSON request for https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3delete_devices
+type ReqDeleteDevices struct {
+	Devices []string    `json:"devices"`
+	Auth    interface{} `json:"auth,omitempty"`
+}
+
+// AuthTypePassword is the type of the password stage of user-interactive authentication.
+const AuthTypePassword = "m.login.password"
+
+// AuthPassword is the auth data for https://spec.matrix.org/v1.1/client-server-api/#password-based
+type AuthPassword struct {
+	Type       string     `json:"type"` // Set by NewAuthPassword
+	Session    string     `json:"session,omitempty"`
+	Identifier Identifier `json:"identifier"`
+	Password   string     `json:"password"`
+}
+
+// NewAuthPassword creates a new AuthPassword for the user ID in the given user-interactive authentication session.
+func NewAuthPassword(session, userID, password string) *AuthPassword {
+	return &AuthPassword{
+		Type:       AuthTypePassword,
+		Session:    session,
+		Identifier: NewUserIdentifier(userID),
+		Password:   password,
+	}
+}
+
+// ReqReceipt is the JSON request for https://spec.matrix.org/v1.4/client-server-api/#post_matrixclientv3roomsroomidreceiptreceipttypeeventid
+type ReqReceipt struct {
+	ThreadID string `json:"thread_id,omitempty"` // ThreadIDMain or the ID of a thread root. Unthreaded if empty.
+}
+
+// ReqSetReadMarkers is the JSON request for https://spec.matrix.org/v1.4/client-server-api/#post_matrixclientv3roomsroomidread_markers
+type ReqSetReadMarkers struct {
+	FullyRead   string `json:"m.fully_read,omitempty"`
+	Read        string `json:"m.read,omitempty"`
+	ReadPrivate string `json:"m.read.private,omitempty"`
+}
+
+// ReqPutPushRule is the JSON request for https://spec.matrix.org/v1.7/client-server-api/#put_matrixclientv3pushrulesscopekindruleid
+type ReqPutPushRule struct {
+	Actions    []interface{}   `json:"actions"`
+	Conditions []PushCondition `json:"conditions,omitempty"`
+	Pattern    string          `json:"pattern,omitempty"`
+}
+
+// ReqPushRuleEnabled is the JSON request for https://spec.matrix.org/v1.7/client-server-api/#put_matrixclientv3pushrulesscopekindruleidenabled
+type ReqPushRuleEnabled struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ReqPushRuleActions is the JSON request for https://spec.matrix.org/v1.7/client-server-api/#put_matrixclientv3pushrulesscopekindruleidactions
+type ReqPushRuleActions struct {
+	Actions []interface{} `json:"actions"`
+}
+
+// ReqSetPusher is the JSON request for https://spec.matrix.org/v1.7/client-server-api/#post_matrixclientv3pushersset
+type ReqSetPusher struct {
+	Pusher
+	// If true, the homeserver adds the pusher alongside others with the same pushkey for other users,
+	// instead of replacing them.
+	Append bool `json:"append,omitempty"`
+}
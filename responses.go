@@ -1,10 +1,21 @@
 package gomatrix
 
+import "encoding/json"
+
 // RespError is the standard JSON error response from Homeservers. It also implements the Golang "error" interface.
-// See http://matrix.org/docs/spec/client_server/r0.2.0.html#api-standards
+// See https://spec.matrix.org/v1.1/client-server-api/#standard-error-response
+//
+// Use errors.Is with one of the Err* sentinels to check for a specific errcode.
 type RespError struct {
 	ErrCode string `json:"errcode"`
 	Err     string `json:"error"`
+
+	// The following fields are only present on some errors.
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"` // M_LIMIT_EXCEEDED: how long to wait before retrying.
+	SoftLogout   bool   `json:"soft_logout,omitempty"`    // M_UNKNOWN_TOKEN: the device may log in again without losing its data.
+	AdminContact string `json:"admin_contact,omitempty"`  // M_RESOURCE_LIMIT_EXCEEDED: a URI to contact the server administrator.
+	LimitType    string `json:"limit_type,omitempty"`     // M_RESOURCE_LIMIT_EXCEEDED: the kind of limit which was exceeded.
+	RoomVersion  string `json:"room_version,omitempty"`   // M_INCOMPATIBLE_ROOM_VERSION: the version of the room.
 }
 
 // Error returns the errcode and error message.
@@ -12,12 +23,24 @@ func (e RespError) Error() string {
 	return e.ErrCode + ": " + e.Err
 }
 
+// Is reports whether target is a RespError with the same errcode, so that errors.Is(err, ErrForbidden) matches
+// any M_FORBIDDEN error regardless of its message.
+func (e RespError) Is(target error) bool {
+	switch t := target.(type) {
+	case RespError:
+		return e.ErrCode == t.ErrCode
+	case *RespError:
+		return t != nil && e.ErrCode == t.ErrCode
+	}
+	return false
+}
+
 // RespCreateFilter is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3useruseridfilter
 type RespCreateFilter struct {
 	FilterID string `json:"filter_id"`
 }
 
-// RespPublicRooms is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3directorylistroomroomid
+// RespPublicRooms is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3publicrooms
 type RespPublicRooms struct {
 	Chunk                  []PublicRoom `json:"chunk"`
 	NextBatch              string       `json:"next_batch,omitempty"`
@@ -85,6 +108,11 @@ type RespJoinedMembers struct {
 	} `json:"joined"`
 }
 
+// RespMembers is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidmembers
+type RespMembers struct {
+	Chunk []Event `json:"chunk"`
+}
+
 // RespContext is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidcontexteventid
 type RespContext struct {
 	End          string  `json:"end"`
@@ -108,6 +136,22 @@ type RespSendEvent struct {
 	EventID string `json:"event_id"`
 }
 
+// RespProfile is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3profileuserid
+type RespProfile struct {
+	DisplayName string `json:"displayname,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// RespUserDisplayName is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3profileuseriddisplayname
+type RespUserDisplayName struct {
+	DisplayName string `json:"displayname"`
+}
+
+// RespAvatarURL is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3profileuseridavatar_url
+type RespAvatarURL struct {
+	AvatarURL string `json:"avatar_url"`
+}
+
 // RespMediaUpload is the JSON response for http://matrix.org/docs/spec/client_server/r0.2.0.html#post-matrix-media-r0-upload
 type RespMediaUpload struct {
 	ContentURI string `json:"content_uri"`
@@ -185,18 +229,53 @@ type Join struct {
 	State struct {
 		Events []Event `json:"events"`
 	} `json:"state"`
-	Summary struct {
-		Heros              []string `json:"m.heros,omitempty"`
-		InvitedMemberCount int      `json:"m.invited_member_count,omitempty"`
-		JoinedMemberCount  int      `json:"m.joined_member_count,omitempty"`
-	} `json:"summary"`
-	Timeline            Timeline `json:"timeline"`
+	Summary             RoomSummary `json:"summary"`
+	Timeline            Timeline    `json:"timeline"`
 	UnreadNotifications struct {
 		HighLightCount    int `json:"highlight_count"`
 		NotificationCount int `json:"notification_count"`
 	} `json:"unread_notifications"`
 }
 
+// RoomSummary is the summary of a joined room, used to calculate its display name. The server only includes the
+// fields which changed since the last sync, so absent fields are nil; see Room.UpdateSummary.
+type RoomSummary struct {
+	Heroes             []string `json:"m.heroes,omitempty"`
+	InvitedMemberCount *int     `json:"m.invited_member_count,omitempty"`
+	JoinedMemberCount  *int     `json:"m.joined_member_count,omitempty"`
+}
+
+// The invite object
+type Invite struct {
+	State struct {
+		Events []Event `json:"events"`
+	} `json:"invite_state"`
+}
+
+// The knock object
+type Knock struct {
+	State struct {
+		Events []Event `json:"events"`
+	} `json:"knock_state"`
+}
+
+// The leave object
+type Leave struct {
+	AccountData struct {
+		Events []Event `json:"events"`
+	} `json:"account_data"`
+	State struct {
+		Events []Event `json:"events"`
+	} `json:"state"`
+	Timeline Timeline `json:"timeline"`
+}
+
+// DeviceLists contains the users whose device lists have changed, for end-to-end encryption.
+type DeviceLists struct {
+	Changed []string `json:"changed,omitempty"`
+	Left    []string `json:"left,omitempty"`
+}
+
 // RespSync is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3sync
 type RespSync struct {
 	AccountData struct {
@@ -207,30 +286,17 @@ type RespSync struct {
 		Events []Event `json:"events"`
 	} `json:"presence"`
 	Rooms struct {
-		Invite map[string]struct {
-			State struct {
-				Events []Event `json:"events"`
-			} `json:"invite_state"`
-		} `json:"invite"`
-		Join  map[string]Join `json:"join"`
-		Knock map[string]struct {
-			State struct {
-				Events []Event `json:"events"`
-			} `json:"knock_state"`
-		}
-		Leave map[string]struct {
-			AccountData struct {
-				Events []Event `json:"events"`
-			} `json:"account_data"`
-			State struct {
-				Events []Event `json:"events"`
-			} `json:"state"`
-			Timeline Timeline `json:"timeline"`
-		} `json:"leave"`
+		Invite map[string]Invite `json:"invite"`
+		Join   map[string]Join   `json:"join"`
+		Knock  map[string]Knock  `json:"knock"`
+		Leave  map[string]Leave  `json:"leave"`
 	} `json:"rooms"`
 	ToDevice struct {
 		Events []Event `json:"events"`
 	} `json:"to_device"`
+	DeviceLists                  DeviceLists    `json:"device_lists"`
+	DeviceOneTimeKeysCount       map[string]int `json:"device_one_time_keys_count,omitempty"`
+	DeviceUnusedFallbackKeyTypes []string       `json:"device_unused_fallback_key_types,omitempty"`
 }
 
 type RespSearchUsers struct {
@@ -243,6 +309,10 @@ type RespSearchUsers struct {
 }
 
 // RespPowerLevels is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#mroompower_levels
+//
+// Levels which are absent from the JSON are nil, so that an explicit 0 survives a round trip; see the *Level
+// methods for the levels which apply then and the Can* methods for interpreting them. When decoded from JSON,
+// levels given as strings, which old rooms may contain, are accepted.
 type RespPowerLevels struct {
 	Ban           *int           `json:"ban,omitempty"`
 	Events        map[string]int `json:"events,omitempty"`
@@ -250,11 +320,184 @@ type RespPowerLevels struct {
 	Invite        *int           `json:"invite,omitempty"`
 	Kick          *int           `json:"kick,omitempty"`
 	Notifications struct {
-		Room int `json:"room,omitempty"`
+		Room *int `json:"room,omitempty"`
 	} `json:"notifications"`
 	Redact       *int           `json:"redact,omitempty"`
-	StateDefault int            `json:"state_default,omitempty"`
+	StateDefault *int           `json:"state_default,omitempty"`
 	Users        map[string]int `json:"users"`
 	UsersDefault int            `json:"users_default,omitempty"`
 	Room         int            `json:"room,omitempty"`
 }
+
+// RespSlidingSync is the JSON response for simplified sliding sync, see
+// https://github.com/matrix-org/matrix-spec-proposals/pull/4186
+type RespSlidingSync struct {
+	Pos   string `json:"pos"`
+	TxnID string `json:"txn_id,omitempty"`
+	Lists map[string]struct {
+		Count int `json:"count"`
+	} `json:"lists"`
+	Rooms      map[string]SlidingSyncRoom `json:"rooms"`
+	Extensions struct {
+		ToDevice struct {
+			NextBatch string  `json:"next_batch"`
+			Events    []Event `json:"events"`
+		} `json:"to_device"`
+		E2EE struct {
+			DeviceLists                  DeviceLists    `json:"device_lists"`
+			DeviceOneTimeKeysCount       map[string]int `json:"device_one_time_keys_count,omitempty"`
+			DeviceUnusedFallbackKeyTypes []string       `json:"device_unused_fallback_key_types,omitempty"`
+		} `json:"e2ee"`
+		AccountData struct {
+			Global []Event            `json:"global"`
+			Rooms  map[string][]Event `json:"rooms"`
+		} `json:"account_data"`
+		Typing struct {
+			Rooms map[string]Event `json:"rooms"`
+		} `json:"typing"`
+		Receipts struct {
+			Rooms map[string]Event `json:"rooms"`
+		} `json:"receipts"`
+	} `json:"extensions"`
+}
+
+// SlidingSyncRoom is the data for a single room in a RespSlidingSync.
+type SlidingSyncRoom struct {
+	Name              string            `json:"name,omitempty"`
+	AvatarURL         string            `json:"avatar,omitempty"`
+	Heroes            []SlidingSyncHero `json:"heroes,omitempty"`
+	Initial           bool              `json:"initial,omitempty"`
+	IsDM              bool              `json:"is_dm,omitempty"`
+	InviteState       []Event           `json:"invite_state,omitempty"`
+	RequiredState     []Event           `json:"required_state,omitempty"`
+	Timeline          []Event           `json:"timeline,omitempty"`
+	PrevBatch         string            `json:"prev_batch,omitempty"`
+	Limited           bool              `json:"limited,omitempty"`
+	ExpandedTimeline  bool              `json:"expanded_timeline,omitempty"`
+	JoinedCount       *int              `json:"joined_count,omitempty"`  // Only present if it changed.
+	InvitedCount      *int              `json:"invited_count,omitempty"` // Only present if it changed.
+	NotificationCount int               `json:"notification_count,omitempty"`
+	HighlightCount    int               `json:"highlight_count,omitempty"`
+	NumLive           int               `json:"num_live,omitempty"`
+	BumpStamp         int64             `json:"bump_stamp,omitempty"`
+}
+
+// SlidingSyncHero is a member of a room without a name, used to compute the room's display name.
+type SlidingSyncHero struct {
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"displayname,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// DeviceKeys are the identity keys of a device - https://spec.matrix.org/v1.1/client-server-api/#_matrixclientv3keysupload_devicekeys
+type DeviceKeys struct {
+	UserID     string                       `json:"user_id"`
+	DeviceID   string                       `json:"device_id"`
+	Algorithms []string                     `json:"algorithms"`
+	Keys       map[string]string            `json:"keys"` // Keyed by "<algorithm>:<device ID>".
+	Signatures map[string]map[string]string `json:"signatures,omitempty"`
+	Unsigned   map[string]interface{}       `json:"unsigned,omitempty"`
+
+	Raw json.RawMessage `json:"-"` // The original JSON of the keys, if they were decoded from JSON, for verifying the signatures.
+}
+
+// UnmarshalJSON decodes the keys and keeps a copy of the original JSON in Raw.
+func (keys *DeviceKeys) UnmarshalJSON(data []byte) error {
+	type rawDeviceKeys DeviceKeys
+	if err := json.Unmarshal(data, (*rawDeviceKeys)(keys)); err != nil {
+		return err
+	}
+	keys.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// OneTimeKey is a signed one-time key - https://spec.matrix.org/v1.1/client-server-api/#key-algorithms
+type OneTimeKey struct {
+	Key        string                       `json:"key"`
+	Fallback   bool                         `json:"fallback,omitempty"`
+	Signatures map[string]map[string]string `json:"signatures,omitempty"`
+
+	Raw json.RawMessage `json:"-"` // The original JSON of the key, if it was decoded from JSON, for verifying the signatures.
+}
+
+// UnmarshalJSON decodes the key and keeps a copy of the original JSON in Raw.
+func (key *OneTimeKey) UnmarshalJSON(data []byte) error {
+	type rawOneTimeKey OneTimeKey
+	if err := json.Unmarshal(data, (*rawOneTimeKey)(key)); err != nil {
+		return err
+	}
+	key.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// RespUploadKeys is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysupload
+type RespUploadKeys struct {
+	OneTimeKeyCounts map[string]int `json:"one_time_key_counts"`
+}
+
+// RespQueryKeys is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysquery
+type RespQueryKeys struct {
+	DeviceKeys map[string]map[string]DeviceKeys `json:"device_keys"`
+	Failures   map[string]interface{}           `json:"failures,omitempty"`
+}
+
+// RespClaimKeys is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#post_matrixclientv3keysclaim
+type RespClaimKeys struct {
+	OneTimeKeys map[string]map[string]map[string]OneTimeKey `json:"one_time_keys"`
+	Failures    map[string]interface{}                      `json:"failures,omitempty"`
+}
+
+// RespSendToDevice is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#put_matrixclientv3sendtodeviceeventtypetxnid
+type RespSendToDevice struct{}
+
+// RespDevice is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3devicesdeviceid
+type RespDevice struct {
+	DeviceID    string `json:"device_id"`
+	DisplayName string `json:"display_name,omitempty"`
+	LastSeenIP  string `json:"last_seen_ip,omitempty"`
+	LastSeenTS  int64  `json:"last_seen_ts,omitempty"`
+}
+
+// RespDevices is the JSON response for https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3devices
+type RespDevices struct {
+	Devices []RespDevice `json:"devices"`
+}
+
+// RespPushRules is the JSON response for https://spec.matrix.org/v1.7/client-server-api/#get_matrixclientv3pushrules
+type RespPushRules struct {
+	Global PushRuleset `json:"global"`
+}
+
+// RespPushers is the JSON response for https://spec.matrix.org/v1.7/client-server-api/#get_matrixclientv3pushers
+type RespPushers struct {
+	Pushers []Pusher `json:"pushers"`
+}
+
+// Pusher kinds - https://spec.matrix.org/v1.7/client-server-api/#post_matrixclientv3pushersset
+const (
+	PusherKindHTTP  = "http"
+	PusherKindEmail = "email"
+)
+
+// Pusher is a pusher registered with the homeserver - https://spec.matrix.org/v1.7/client-server-api/#get_matrixclientv3pushers
+type Pusher struct {
+	AppDisplayName    string     `json:"app_display_name"`
+	AppID             string     `json:"app_id"`
+	Data              PusherData `json:"data"`
+	DeviceDisplayName string     `json:"device_display_name"`
+	Kind              string     `json:"kind"`
+	Lang              string     `json:"lang"`
+	ProfileTag        string     `json:"profile_tag,omitempty"`
+	PushKey           string     `json:"pushkey"`
+}
+
+// PusherData holds the pusher's configuration. For http pushers, URL is the push gateway's notify endpoint.
+type PusherData struct {
+	Format string `json:"format,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+// RespMediaConfig is the JSON response for https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediaconfig
+type RespMediaConfig struct {
+	UploadSize int64 `json:"m.upload.size,omitempty"` // The maximum upload size in bytes, or 0 if unknown.
+}
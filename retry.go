@@ -0,0 +1,159 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether a request which failed in Client.MakeRequest should be attempted again.
+type RetryPolicy interface {
+	// ShouldRetry is called after every failed attempt of the request with the given method and URL. attempt is
+	// the number of attempts made so far, starting at 1. res is the HTTP response, or nil if the request failed
+	// before a response was received (e.g. a network error). err is the error the attempt failed with, which is an
+	// HTTPError for non-2xx responses.
+	//
+	// Returns how long to wait before the next attempt, and false if the request should not be retried.
+	ShouldRetry(method, httpURL string, attempt int, res *http.Response, err error) (time.Duration, bool)
+}
+
+// DefaultRetryPolicy implements RetryPolicy with capped exponential backoff and jitter.
+//
+// Rate-limited responses wait for as long as the server asks via Retry-After or retry_after_ms, see HandleRetry. If
+// that is longer than MaxDelay, the request is not retried and the rate limit error is returned to the caller.
+// Other failures wait BaseDelay * 2^(attempt-1), capped at MaxDelay, of which a random half is used as jitter.
+//
+// A network error or server error does not tell whether the server acted on the request, so by default these are
+// only retried for requests which can safely be repeated: GET and HEAD requests, and PUT requests to endpoints
+// keyed by a transaction ID, which the server deduplicates. Repeating any other request, e.g. a POST to
+// /createRoom, could perform it twice. Rate-limited requests were refused by the server and are always retried.
+type DefaultRetryPolicy struct {
+	MaxAttempts        int             // The maximum number of attempts, including the first. Values below 2 disable retries.
+	BaseDelay          time.Duration   // The delay before the first retry.
+	MaxDelay           time.Duration   // The maximum delay between two attempts.
+	StatusCodes        map[int]bool    // HTTP status codes which should be retried.
+	ErrCodes           map[string]bool // Matrix errcodes which should be retried, whatever their status code.
+	RetryNetworkErrors bool            // Whether to retry requests which failed without a response.
+	RetryUnsafe        bool            // Whether to retry network and server errors for requests which are not safe to repeat.
+}
+
+// NewDefaultRetryPolicy returns a DefaultRetryPolicy which makes up to 5 attempts, retrying network errors and
+// 5xx gateway/availability errors of requests which are safe to repeat, as well as 429 and M_LIMIT_EXCEEDED
+// errors of any request, regardless of their status code.
+func NewDefaultRetryPolicy() *DefaultRetryPolicy {
+	return &DefaultRetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+		StatusCodes: map[int]bool{
+			http.StatusTooManyRequests:     true,
+			http.StatusInternalServerError: true,
+			http.StatusBadGateway:          true,
+			http.StatusServiceUnavailable:  true,
+			http.StatusGatewayTimeout:      true,
+		},
+		ErrCodes: map[string]bool{
+			ErrLimitExceeded.ErrCode: true,
+		},
+		RetryNetworkErrors: true,
+	}
+}
+
+// ShouldRetry implements RetryPolicy.
+func (p *DefaultRetryPolicy) ShouldRetry(method, httpURL string, attempt int, res *http.Response, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	backoff := p.backoff(attempt)
+	repeatable := p.RetryUnsafe || isRepeatable(method, httpURL)
+
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) {
+		// Anything other than a network error (e.g. a malformed 2xx body) is not going to get better.
+		return backoff, res == nil && p.RetryNetworkErrors && repeatable
+	}
+
+	retry := p.StatusCodes[httpErr.Code]
+	rateLimited := httpErr.Code == http.StatusTooManyRequests
+	var respErr RespError
+	if errors.As(err, &respErr) {
+		retry = retry || p.ErrCodes[respErr.ErrCode]
+		rateLimited = rateLimited || respErr.ErrCode == ErrLimitExceeded.ErrCode
+	}
+	if !retry || !(rateLimited || repeatable) {
+		return 0, false
+	}
+	if rateLimited {
+		if wait, err := HandleRetry(res, httpErr.Contents, backoff); err == nil {
+			if wait > p.MaxDelay {
+				return 0, false
+			}
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, true
+		}
+	}
+	return backoff, true
+}
+
+// txnPath matches the paths of endpoints keyed by a transaction ID.
+var txnPath = regexp.MustCompile(`/rooms/[^/]+/(send|redact)/[^/]+/[^/]+$|/sendToDevice/[^/]+/[^/]+$`)
+
+// isRepeatable reports whether a request can be sent again without risking performing it twice.
+func isRepeatable(method, httpURL string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPut:
+		u, err := url.Parse(httpURL)
+		return err == nil && txnPath.MatchString(u.EscapedPath())
+	}
+	return false
+}
+
+func (p *DefaultRetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// HandleRetry returns how long the server asked the client to wait before retrying a rate-limited request.
+// The Retry-After header of res takes precedence over the retry_after_ms field of the JSON error body. If neither
+// is present, duration is returned.
+func HandleRetry(res *http.Response, body []byte, duration time.Duration) (time.Duration, error) {
+	ra := ""
+	if res != nil {
+		ra = res.Header.Get("Retry-After")
+	}
+	if ra == "" {
+		var respErr RespError
+		if err := json.Unmarshal(body, &respErr); err == nil && respErr.RetryAfterMs > 0 {
+			return time.Duration(respErr.RetryAfterMs) * time.Millisecond, nil
+		}
+		return duration, nil
+	}
+
+	if t, err := time.Parse(http.TimeFormat, ra); err == nil {
+		return time.Until(t), nil
+	}
+
+	if seconds, err := strconv.Atoi(ra); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return duration, errors.New("invalid retry-after data")
+}
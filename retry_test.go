@@ -0,0 +1,161 @@
+package gomatrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func fastRetryPolicy() *DefaultRetryPolicy {
+	p := NewDefaultRetryPolicy()
+	p.BaseDelay = time.Millisecond
+	p.MaxDelay = time.Millisecond
+	return p
+}
+
+func TestMakeRequestRetriesServerErrors(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"joined_rooms":["!a:example.com"]}`))
+	}))
+	cli.RetryPolicy = fastRetryPolicy()
+
+	resp, err := cli.JoinedRooms(context.Background())
+	if err != nil {
+		t.Fatalf("TestMakeRequestRetriesServerErrors => Got error: %s", err)
+	}
+	if attempts != 3 || len(resp.JoinedRooms) != 1 {
+		t.Fatalf("TestMakeRequestRetriesServerErrors => Got: %d attempts, %v Expected: 3 attempts, one room", attempts, resp.JoinedRooms)
+	}
+}
+
+func TestMakeRequestRateLimited(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":1}`))
+			return
+		}
+		w.Write([]byte(`{"event_id":"$1"}`))
+	}))
+	cli.RetryPolicy = fastRetryPolicy()
+
+	resp, err := cli.SendText(context.Background(), "!a:example.com", "hello")
+	if err != nil {
+		t.Fatalf("TestMakeRequestRateLimited => Got error: %s", err)
+	}
+	if resp.EventID != "$1" {
+		t.Fatalf("TestMakeRequestRateLimited => Got: %q Expected: %q", resp.EventID, "$1")
+	}
+}
+
+func TestMakeRequestMaxAttempts(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	cli.RetryPolicy = fastRetryPolicy()
+
+	_, err := cli.JoinedRooms(context.Background())
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("TestMakeRequestMaxAttempts => Got: %v Expected: an HTTPError with code 503", err)
+	}
+	if attempts != 5 {
+		t.Fatalf("TestMakeRequestMaxAttempts => Got: %d attempts Expected: 5", attempts)
+	}
+}
+
+func TestMakeRequestNoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errcode":"M_FORBIDDEN","error":"nope"}`))
+	}))
+	cli.RetryPolicy = fastRetryPolicy()
+
+	if _, err := cli.JoinedRooms(context.Background()); err == nil || attempts != 1 {
+		t.Fatalf("TestMakeRequestNoRetryOnClientError => Got: %d attempts, %v Expected: 1 attempt and an error", attempts, err)
+	}
+}
+
+func TestMakeRequestNoRetryOnUnsafeRequest(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":1}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	cli.RetryPolicy = fastRetryPolicy()
+
+	// The rate-limited attempt is retried, but the 502 may have created the room and is not.
+	_, err := cli.CreateRoom(context.Background(), &ReqCreateRoom{})
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadGateway || attempts != 2 {
+		t.Fatalf("TestMakeRequestNoRetryOnUnsafeRequest => Got: %d attempts, %v Expected: 2 attempts and a 502", attempts, err)
+	}
+}
+
+func TestMakeRequestRetryAfterTooLong(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests"}`))
+	}))
+	cli.RetryPolicy = fastRetryPolicy()
+
+	if _, err := cli.JoinedRooms(context.Background()); !errors.Is(err, ErrLimitExceeded) || attempts != 1 {
+		t.Fatalf("TestMakeRequestRetryAfterTooLong => Got: %d attempts, %v Expected: 1 attempt and M_LIMIT_EXCEEDED", attempts, err)
+	}
+}
+
+func TestIsRepeatable(t *testing.T) {
+	for _, tc := range []struct {
+		method, url string
+		expected    bool
+	}{
+		{"GET", "https://example.com/_matrix/client/v3/sync", true},
+		{"PUT", "https://example.com/_matrix/client/v3/rooms/%21a:example.com/send/m.room.message/txn1", true},
+		{"PUT", "https://example.com/_matrix/client/v3/rooms/%21a:example.com/redact/$ev/txn1", true},
+		{"PUT", "https://example.com/_matrix/client/v3/sendToDevice/m.room_key_request/txn1", true},
+		{"PUT", "https://example.com/_matrix/client/v3/rooms/%21a:example.com/state/m.room.name/", false},
+		{"POST", "https://example.com/_matrix/client/v3/createRoom", false},
+		{"POST", "https://example.com/_matrix/client/v3/login", false},
+	} {
+		if got := isRepeatable(tc.method, tc.url); got != tc.expected {
+			t.Fatalf("TestIsRepeatable => %s %s Got: %v Expected: %v", tc.method, tc.url, got, tc.expected)
+		}
+	}
+}
+
+func TestHandleRetry(t *testing.T) {
+	res := &http.Response{Header: http.Header{}}
+	if d, _ := HandleRetry(res, []byte(`{"retry_after_ms":1500}`), time.Second); d != 1500*time.Millisecond {
+		t.Fatalf("TestHandleRetry(retry_after_ms) => Got: %s Expected: %s", d, 1500*time.Millisecond)
+	}
+	res.Header.Set("Retry-After", "3")
+	if d, _ := HandleRetry(res, []byte(`{"retry_after_ms":1500}`), time.Second); d != 3*time.Second {
+		t.Fatalf("TestHandleRetry(Retry-After) => Got: %s Expected: %s", d, 3*time.Second)
+	}
+	res.Header.Set("Retry-After", "soon")
+	if _, err := HandleRetry(res, nil, time.Second); err == nil {
+		t.Fatal("TestHandleRetry(invalid) => Got: nil error Expected: an error")
+	}
+}
@@ -1,9 +1,24 @@
 package gomatrix
 
+import (
+	"encoding/json"
+	"sync"
+)
+
 // Room represents a single Matrix room.
+//
+// It is safe to read and update the room's state from multiple goroutines. Events stored in the state are
+// shared between readers and must not be modified; UpdateState replaces them instead.
 type Room struct {
-	ID    string
-	State map[string]map[string]*Event
+	ID string
+
+	mu            sync.RWMutex
+	state         map[string]map[string]*Event
+	membersLoaded bool // Whether the full member list has been loaded with Client.LoadMembers.
+
+	// The room summary from sync, see UpdateSummary. Nil if the server has not sent it.
+	heroes                    []string
+	joinedCount, invitedCount *int
 }
 
 // PublicRoom represents the information about a public room obtainable from the room directory
@@ -22,24 +37,89 @@ type PublicRoom struct {
 
 // UpdateState updates the room's current state with the given Event. This will clobber events based
 // on the type/state_key combination.
-func (room Room) UpdateState(event *Event) {
-	_, exists := room.State[event.Type]
+func (room *Room) UpdateState(event *Event) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	_, exists := room.state[event.Type]
 	if !exists {
-		room.State[event.Type] = make(map[string]*Event)
+		room.state[event.Type] = make(map[string]*Event)
 	}
-	room.State[event.Type][*event.StateKey] = event
+	room.state[event.Type][*event.StateKey] = event
 }
 
 // GetStateEvent returns the state event for the given type/state_key combo, or nil.
-func (room Room) GetStateEvent(eventType string, stateKey string) *Event {
-	stateEventMap := room.State[eventType]
+func (room *Room) GetStateEvent(eventType string, stateKey string) *Event {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	stateEventMap := room.state[eventType]
 	event := stateEventMap[stateKey]
 	return event
 }
 
+// GetStateEvents returns a snapshot of the state events of the given type, keyed by state key.
+// The returned map is a copy and may be used freely; it is never nil.
+func (room *Room) GetStateEvents(eventType string) map[string]*Event {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	events := make(map[string]*Event, len(room.state[eventType]))
+	for stateKey, event := range room.state[eventType] {
+		events[stateKey] = event
+	}
+	return events
+}
+
+// State returns a snapshot of the room's current state, keyed by event type and then state key.
+// The returned maps are copies and may be used freely.
+func (room *Room) State() map[string]map[string]*Event {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	return copyState(room.state)
+}
+
+// Version returns the room version from the m.room.create event, or "1" if it is unknown.
+func (room *Room) Version() string {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	return room.version()
+}
+
+func (room *Room) version() string {
+	if create := room.state["m.room.create"][""]; create != nil {
+		if version, ok := create.Content["room_version"].(string); ok && version != "" {
+			return version
+		}
+	}
+	return "1"
+}
+
+// ApplyRedaction replaces the state event redacted by the m.room.redaction event, if the room has it, with a
+// redacted copy. The copy's unsigned data holds the redaction event under "redacted_because", as it would if the
+// server had sent the event after it was redacted. Returns true if a state event was redacted.
+func (room *Room) ApplyRedaction(redaction *Event) bool {
+	eventID := redaction.RedactedEventID()
+	if eventID == "" {
+		return false
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	for _, events := range room.state {
+		for stateKey, event := range events {
+			if event.ID != eventID {
+				continue
+			}
+			because := *redaction
+			redacted := Redact(event, room.version())
+			redacted.Unsigned = map[string]interface{}{"redacted_because": &because}
+			events[stateKey] = redacted
+			return true
+		}
+	}
+	return false
+}
+
 // GetMembershipState returns the membership state of the given user ID in this room. If there is
 // no entry for this member, 'leave' is returned for consistency with left users.
-func (room Room) GetMembershipState(userID string) string {
+func (room *Room) GetMembershipState(userID string) string {
 	state := "leave"
 	event := room.GetStateEvent("m.room.member", userID)
 	if event != nil {
@@ -54,11 +134,67 @@ func (room Room) GetMembershipState(userID string) string {
 	return state
 }
 
+// roomJSON is the JSON representation of a Room, used by stores which persist rooms.
+type roomJSON struct {
+	ID            string
+	State         map[string]map[string]*Event
+	MembersLoaded bool `json:",omitempty"`
+
+	Heroes             []string `json:",omitempty"`
+	JoinedMemberCount  *int     `json:",omitempty"`
+	InvitedMemberCount *int     `json:",omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (room *Room) MarshalJSON() ([]byte, error) {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	return json.Marshal(roomJSON{
+		ID:                 room.ID,
+		State:              room.state,
+		MembersLoaded:      room.membersLoaded,
+		Heroes:             room.heroes,
+		JoinedMemberCount:  room.joinedCount,
+		InvitedMemberCount: room.invitedCount,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (room *Room) UnmarshalJSON(data []byte) error {
+	var r roomJSON
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.State == nil {
+		r.State = make(map[string]map[string]*Event)
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.ID = r.ID
+	room.state = r.State
+	room.membersLoaded = r.MembersLoaded
+	room.heroes = r.Heroes
+	room.joinedCount = r.JoinedMemberCount
+	room.invitedCount = r.InvitedMemberCount
+	return nil
+}
+
+func copyState(state map[string]map[string]*Event) map[string]map[string]*Event {
+	c := make(map[string]map[string]*Event, len(state))
+	for eventType, events := range state {
+		c[eventType] = make(map[string]*Event, len(events))
+		for stateKey, event := range events {
+			c[eventType][stateKey] = event
+		}
+	}
+	return c
+}
+
 // NewRoom creates a new Room with the given ID
 func NewRoom(roomID string) *Room {
 	// Init the State map and return a pointer to the Room
 	return &Room{
 		ID:    roomID,
-		State: make(map[string]map[string]*Event),
+		state: make(map[string]map[string]*Event),
 	}
 }
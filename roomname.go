@@ -0,0 +1,118 @@
+package gomatrix
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxHeroes is the number of other members named in a room's display name when the server sends no heroes.
+const maxHeroes = 5
+
+// UpdateSummary merges a room summary from sync into the room. Fields which the summary omits keep their previous
+// values, as the server only sends the ones which changed.
+func (room *Room) UpdateSummary(summary RoomSummary) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if summary.Heroes != nil {
+		room.heroes = summary.Heroes
+	}
+	if summary.JoinedMemberCount != nil {
+		joined := *summary.JoinedMemberCount
+		room.joinedCount = &joined
+	}
+	if summary.InvitedMemberCount != nil {
+		invited := *summary.InvitedMemberCount
+		room.invitedCount = &invited
+	}
+}
+
+// DisplayName returns the name of the room to show to the user with the given ID: the room's m.room.name, or
+// else its canonical alias, or else a name made from the names of the other members, such as "Alice and Bob",
+// "Alice, Bob and 3 others" or "Empty Room (was Alice)".
+//
+// Members are taken from the heroes of the room summary if sync has sent one, and from the room's state otherwise.
+// See https://spec.matrix.org/v1.7/client-server-api/#calculating-the-display-name-for-a-room
+func (room *Room) DisplayName(userID string) string {
+	if event := room.GetStateEvent("m.room.name", ""); event != nil {
+		if name, _ := event.Content["name"].(string); name != "" {
+			return name
+		}
+	}
+	if event := room.GetStateEvent("m.room.canonical_alias", ""); event != nil {
+		if alias, _ := event.Content["alias"].(string); alias != "" {
+			return alias
+		}
+	}
+
+	heroes, memberCount := room.heroesAndMemberCount(userID)
+	names := make([]string, len(heroes))
+	for i, hero := range heroes {
+		names[i] = room.MemberDisplayName(hero)
+	}
+	switch {
+	case len(names) == 0:
+		return "Empty Room"
+	case memberCount <= 1:
+		return "Empty Room (was " + joinNames(names) + ")"
+	}
+	if others := memberCount - 1 - len(names); others > 0 {
+		if others == 1 {
+			return strings.Join(names, ", ") + " and 1 other"
+		}
+		return strings.Join(names, ", ") + " and " + strconv.Itoa(others) + " others"
+	}
+	return joinNames(names)
+}
+
+// heroesAndMemberCount returns the users to name the room after and the number of joined and invited members,
+// preferring the room summary to the room's state.
+func (room *Room) heroesAndMemberCount(userID string) ([]string, int) {
+	room.mu.RLock()
+	heroes := room.heroes
+	joined, invited := room.joinedCount, room.invitedCount
+	room.mu.RUnlock()
+
+	var current, former []string
+	count := 0
+	for _, member := range room.Members() {
+		switch member.Membership {
+		case "join", "invite":
+			count++
+			if member.UserID != userID {
+				current = append(current, member.UserID)
+			}
+		case "leave", "ban":
+			if member.UserID != userID {
+				former = append(former, member.UserID)
+			}
+		}
+	}
+	if joined != nil || invited != nil {
+		count = 0
+		if joined != nil {
+			count += *joined
+		}
+		if invited != nil {
+			count += *invited
+		}
+	}
+	if heroes == nil {
+		// Members who left are only named if nobody else is left.
+		heroes = current
+		if len(heroes) == 0 {
+			heroes = former
+		}
+		if len(heroes) > maxHeroes {
+			heroes = heroes[:maxHeroes]
+		}
+	}
+	return heroes, count
+}
+
+// joinNames joins names like "Alice", "Alice and Bob" or "Alice, Bob and Carol".
+func joinNames(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,81 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRoomDisplayName(t *testing.T) {
+	room := NewRoom("!room:example.com")
+	setState := func(raw string) {
+		var event Event
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			t.Fatalf("TestRoomDisplayName => Failed to unmarshal: %s", err)
+		}
+		room.UpdateState(&event)
+	}
+	summary := func(raw string) RoomSummary {
+		var s RoomSummary
+		if err := json.Unmarshal([]byte(raw), &s); err != nil {
+			t.Fatalf("TestRoomDisplayName => Failed to unmarshal: %s", err)
+		}
+		return s
+	}
+	me := "@me:example.com"
+
+	if got := room.DisplayName(me); got != "Empty Room" {
+		t.Fatalf("TestRoomDisplayName => Got: %q Expected: Empty Room", got)
+	}
+
+	// Without a summary, the names come from the room's state.
+	setState(`{"type":"m.room.member","state_key":"@me:example.com","content":{"membership":"join","displayname":"Me"}}`)
+	setState(`{"type":"m.room.member","state_key":"@alice:example.com","content":{"membership":"leave","displayname":"Alice"}}`)
+	if got := room.DisplayName(me); got != "Empty Room (was Alice)" {
+		t.Fatalf("TestRoomDisplayName => Got: %q Expected: Empty Room (was Alice)", got)
+	}
+	setState(`{"type":"m.room.member","state_key":"@bob:example.com","content":{"membership":"join","displayname":"Bob"}}`)
+	setState(`{"type":"m.room.member","state_key":"@carol:example.com","content":{"membership":"invite"}}`)
+	if got := room.DisplayName(me); got != "Bob and @carol:example.com" {
+		t.Fatalf("TestRoomDisplayName => Got: %q Expected: Bob and @carol:example.com", got)
+	}
+
+	// The summary's heroes and counts take precedence, and later summaries only update what they include.
+	room.UpdateSummary(summary(`{"m.heroes":["@bob:example.com","@alice:example.com"],"m.joined_member_count":10,"m.invited_member_count":1}`))
+	room.UpdateSummary(summary(`{"m.invited_member_count":0}`))
+	if got := room.DisplayName(me); got != "Bob, Alice and 7 others" {
+		t.Fatalf("TestRoomDisplayName => Got: %q Expected: Bob, Alice and 7 others", got)
+	}
+
+	setState(`{"type":"m.room.canonical_alias","state_key":"","content":{"alias":"#room:example.com"}}`)
+	if got := room.DisplayName(me); got != "#room:example.com" {
+		t.Fatalf("TestRoomDisplayName => Got: %q Expected: #room:example.com", got)
+	}
+	setState(`{"type":"m.room.name","state_key":"","content":{"name":"The Room"}}`)
+	if got := room.DisplayName(me); got != "The Room" {
+		t.Fatalf("TestRoomDisplayName => Got: %q Expected: The Room", got)
+	}
+}
+
+func TestRoomDisplayNameFromSlidingSync(t *testing.T) {
+	var res RespSlidingSync
+	err := json.Unmarshal([]byte(`{"pos":"5","rooms":{"!room:example.com":{
+		"heroes":[{"user_id":"@bob:example.com"}],
+		"joined_count":5,
+		"invited_count":1,
+		"required_state":[
+			{"type":"m.room.member","state_key":"@bob:example.com","content":{"membership":"join","displayname":"Bob"}}
+		]
+	}}}`), &res)
+	if err != nil {
+		t.Fatalf("TestRoomDisplayNameFromSlidingSync => Failed to unmarshal: %s", err)
+	}
+	store := NewInMemoryStore()
+	syncer := NewDefaultSyncer("@me:example.com", store)
+	if err = syncer.ProcessResponse(res.toRespSync("@me:example.com"), "4"); err != nil {
+		t.Fatalf("TestRoomDisplayNameFromSlidingSync => ProcessResponse: %s", err)
+	}
+	// The counts come from the server, not from the lazy-loaded members.
+	if got := store.LoadRoom("!room:example.com").DisplayName("@me:example.com"); got != "Bob and 4 others" {
+		t.Fatalf("TestRoomDisplayNameFromSlidingSync => Got: %q Expected: Bob and 4 others", got)
+	}
+}
@@ -0,0 +1,305 @@
+package gomatrix
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// SlidingSync syncs with the homeserver using simplified sliding sync (MSC4186) instead of /sync. Only the rooms in
+// the configured lists and room subscriptions are returned, which keeps the initial sync of large accounts small.
+//
+// Responses are converted into a RespSync and passed to Client.Syncer, so listeners registered on the DefaultSyncer
+// and rooms in the Client.Store are fed the same way as with Client.Sync.
+//
+// Lists and room subscriptions may be changed from any goroutine while Sync is running; the changes take effect
+// from the next request.
+type SlidingSync struct {
+	Client     *Client
+	ConnID     string                // Identifies this connection if the client runs several at once. Optional.
+	Timeout    int                   // The long-poll timeout in milliseconds. Defaults to 30000.
+	Extensions SlidingSyncExtensions // The extensions to enable. Must not be modified while syncing.
+
+	mu            sync.Mutex
+	lists         map[string]SlidingSyncList
+	subscriptions map[string]SlidingSyncRoomSubscription
+	counts        map[string]int
+	pos           string
+	toDeviceSince string
+}
+
+// NewSlidingSync returns a SlidingSync for the given client, with the to-device, e2ee, account data, typing and
+// receipts extensions enabled.
+func NewSlidingSync(cli *Client) *SlidingSync {
+	return &SlidingSync{
+		Client:  cli,
+		Timeout: 30000,
+		Extensions: SlidingSyncExtensions{
+			ToDevice:    &SlidingSyncToDeviceExtension{Enabled: true},
+			E2EE:        &SlidingSyncExtension{Enabled: true},
+			AccountData: &SlidingSyncRoomsExtension{Enabled: true},
+			Typing:      &SlidingSyncRoomsExtension{Enabled: true},
+			Receipts:    &SlidingSyncRoomsExtension{Enabled: true},
+		},
+		lists:         make(map[string]SlidingSyncList),
+		subscriptions: make(map[string]SlidingSyncRoomSubscription),
+		counts:        make(map[string]int),
+	}
+}
+
+// SetList adds the list with the given name, or replaces it if it already exists.
+func (ss *SlidingSync) SetList(name string, list SlidingSyncList) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.lists[name] = list
+}
+
+// SetListRanges changes the ranges of an existing list, e.g. to page through it. Returns false if there is no list
+// with the given name.
+func (ss *SlidingSync) SetListRanges(name string, ranges ...[2]int) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	list, ok := ss.lists[name]
+	if !ok {
+		return false
+	}
+	list.Ranges = ranges
+	ss.lists[name] = list
+	return true
+}
+
+// RemoveList removes the list with the given name.
+func (ss *SlidingSync) RemoveList(name string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.lists, name)
+	delete(ss.counts, name)
+}
+
+// ListCount returns the total number of rooms matching the list's filters, as of the last response.
+func (ss *SlidingSync) ListCount(name string) int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.counts[name]
+}
+
+// Subscribe subscribes to the given room, regardless of whether it is in any list.
+func (ss *SlidingSync) Subscribe(roomID string, sub SlidingSyncRoomSubscription) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.subscriptions[roomID] = sub
+}
+
+// Unsubscribe removes the subscription to the given room.
+func (ss *SlidingSync) Unsubscribe(roomID string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.subscriptions, roomID)
+}
+
+// Pos returns the position token of the last response, or "" before the first response.
+func (ss *SlidingSync) Pos() string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.pos
+}
+
+// Sync starts sliding syncing with the homeserver. It behaves like Client.Sync: it blocks until a fatal error
+// occurs, ctx is done, or Client.StopSync (or another Sync) is called.
+//
+// If the server has expired the connection, the position is reset and the lists are synced from scratch.
+//
+// If Client.Store implements SlidingSyncStorer, the position and the to-device token are saved there after every
+// processed response, and the first Sync resumes from them.
+func (ss *SlidingSync) Sync(ctx context.Context) error {
+	cli := ss.Client
+	syncingID := cli.incrementSyncingID()
+	store, _ := cli.Store.(SlidingSyncStorer)
+	if store != nil {
+		ss.mu.Lock()
+		if ss.pos == "" && ss.toDeviceSince == "" {
+			saved := store.LoadSlidingSyncPos(cli.UserID, ss.ConnID)
+			ss.pos, ss.toDeviceSince = saved.Pos, saved.ToDeviceSince
+		}
+		ss.mu.Unlock()
+	}
+
+	for {
+		req, pos := ss.buildRequest()
+		timeout := ss.Timeout
+		if pos == "" {
+			// Return the initial state of the lists right away.
+			timeout = 0
+		}
+		resSync, err := cli.SlidingSyncRequest(ctx, pos, timeout, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, ErrUnknownPos) {
+				ss.resetPos()
+				continue
+			}
+			duration, err2 := cli.Syncer.OnFailedSync(nil, err)
+			if err2 != nil {
+				return err2
+			}
+			if err2 = sleepContext(ctx, duration); err2 != nil {
+				return err2
+			}
+			continue
+		}
+
+		// Check that the syncing state hasn't changed
+		// Either because we've stopped syncing or another sync has been started.
+		// We discard the response from our sync.
+		if cli.getSyncingID() != syncingID {
+			return nil
+		}
+
+		saved := ss.update(resSync)
+		if err = processResponse(ctx, cli.Syncer, resSync.toRespSync(cli.UserID), pos); err != nil {
+			return err
+		}
+		if store != nil {
+			store.SaveSlidingSyncPos(cli.UserID, ss.ConnID, saved)
+		}
+	}
+}
+
+func (ss *SlidingSync) buildRequest() (*ReqSlidingSync, string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	req := &ReqSlidingSync{
+		ConnID:            ss.ConnID,
+		Lists:             make(map[string]SlidingSyncList, len(ss.lists)),
+		RoomSubscriptions: make(map[string]SlidingSyncRoomSubscription, len(ss.subscriptions)),
+		Extensions:        ss.Extensions,
+	}
+	for name, list := range ss.lists {
+		req.Lists[name] = list
+	}
+	for roomID, sub := range ss.subscriptions {
+		req.RoomSubscriptions[roomID] = sub
+	}
+	if ss.Extensions.ToDevice != nil {
+		toDevice := *ss.Extensions.ToDevice
+		toDevice.Since = ss.toDeviceSince
+		req.Extensions.ToDevice = &toDevice
+	}
+	return req, ss.pos
+}
+
+// update applies the response to the connection and returns the position to save once it has been processed.
+func (ss *SlidingSync) update(res *RespSlidingSync) SlidingSyncPos {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.pos = res.Pos
+	if res.Extensions.ToDevice.NextBatch != "" {
+		ss.toDeviceSince = res.Extensions.ToDevice.NextBatch
+	}
+	for name, list := range res.Lists {
+		ss.counts[name] = list.Count
+	}
+	return SlidingSyncPos{Pos: ss.pos, ToDeviceSince: ss.toDeviceSince}
+}
+
+func (ss *SlidingSync) resetPos() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.pos = ""
+}
+
+// SlidingSyncRequest makes an HTTP request for simplified sliding sync, see
+// https://github.com/matrix-org/matrix-spec-proposals/pull/4186
+func (cli *Client) SlidingSyncRequest(ctx context.Context, pos string, timeout int, req *ReqSlidingSync) (resp *RespSlidingSync, err error) {
+	query := map[string]string{
+		"timeout": strconv.Itoa(timeout),
+	}
+	if pos != "" {
+		query["pos"] = pos
+	}
+	urlPath := cli.BuildBaseURLWithQuery([]string{"_matrix/client/unstable/org.matrix.simplified_msc3575/sync"}, query)
+	err = cli.MakeRequest(ctx, "POST", urlPath, req, &resp)
+	return
+}
+
+// toRespSync converts the response into the equivalent /sync response so it can be processed by a Syncer.
+// Rooms are sorted into join, invite, knock and leave based on the membership event of userID. Of the timeline of
+// rooms marked as initial, only the live events are kept, so that listeners are not passed old events again.
+func (r *RespSlidingSync) toRespSync(userID string) *RespSync {
+	var res RespSync
+	res.NextBatch = r.Pos
+	res.AccountData.Events = r.Extensions.AccountData.Global
+	res.ToDevice.Events = r.Extensions.ToDevice.Events
+	res.DeviceLists = r.Extensions.E2EE.DeviceLists
+	res.DeviceOneTimeKeysCount = r.Extensions.E2EE.DeviceOneTimeKeysCount
+	res.DeviceUnusedFallbackKeyTypes = r.Extensions.E2EE.DeviceUnusedFallbackKeyTypes
+	res.Rooms.Join = make(map[string]Join)
+	res.Rooms.Invite = make(map[string]Invite)
+	res.Rooms.Knock = make(map[string]Knock)
+	res.Rooms.Leave = make(map[string]Leave)
+
+	for roomID, room := range r.Rooms {
+		timeline := room.Timeline
+		if room.Initial {
+			// The room has just entered a list or subscription, so only its live events are new. The state the
+			// older ones led to is in its required state.
+			if live := room.NumLive; live >= 0 && live < len(timeline) {
+				timeline = timeline[len(timeline)-live:]
+			}
+		}
+		switch membership := room.membership(userID); {
+		case len(room.InviteState) > 0 && membership == "knock":
+			var knock Knock
+			knock.State.Events = room.InviteState
+			res.Rooms.Knock[roomID] = knock
+		case len(room.InviteState) > 0:
+			var invite Invite
+			invite.State.Events = room.InviteState
+			res.Rooms.Invite[roomID] = invite
+		case membership == "leave" || membership == "ban":
+			var leave Leave
+			leave.AccountData.Events = r.Extensions.AccountData.Rooms[roomID]
+			leave.State.Events = room.RequiredState
+			leave.Timeline = Timeline{Events: timeline, Limited: room.Limited, PrevBatch: room.PrevBatch}
+			res.Rooms.Leave[roomID] = leave
+		default:
+			var join Join
+			join.AccountData.Events = r.Extensions.AccountData.Rooms[roomID]
+			if typing, ok := r.Extensions.Typing.Rooms[roomID]; ok {
+				join.Ephemeral.Events = append(join.Ephemeral.Events, typing)
+			}
+			if receipt, ok := r.Extensions.Receipts.Rooms[roomID]; ok {
+				join.Ephemeral.Events = append(join.Ephemeral.Events, receipt)
+			}
+			join.State.Events = room.RequiredState
+			for _, hero := range room.Heroes {
+				join.Summary.Heroes = append(join.Summary.Heroes, hero.UserID)
+			}
+			join.Summary.JoinedMemberCount = room.JoinedCount
+			join.Summary.InvitedMemberCount = room.InvitedCount
+			join.Timeline = Timeline{Events: timeline, Limited: room.Limited, PrevBatch: room.PrevBatch}
+			join.UnreadNotifications.HighLightCount = room.HighlightCount
+			join.UnreadNotifications.NotificationCount = room.NotificationCount
+			res.Rooms.Join[roomID] = join
+		}
+	}
+	return &res
+}
+
+// membership returns the most recent membership of userID in the room's timeline or state, or "" if there is none.
+func (room *SlidingSyncRoom) membership(userID string) string {
+	for _, events := range [][]Event{room.Timeline, room.RequiredState, room.InviteState} {
+		for i := len(events) - 1; i >= 0; i-- {
+			e := events[i]
+			if e.Type == "m.room.member" && e.StateKey != nil && *e.StateKey == userID {
+				if membership, ok := e.Content["membership"].(string); ok {
+					return membership
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSlidingSyncToRespSync(t *testing.T) {
+	var res RespSlidingSync
+	err := json.Unmarshal([]byte(`{
+		"pos": "5",
+		"rooms": {
+			"!joined:example.com": {
+				"heroes": [{"user_id": "@bob:example.com"}],
+				"joined_count": 2,
+				"required_state": [{"type": "m.room.create", "state_key": "", "content": {}}],
+				"timeline": [{"type": "m.room.message", "event_id": "$1", "content": {"body": "hi"}}]
+			},
+			"!invited:example.com": {
+				"invite_state": [{"type": "m.room.member", "state_key": "@alice:example.com", "content": {"membership": "invite"}}]
+			},
+			"!left:example.com": {
+				"timeline": [{"type": "m.room.member", "state_key": "@alice:example.com", "content": {"membership": "leave"}}]
+			}
+		},
+		"extensions": {
+			"typing": {"rooms": {"!joined:example.com": {"type": "m.typing", "content": {"user_ids": ["@bob:example.com"]}}}},
+			"to_device": {"next_batch": "td1", "events": [{"type": "m.room_key_request", "content": {}}]}
+		}
+	}`), &res)
+	if err != nil {
+		t.Fatalf("TestSlidingSyncToRespSync => Failed to unmarshal: %s", err)
+	}
+
+	sync := res.toRespSync("@alice:example.com")
+	if sync.NextBatch != "5" || len(sync.ToDevice.Events) != 1 {
+		t.Fatalf("TestSlidingSyncToRespSync => Got: next_batch=%s to_device=%d Expected: 5, 1", sync.NextBatch, len(sync.ToDevice.Events))
+	}
+	join, ok := sync.Rooms.Join["!joined:example.com"]
+	if !ok || len(join.State.Events) != 1 || len(join.Timeline.Events) != 1 || len(join.Ephemeral.Events) != 1 {
+		t.Fatalf("TestSlidingSyncToRespSync => Got: %+v Expected: a joined room with state, timeline and typing", join)
+	}
+	if len(join.Summary.Heroes) != 1 || join.Summary.JoinedMemberCount == nil || *join.Summary.JoinedMemberCount != 2 || join.Summary.InvitedMemberCount != nil {
+		t.Fatalf("TestSlidingSyncToRespSync => Got: %+v Expected: one hero and two joined members", join.Summary)
+	}
+	if _, ok := sync.Rooms.Invite["!invited:example.com"]; !ok {
+		t.Fatal("TestSlidingSyncToRespSync => Expected !invited:example.com in the invited rooms")
+	}
+	if _, ok := sync.Rooms.Leave["!left:example.com"]; !ok {
+		t.Fatal("TestSlidingSyncToRespSync => Expected !left:example.com in the left rooms")
+	}
+}
+
+func TestSlidingSync(t *testing.T) {
+	responses := map[int]string{
+		1: `{"pos":"1","lists":{"all":{"count":3}},"rooms":{"!room:example.com":{"initial":true,
+			"required_state":[{"type":"m.room.name","state_key":"","content":{"name":"Room"}}],
+			"timeline":[{"type":"m.room.message","event_id":"$0","content":{"body":"old"}}]
+		}},"extensions":{"to_device":{"next_batch":"td1"}}}`,
+		2: `{"pos":"2","rooms":{
+			"!room:example.com":{"timeline":[{"type":"m.room.message","event_id":"$1","content":{"body":"hi"}}]},
+			"!new:example.com":{"initial":true,"num_live":1,
+				"required_state":[{"type":"m.room.name","state_key":"","content":{"name":"New"}}],
+				"timeline":[
+					{"type":"m.room.message","event_id":"$old","content":{"body":"old"}},
+					{"type":"m.room.message","event_id":"$live","content":{"body":"live"}}
+				]},
+			"!left:example.com":{
+				"required_state":[{"type":"m.room.name","state_key":"","content":{"name":"Left"}}],
+				"timeline":[{"type":"m.room.member","state_key":"@alice:example.com","content":{"membership":"leave"}}]}
+		}}`,
+		3: `{"errcode":"M_UNKNOWN_POS","error":"Unknown position"}`,
+		4: `{"pos":"4","lists":{"all":{"count":3}},"rooms":{"!room:example.com":{"initial":true,
+			"timeline":[{"type":"m.room.message","event_id":"$1","content":{"body":"hi"}}]
+		}},"extensions":{"to_device":{"next_batch":"td4"}}}`,
+	}
+	var positions, since []string
+	var cli *Client
+	cli = newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/unstable/org.matrix.simplified_msc3575/sync" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req ReqSlidingSync
+		json.NewDecoder(r.Body).Decode(&req)
+		pos := r.URL.Query().Get("pos")
+		positions = append(positions, pos)
+		since = append(since, req.Extensions.ToDevice.Since)
+		if pos == "" && r.URL.Query().Get("timeout") != "0" {
+			t.Errorf("TestSlidingSync => Got timeout: %s Expected: 0 for the initial request", r.URL.Query().Get("timeout"))
+		}
+		res, ok := responses[len(positions)]
+		if !ok {
+			cli.StopSync()
+			res = `{"pos":"` + strconv.Itoa(len(positions)) + `"}`
+		} else if len(positions) == 3 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(res))
+	}))
+	var messages []string
+	cli.Syncer.(*DefaultSyncer).OnEventType("m.room.message", func(event *Event) { messages = append(messages, event.ID) })
+
+	ss := NewSlidingSync(cli)
+	ss.SetList("all", SlidingSyncList{Ranges: [][2]int{{0, 10}}})
+	if err := ss.Sync(context.Background()); err != nil {
+		t.Fatalf("TestSlidingSync => Sync: %s", err)
+	}
+	// The expired position 2 is reset, and the response received after StopSync is discarded.
+	if !reflect.DeepEqual(positions, []string{"", "1", "2", "", "4"}) || since[1] != "td1" {
+		t.Fatalf("TestSlidingSync => Got positions: %q to-device: %q Expected: [\"\" 1 2 \"\" 4] and td1 from the second request", positions, since)
+	}
+	// Initial responses and the history of rooms entering the list are not passed to listeners again.
+	sort.Strings(messages)
+	if !reflect.DeepEqual(messages, []string{"$1", "$live"}) || ss.ListCount("all") != 3 || ss.Pos() != "4" {
+		t.Fatalf("TestSlidingSync => Got: messages %v, count %d, pos %s Expected: [$1 $live], count 3, pos 4", messages, ss.ListCount("all"), ss.Pos())
+	}
+	for roomID, name := range map[string]string{"!room:example.com": "Room", "!new:example.com": "New", "!left:example.com": "Left"} {
+		if room := cli.Store.LoadRoom(roomID); room == nil || room.DisplayName(cli.UserID) != name {
+			t.Fatalf("TestSlidingSync => %s Got: %v Expected: the required state to be stored", roomID, room)
+		}
+	}
+	if pos := cli.Store.(SlidingSyncStorer).LoadSlidingSyncPos(cli.UserID, ""); pos != (SlidingSyncPos{Pos: "4", ToDeviceSince: "td4"}) {
+		t.Fatalf("TestSlidingSync => Got stored position: %+v Expected: 4 and td4", pos)
+	}
+
+	// A new connection resumes from the stored position.
+	if err := NewSlidingSync(cli).Sync(context.Background()); err != nil {
+		t.Fatalf("TestSlidingSync => Sync: %s", err)
+	}
+	if len(positions) != 6 || positions[5] != "4" || since[5] != "td4" {
+		t.Fatalf("TestSlidingSync => Got positions: %q to-device: %q Expected: the stored position 4 and td4 last", positions, since)
+	}
+}
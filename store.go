@@ -1,10 +1,16 @@
 package gomatrix
 
+import "sync"
+
 // Storer is an interface which must be satisfied to store client data.
 //
-// You can either write a struct which persists this data to disk, or you can use the
-// provided "InMemoryStore" which just keeps data around in-memory which is lost on
-// restarts.
+// You can either use the provided "FileStore" which persists this data to disk, write a
+// struct which persists it elsewhere, or use the provided "InMemoryStore" which just keeps
+// data around in-memory which is lost on restarts.
+//
+// The DefaultSyncer calls SaveRoom after applying the state changes of every sync response
+// to a room, so implementations may persist rooms there. Client.Sync calls SaveNextBatch only
+// once the response has been processed and every room in it saved.
 type Storer interface {
 	SaveFilterID(userID, filterID string)
 	LoadFilterID(userID string) string
@@ -14,44 +20,103 @@ type Storer interface {
 	LoadRoom(roomID string) *Room
 }
 
-// InMemoryStore implements the Storer interface.
+// SlidingSyncStorer is implemented by stores which can also persist the position of sliding sync connections, so
+// that SlidingSync can resume after a restart instead of syncing from scratch. SlidingSync saves the position only
+// once a response has been processed, like Client.Sync does with the next batch token.
+type SlidingSyncStorer interface {
+	SaveSlidingSyncPos(userID, connID string, pos SlidingSyncPos)
+	LoadSlidingSyncPos(userID, connID string) SlidingSyncPos
+}
+
+// SlidingSyncPos is the position of a sliding sync connection.
+type SlidingSyncPos struct {
+	Pos           string `json:"pos"`
+	ToDeviceSince string `json:"to_device_since,omitempty"` // The next batch token of the to-device extension.
+}
+
+// InMemoryStore implements the Storer and SlidingSyncStorer interfaces.
 //
-// Everything is persisted in-memory as maps. It is not safe to load/save filter IDs
-// or next batch tokens on any goroutine other than the syncing goroutine: the one
-// which called Client.Sync().
+// Everything is persisted in-memory as maps. It is safe to use the store from multiple
+// goroutines through its methods; accessing the maps directly is not synchronized.
 type InMemoryStore struct {
 	Filters   map[string]string
 	NextBatch map[string]string
 	Rooms     map[string]*Room
+
+	mu             sync.RWMutex
+	slidingSyncPos map[string]map[string]SlidingSyncPos
 }
 
 // SaveFilterID to memory.
 func (s *InMemoryStore) SaveFilterID(userID, filterID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.Filters[userID] = filterID
 }
 
 // LoadFilterID from memory.
 func (s *InMemoryStore) LoadFilterID(userID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.Filters[userID]
 }
 
 // SaveNextBatch to memory.
 func (s *InMemoryStore) SaveNextBatch(userID, nextBatchToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.NextBatch[userID] = nextBatchToken
 }
 
 // LoadNextBatch from memory.
 func (s *InMemoryStore) LoadNextBatch(userID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.NextBatch[userID]
 }
 
+// SaveSlidingSyncPos to memory.
+func (s *InMemoryStore) SaveSlidingSyncPos(userID, connID string, pos SlidingSyncPos) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slidingSyncPos == nil {
+		s.slidingSyncPos = make(map[string]map[string]SlidingSyncPos)
+	}
+	if s.slidingSyncPos[userID] == nil {
+		s.slidingSyncPos[userID] = make(map[string]SlidingSyncPos)
+	}
+	s.slidingSyncPos[userID][connID] = pos
+}
+
+// LoadSlidingSyncPos from memory.
+func (s *InMemoryStore) LoadSlidingSyncPos(userID, connID string) SlidingSyncPos {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.slidingSyncPos[userID][connID]
+}
+
 // SaveRoom to memory.
 func (s *InMemoryStore) SaveRoom(room *Room) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.Rooms[room.ID] = room
 }
 
+// LoadRooms returns a snapshot of all rooms in the store, keyed by room ID.
+func (s *InMemoryStore) LoadRooms() map[string]*Room {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rooms := make(map[string]*Room, len(s.Rooms))
+	for roomID, room := range s.Rooms {
+		rooms[roomID] = room
+	}
+	return rooms
+}
+
 // LoadRoom from memory.
 func (s *InMemoryStore) LoadRoom(roomID string) *Room {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.Rooms[roomID]
 }
 
@@ -0,0 +1,70 @@
+package gomatrix
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestInMemoryStoreConcurrentAccess is meant to be run with -race.
+func TestInMemoryStoreConcurrentAccess(t *testing.T) {
+	store := NewInMemoryStore()
+	syncer := NewDefaultSyncer("@alice:example.com", store)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			var res RespSync
+			res.NextBatch = fmt.Sprint(i)
+			stateKey := fmt.Sprintf("@user%d:example.com", i)
+			join := Join{}
+			join.State.Events = []Event{{
+				Type:     "m.room.member",
+				StateKey: &stateKey,
+				Content:  map[string]interface{}{"membership": "join"},
+			}}
+			res.Rooms.Join = map[string]Join{"!room:example.com": join}
+			if err := syncer.ProcessResponse(&res, "since"); err != nil {
+				t.Errorf("TestInMemoryStoreConcurrentAccess => ProcessResponse: %s", err)
+			}
+			store.SaveNextBatch("@alice:example.com", res.NextBatch)
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				store.LoadNextBatch("@alice:example.com")
+				for _, room := range store.LoadRooms() {
+					room.GetMembershipState("@user1:example.com")
+					for _, members := range room.State() {
+						_ = len(members)
+					}
+					_ = len(room.GetStateEvents("m.room.member"))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	room := store.LoadRoom("!room:example.com")
+	if got := len(room.GetStateEvents("m.room.member")); got != 100 {
+		t.Fatalf("TestInMemoryStoreConcurrentAccess => Got: %d members Expected: 100", got)
+	}
+}
+
+func TestRoomStateSnapshot(t *testing.T) {
+	room := NewRoom("!room:example.com")
+	stateKey := ""
+	room.UpdateState(&Event{Type: "m.room.name", StateKey: &stateKey, Content: map[string]interface{}{"name": "a"}})
+
+	snapshot := room.State()
+	delete(snapshot["m.room.name"], "")
+	if room.GetStateEvent("m.room.name", "") == nil {
+		t.Fatal("TestRoomStateSnapshot => modifying the snapshot changed the room's state")
+	}
+}
@@ -1,6 +1,7 @@
 package gomatrix
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"runtime/debug"
@@ -19,13 +20,43 @@ type Syncer interface {
 	GetFilterJSON(userID string) json.RawMessage
 }
 
+// ContextSyncer is a Syncer which may make requests while processing a response. Client.Sync and
+// SlidingSync.Sync call ProcessResponseContext instead of ProcessResponse, with the context they were called with.
+type ContextSyncer interface {
+	Syncer
+	ProcessResponseContext(ctx context.Context, resp *RespSync, since string) error
+}
+
+// processResponse passes the response to the syncer, along with ctx if it is a ContextSyncer.
+func processResponse(ctx context.Context, syncer Syncer, resp *RespSync, since string) error {
+	if s, ok := syncer.(ContextSyncer); ok {
+		return s.ProcessResponseContext(ctx, resp, since)
+	}
+	return syncer.ProcessResponse(resp, since)
+}
+
+// Decrypter decrypts end-to-end encrypted events for the DefaultSyncer. It is implemented by crypto.OlmMachine.
+// Both methods may make requests, which are bound to ctx.
+type Decrypter interface {
+	// ProcessSyncResponse is called with every sync response before it is processed, including the initial one,
+	// to handle to-device messages, device list changes and one-time key counts. If an error is returned, syncing
+	// will be stopped permanently.
+	ProcessSyncResponse(ctx context.Context, resp *RespSync, since string) error
+	// DecryptEvent decrypts an m.room.encrypted room event, returning the decrypted event.
+	DecryptEvent(ctx context.Context, event *Event) (*Event, error)
+}
+
 // DefaultSyncer is the default syncing implementation. You can either write your own syncer, or selectively
 // replace parts of this default syncer (e.g. the ProcessResponse method). The default syncer uses the observer
 // pattern to notify callers about incoming events. See DefaultSyncer.OnEventType for more information.
 type DefaultSyncer struct {
-	UserID    string
-	Store     Storer
-	listeners map[string][]OnEventListener // event type to listeners array
+	UserID string
+	Store  Storer
+	// Decrypter is optional. If set, encrypted timeline events are decrypted before listeners are notified.
+	Decrypter Decrypter
+	// AccountData is updated from every sync response, including the initial one.
+	AccountData *AccountDataCache
+	listeners   map[string][]OnEventListener // event type to listeners array
 }
 
 // OnEventListener can be used with DefaultSyncer.OnEventType to be informed of incoming events.
@@ -34,66 +65,152 @@ type OnEventListener func(*Event)
 // NewDefaultSyncer returns an instantiated DefaultSyncer
 func NewDefaultSyncer(userID string, store Storer) *DefaultSyncer {
 	return &DefaultSyncer{
-		UserID:    userID,
-		Store:     store,
-		listeners: make(map[string][]OnEventListener),
+		UserID:      userID,
+		Store:       store,
+		AccountData: NewAccountDataCache(),
+		listeners:   make(map[string][]OnEventListener),
 	}
 }
 
 // ProcessResponse processes the /sync response in a way suitable for bots. "Suitable for bots" means a stream of
 // unrepeating events. Returns a fatal error if a listener panics.
-func (s *DefaultSyncer) ProcessResponse(res *RespSync, since string) (err error) {
-	if !s.shouldProcessResponse(res, since) {
-		return
-	}
+//
+// If a Decrypter is set, m.room.encrypted timeline events which can be decrypted are passed to listeners as the
+// decrypted event; others are passed on unchanged.
+//
+// State events in the timeline update the state of the stored room, and m.room.redaction events in it are applied
+// to that state with Room.ApplyRedaction, before listeners are notified of them.
+//
+// The state and summary of every room are stored for every response, including the initial one. Listeners are not
+// notified of the events of the initial sync, which are not new, nor of those of rooms the user has just joined,
+// whose timeline may contain events that were processed before.
+func (s *DefaultSyncer) ProcessResponse(res *RespSync, since string) error {
+	return s.ProcessResponseContext(context.Background(), res, since)
+}
 
+// ProcessResponseContext implements ContextSyncer. It is ProcessResponse, with the requests of the Decrypter bound
+// to ctx.
+func (s *DefaultSyncer) ProcessResponseContext(ctx context.Context, res *RespSync, since string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("ProcessResponse panicked! userID=%s since=%s panic=%s\n%s", s.UserID, since, r, debug.Stack())
 		}
 	}()
 
+	if s.Decrypter != nil {
+		if err = s.Decrypter.ProcessSyncResponse(ctx, res, since); err != nil {
+			return
+		}
+	}
+	if s.AccountData != nil {
+		s.AccountData.Update(res)
+	}
+	notifyAll, skipped := s.shouldNotify(res, since)
+
+	if notifyAll {
+		for _, event := range res.AccountData.Events {
+			event := event
+			s.notifyListeners(&event)
+		}
+	}
+
+	// Each event is copied out of the loop variable, which is shared between iterations, before a pointer to it is
+	// stored or passed to listeners.
 	for roomID, roomData := range res.Rooms.Join {
 		room := s.getOrCreateRoom(roomID)
+		notify := notifyAll && !skipped[roomID]
 		for _, event := range roomData.State.Events {
+			event := event
 			event.RoomID = roomID
 			room.UpdateState(&event)
-			s.notifyListeners(&event)
+			if notify {
+				s.notifyListeners(&event)
+			}
 		}
 		for _, event := range roomData.Timeline.Events {
+			event := event
 			event.RoomID = roomID
-			s.notifyListeners(&event)
+			if event.StateKey != nil {
+				room.UpdateState(&event)
+			} else if event.Type == "m.room.redaction" {
+				room.ApplyRedaction(&event)
+			}
+			if notify {
+				s.notifyListeners(s.decrypt(ctx, &event))
+			}
+		}
+		room.UpdateSummary(roomData.Summary)
+		s.Store.SaveRoom(room)
+		if !notify {
+			continue
 		}
 		for _, event := range roomData.Ephemeral.Events {
+			event := event
+			event.RoomID = roomID
+			s.notifyListeners(&event)
+		}
+		for _, event := range roomData.AccountData.Events {
+			event := event
 			event.RoomID = roomID
 			s.notifyListeners(&event)
 		}
 	}
 	for roomID, roomData := range res.Rooms.Invite {
 		room := s.getOrCreateRoom(roomID)
+		notify := notifyAll && !skipped[roomID]
 		for _, event := range roomData.State.Events {
+			event := event
 			event.RoomID = roomID
 			room.UpdateState(&event)
-			s.notifyListeners(&event)
+			if notify {
+				s.notifyListeners(&event)
+			}
 		}
+		s.Store.SaveRoom(room)
 	}
 	for roomID, roomData := range res.Rooms.Knock {
 		room := s.getOrCreateRoom(roomID)
 		for _, event := range roomData.State.Events {
+			event := event
 			event.RoomID = roomID
 			room.UpdateState(&event)
-			s.notifyListeners(&event)
+			if notifyAll {
+				s.notifyListeners(&event)
+			}
 		}
+		s.Store.SaveRoom(room)
 	}
 	for roomID, roomData := range res.Rooms.Leave {
 		room := s.getOrCreateRoom(roomID)
+		for _, event := range roomData.State.Events {
+			event := event
+			event.RoomID = roomID
+			room.UpdateState(&event)
+			if notifyAll {
+				s.notifyListeners(&event)
+			}
+		}
 		for _, event := range roomData.Timeline.Events {
+			event := event
 			if event.StateKey != nil {
 				event.RoomID = roomID
 				room.UpdateState(&event)
+				if notifyAll {
+					s.notifyListeners(&event)
+				}
+			} else if event.Type == "m.room.redaction" {
+				event.RoomID = roomID
+				room.ApplyRedaction(&event)
+			}
+		}
+		if notifyAll {
+			for _, event := range roomData.AccountData.Events {
+				event := event
+				event.RoomID = roomID
 				s.notifyListeners(&event)
 			}
 		}
+		s.Store.SaveRoom(room)
 	}
 	return
 }
@@ -108,19 +225,33 @@ func (s *DefaultSyncer) OnEventType(eventType string, callback OnEventListener)
 	s.listeners[eventType] = append(s.listeners[eventType], callback)
 }
 
-// shouldProcessResponse returns true if the response should be processed. May modify the response to remove
-// stuff that shouldn't be processed.
-func (s *DefaultSyncer) shouldProcessResponse(resp *RespSync, since string) bool {
+// decrypt returns the decrypted event if it is encrypted and can be decrypted, or the event itself otherwise.
+func (s *DefaultSyncer) decrypt(ctx context.Context, event *Event) *Event {
+	if s.Decrypter == nil || event.Type != "m.room.encrypted" {
+		return event
+	}
+	decrypted, err := s.Decrypter.DecryptEvent(ctx, event)
+	if err != nil {
+		return event
+	}
+	return decrypted
+}
+
+// shouldNotify reports whether listeners should be notified of the events in the response, which they are not
+// for the initial sync, and returns the rooms whose events they should not be notified of even then.
+func (s *DefaultSyncer) shouldNotify(resp *RespSync, since string) (bool, map[string]bool) {
 	if since == "" {
-		return false
+		return false, nil
 	}
 	// This is a horrible hack because /sync will return the most recent messages for a room
 	// as soon as you /join it. We do NOT want to process those events in that particular room
 	// because they may have already been processed (if you toggle the bot in/out of the room).
 	//
 	// Work around this by inspecting each room's timeline and seeing if an m.room.member event for us
-	// exists and is "join" and then discard processing that room entirely if so.
+	// exists and is "join" and then skip notifying listeners of that room entirely if so. Its state is
+	// still stored.
 	// TODO: We probably want to process messages from after the last join event in the timeline.
+	skipped := make(map[string]bool)
 	for roomID, roomData := range resp.Rooms.Join {
 		for i := len(roomData.Timeline.Events) - 1; i >= 0; i-- {
 			e := roomData.Timeline.Events[i]
@@ -131,18 +262,13 @@ func (s *DefaultSyncer) shouldProcessResponse(resp *RespSync, since string) bool
 					continue
 				}
 				if mship == "join" {
-					_, ok := resp.Rooms.Join[roomID]
-					if !ok {
-						continue
-					}
-					delete(resp.Rooms.Join, roomID)   // don't re-process messages
-					delete(resp.Rooms.Invite, roomID) // don't re-process invites
+					skipped[roomID] = true // don't re-process messages or invites
 					break
 				}
 			}
 		}
 	}
-	return true
+	return true, skipped
 }
 
 // getOrCreateRoom must only be called by the Sync() goroutine which calls ProcessResponse()
@@ -0,0 +1,79 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDefaultSyncerStoresState(t *testing.T) {
+	store := NewInMemoryStore()
+	syncer := NewDefaultSyncer("@alice:example.com", store)
+	var res RespSync
+	err := json.Unmarshal([]byte(`{"rooms":{"join":{"!room:example.com":{
+		"state":{"events":[
+			{"type":"m.room.name","state_key":"","content":{"name":"Room"}},
+			{"type":"m.room.topic","state_key":"","content":{"topic":"Topic"}}
+		]},
+		"timeline":{"events":[
+			{"type":"m.room.topic","state_key":"","content":{"topic":"New topic"}},
+			{"type":"m.room.message","content":{"msgtype":"m.text","body":"hello"}}
+		]}
+	}}}}`), &res)
+	if err != nil {
+		t.Fatalf("TestDefaultSyncerStoresState => Failed to unmarshal: %s", err)
+	}
+	if err = syncer.ProcessResponse(&res, "since"); err != nil {
+		t.Fatalf("TestDefaultSyncerStoresState => ProcessResponse: %s", err)
+	}
+	room := store.LoadRoom("!room:example.com")
+	if name := room.GetStateEvent("m.room.name", ""); name == nil || name.Content["name"] != "Room" {
+		t.Fatalf("TestDefaultSyncerStoresState => Got: %+v Expected: the m.room.name event", name)
+	}
+	if topic := room.GetStateEvent("m.room.topic", ""); topic == nil || topic.Content["topic"] != "New topic" {
+		t.Fatalf("TestDefaultSyncerStoresState => Got: %+v Expected: the m.room.topic event from the timeline", topic)
+	}
+}
+
+func TestDefaultSyncerNotifiesOnlyNewEvents(t *testing.T) {
+	store := NewInMemoryStore()
+	syncer := NewDefaultSyncer("@alice:example.com", store)
+	var messages []string
+	syncer.OnEventType("m.room.message", func(event *Event) { messages = append(messages, event.RoomID) })
+	process := func(since, raw string) {
+		var res RespSync
+		if err := json.Unmarshal([]byte(raw), &res); err != nil {
+			t.Fatalf("TestDefaultSyncerNotifiesOnlyNewEvents => Failed to unmarshal: %s", err)
+		}
+		if err := syncer.ProcessResponse(&res, since); err != nil {
+			t.Fatalf("TestDefaultSyncerNotifiesOnlyNewEvents => ProcessResponse: %s", err)
+		}
+	}
+
+	// The initial sync is stored, but its events are not new.
+	process("", `{"rooms":{"join":{"!a:example.com":{
+		"state":{"events":[{"type":"m.room.name","state_key":"","content":{"name":"A"}}]},
+		"timeline":{"events":[{"type":"m.room.message","content":{"body":"old"}}]}
+	}}}}`)
+	room := store.LoadRoom("!a:example.com")
+	if room == nil || room.GetStateEvent("m.room.name", "") == nil || room.DisplayName("@alice:example.com") != "A" {
+		t.Fatal("TestDefaultSyncerNotifiesOnlyNewEvents => Got: no state after the initial sync Expected: the room's name")
+	}
+	if len(messages) != 0 {
+		t.Fatalf("TestDefaultSyncerNotifiesOnlyNewEvents => Got: %v Expected: no messages from the initial sync", messages)
+	}
+
+	// A room the user has just joined is stored, but only the other room's messages are passed on.
+	process("s1", `{"rooms":{"join":{
+		"!a:example.com":{"timeline":{"events":[{"type":"m.room.message","content":{"body":"new"}}]}},
+		"!b:example.com":{"timeline":{"events":[
+			{"type":"m.room.member","state_key":"@alice:example.com","content":{"membership":"join"}},
+			{"type":"m.room.message","content":{"body":"before"}}
+		]}}
+	}}}`)
+	if len(messages) != 1 || messages[0] != "!a:example.com" {
+		t.Fatalf("TestDefaultSyncerNotifiesOnlyNewEvents => Got: %v Expected: [!a:example.com]", messages)
+	}
+	if room := store.LoadRoom("!b:example.com"); room == nil || room.GetMembershipState("@alice:example.com") != "join" {
+		t.Fatal("TestDefaultSyncerNotifiesOnlyNewEvents => Got: no state Expected: the joined room to be stored")
+	}
+}
@@ -14,6 +14,13 @@
 
 package gomatrix
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // TagContent contains the data for an m.tag message type
 // https://matrix.org/docs/spec/client_server/r0.4.0.html#m-tag
 type TagContent struct {
@@ -24,3 +31,90 @@ type TagContent struct {
 type TagProperties struct {
 	Order float32 `json:"order,omitempty"` // Empty values must be neglected
 }
+
+// Tag names defined by the spec - https://spec.matrix.org/v1.1/client-server-api/#room-tagging
+const (
+	TagFavourite    = "m.favourite"
+	TagLowPriority  = "m.lowpriority"
+	TagServerNotice = "m.server_notice"
+)
+
+// ErrInvalidTagName is returned when a tag name is neither a tag defined by the spec nor a user tag.
+var ErrInvalidTagName = errors.New("invalid tag name")
+
+// ValidateTagName returns an error wrapping ErrInvalidTagName unless the tag is m.favourite, m.lowpriority,
+// m.server_notice or a user-defined u.* tag.
+func ValidateTagName(tag string) error {
+	switch {
+	case tag == TagFavourite, tag == TagLowPriority, tag == TagServerNotice:
+		return nil
+	case strings.HasPrefix(tag, "u.") && len(tag) > len("u."):
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidTagName, tag)
+}
+
+// RoomTags returns the tags of every room with m.tag account data in the cache, by room ID.
+func (c *AccountDataCache) RoomTags() map[string]TagContent {
+	c.mu.RLock()
+	events := make(map[string]*Event, len(c.rooms))
+	for roomID, data := range c.rooms {
+		if event := data["m.tag"]; event != nil {
+			events[roomID] = event
+		}
+	}
+	c.mu.RUnlock()
+
+	tags := make(map[string]TagContent, len(events))
+	for roomID, event := range events {
+		if content, err := event.ParseContent(); err == nil {
+			if tag, ok := content.(*TagContent); ok {
+				tags[roomID] = *tag
+			}
+		}
+	}
+	return tags
+}
+
+// RoomsWithTag returns the IDs of the rooms with the given tag, sorted by the tag's Order and then by room ID.
+func RoomsWithTag(tags map[string]TagContent, tag string) []string {
+	var roomIDs []string
+	for roomID, content := range tags {
+		if _, ok := content.Tags[tag]; ok {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	sortByTagOrder(roomIDs, tags, tag)
+	return roomIDs
+}
+
+// OrderRooms sorts a room list the way users organise it: favourites first, sorted by their Order, then rooms
+// without either tag in their original order, then low priority rooms sorted by their Order.
+func OrderRooms(roomIDs []string, tags map[string]TagContent) []string {
+	var favourites, normal, lowPriority []string
+	for _, roomID := range roomIDs {
+		if _, ok := tags[roomID].Tags[TagFavourite]; ok {
+			favourites = append(favourites, roomID)
+		} else if _, ok := tags[roomID].Tags[TagLowPriority]; ok {
+			lowPriority = append(lowPriority, roomID)
+		} else {
+			normal = append(normal, roomID)
+		}
+	}
+	sortByTagOrder(favourites, tags, TagFavourite)
+	sortByTagOrder(lowPriority, tags, TagLowPriority)
+	ordered := make([]string, 0, len(roomIDs))
+	ordered = append(ordered, favourites...)
+	ordered = append(ordered, normal...)
+	return append(ordered, lowPriority...)
+}
+
+func sortByTagOrder(roomIDs []string, tags map[string]TagContent, tag string) {
+	sort.SliceStable(roomIDs, func(i, j int) bool {
+		a, b := tags[roomIDs[i]].Tags[tag].Order, tags[roomIDs[j]].Tags[tag].Order
+		if a != b {
+			return a < b
+		}
+		return roomIDs[i] < roomIDs[j]
+	})
+}
@@ -0,0 +1,40 @@
+package gomatrix
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateTagName(t *testing.T) {
+	for tag, valid := range map[string]bool{
+		TagFavourite:    true,
+		TagLowPriority:  true,
+		TagServerNotice: true,
+		"u.work":        true,
+		"u.":            false,
+		"m.unknown":     false,
+		"work":          false,
+	} {
+		if err := ValidateTagName(tag); (err == nil) != valid || (err != nil && !errors.Is(err, ErrInvalidTagName)) {
+			t.Fatalf("TestValidateTagName => %q Got: %v Expected valid: %v", tag, err, valid)
+		}
+	}
+}
+
+func TestOrderRooms(t *testing.T) {
+	tags := map[string]TagContent{
+		"!fav2": {Tags: map[string]TagProperties{TagFavourite: {Order: 0.5}}},
+		"!fav1": {Tags: map[string]TagProperties{TagFavourite: {Order: 0.1}, "u.work": {}}},
+		"!low":  {Tags: map[string]TagProperties{TagLowPriority: {}}},
+		"!work": {Tags: map[string]TagProperties{"u.work": {Order: 0.2}}},
+	}
+	got := OrderRooms([]string{"!low", "!b", "!fav2", "!a", "!work", "!fav1"}, tags)
+	expected := []string{"!fav1", "!fav2", "!b", "!a", "!work", "!low"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("TestOrderRooms => Got: %v Expected: %v", got, expected)
+	}
+	if got := RoomsWithTag(tags, "u.work"); !reflect.DeepEqual(got, []string{"!fav1", "!work"}) {
+		t.Fatalf("TestOrderRooms => RoomsWithTag Got: %v Expected: [!fav1 !work]", got)
+	}
+}
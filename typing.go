@@ -0,0 +1,85 @@
+package gomatrix
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultTypingTimeout is the typing timeout used by KeepTyping if none is given.
+const DefaultTypingTimeout = 30 * time.Second
+
+// KeepTyping marks the current user as typing in the room until the context is done, refreshing the typing state
+// before it times out, and then clears it. It blocks until the typing state has been cleared, so it is usually run
+// in its own goroutine alongside a long-running task:
+//
+//	ctx, cancel := context.WithCancel(ctx)
+//	go cli.KeepTyping(ctx, roomID, 0)
+//	answer := compute(ctx)
+//	cancel()
+//
+// Failed refreshes are retried on the next refresh. Returns the error from clearing the typing state, if any.
+func (cli *Client) KeepTyping(ctx context.Context, roomID string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultTypingTimeout
+	}
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		cli.UserTyping(ctx, roomID, true, timeout)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// The context is done, so clear the typing state with a new one.
+			clearCtx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return cli.UserTyping(clearCtx, roomID, false, 0)
+		}
+	}
+}
+
+// TypingTracker keeps track of the users who are currently typing in each room, as reported by m.typing events.
+// Register it with a DefaultSyncer to keep it up to date:
+//
+//	typing := gomatrix.NewTypingTracker()
+//	syncer.OnEventType("m.typing", typing.Handle)
+//
+// It is safe to use the tracker from multiple goroutines.
+type TypingTracker struct {
+	mu    sync.RWMutex
+	rooms map[string][]string
+}
+
+// NewTypingTracker returns an empty TypingTracker.
+func NewTypingTracker() *TypingTracker {
+	return &TypingTracker{rooms: make(map[string][]string)}
+}
+
+// Handle updates the tracker from an m.typing event. It is an OnEventListener.
+func (t *TypingTracker) Handle(event *Event) {
+	if event.Type != "m.typing" || event.RoomID == "" {
+		return
+	}
+	content, err := event.ParseContent()
+	if err != nil {
+		return
+	}
+	typing, ok := content.(*TypingContent)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(typing.UserIDs) == 0 {
+		delete(t.rooms, event.RoomID)
+		return
+	}
+	t.rooms[event.RoomID] = append([]string(nil), typing.UserIDs...)
+}
+
+// TypingUsers returns the IDs of the users currently typing in the room.
+func (t *TypingTracker) TypingUsers(roomID string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]string(nil), t.rooms[roomID]...)
+}
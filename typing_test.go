@@ -0,0 +1,48 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeepTyping(t *testing.T) {
+	var mu sync.Mutex
+	var states []bool
+	cli := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ReqTyping
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		states = append(states, req.Typing)
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	if err := cli.KeepTyping(ctx, "!room:example.com", 200*time.Millisecond); err != nil {
+		t.Fatalf("TestKeepTyping => KeepTyping: %s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) < 3 || !states[0] || !states[1] || states[len(states)-1] {
+		t.Fatalf("TestKeepTyping => Got: %v Expected: at least two refreshes followed by a clear", states)
+	}
+}
+
+func TestTypingTracker(t *testing.T) {
+	tracker := NewTypingTracker()
+	tracker.Handle(&Event{Type: "m.typing", RoomID: "!room:example.com", Content: map[string]interface{}{
+		"user_ids": []interface{}{"@alice:example.com", "@bob:example.com"},
+	}})
+	if got := tracker.TypingUsers("!room:example.com"); len(got) != 2 {
+		t.Fatalf("TestTypingTracker => Got: %v Expected: 2 users", got)
+	}
+	tracker.Handle(&Event{Type: "m.typing", RoomID: "!room:example.com", Content: map[string]interface{}{"user_ids": []interface{}{}}})
+	if got := tracker.TypingUsers("!room:example.com"); len(got) != 0 {
+		t.Fatalf("TestTypingTracker => Got: %v Expected: no users", got)
+	}
+}